@@ -0,0 +1,117 @@
+package decompress
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// preferences is the registered preferences of the content codings, that are advertised by the Accept-Encoding header
+var preferences = map[string]float64{
+	"gzip":    1,
+	"deflate": 1,
+}
+
+// SetPreference sets the preference of the content coding name, that is the qvalue (weight) of the coding in the
+// Accept-Encoding header generated by RoundTripper. The codings are advertised in descending order of the preference,
+// e.g. set 0.5 to `gzip` to prefer `br` and `zstd` over it. A preference of 0 stops advertising the coding.
+// Only the codings with a preference are advertised, and `gzip` and `deflate` have the preference 1 by default.
+// q is rounded to 3 decimal places, that is the precision of the qvalues (RFC 9110 Section 12.4.2).
+// SetPreference is safe for concurrent use.
+// If q is not in the range of 0 to 1, SetPreference panics
+func SetPreference(name string, q float64) {
+	q, ok := qvalue(q)
+	if !ok {
+		panic(fmt.Sprintf("decompress: SetPreference invalid preference %v for %s", q, name))
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	preferences[strings.ToLower(name)] = q
+}
+
+// qvalue returns q rounded to 3 decimal places, and reports whether q is in the range of 0 to 1.
+// Refs RFC 9110 Section 12.4.2
+func qvalue(q float64) (float64, bool) {
+	if !(q >= 0 && q <= 1) {
+		return q, false
+	}
+	return math.Round(q*1000) / 1000, true
+}
+
+// AcceptEncoding returns the value of the Accept-Encoding header, that advertises the supported content codings
+// in the order of the preferences, e.g. `zstd, br, gzip;q=0.5`.
+// Preferences takes precedence over the preferences set by SetPreference, and the codings without a decoder are omitted
+func (r *RoundTripper) AcceptEncoding() string {
+	return r.acceptEncoding(context.Background())
+}
+
+func (r *RoundTripper) acceptEncoding(ctx context.Context) string {
+	qs := make(map[string]float64)
+	registryMu.RLock()
+	for name, q := range preferences {
+		qs[name] = q
+	}
+	registryMu.RUnlock()
+	for name, q := range r.Preferences {
+		// the codings with an invalid preference are not advertised
+		q, _ = qvalue(q)
+		qs[strings.ToLower(name)] = q
+	}
+	type coding struct {
+		name string
+		q    float64
+	}
+	var codings []coding
+	for name, q := range qs {
+		if !(q > 0 && q <= 1) {
+			continue
+		}
+		if _, ok := r.decoder(ctx, name, nil); !ok {
+			continue
+		}
+		codings = append(codings, coding{name: name, q: q})
+	}
+	sort.Slice(codings, func(i, j int) bool {
+		if codings[i].q != codings[j].q {
+			return codings[i].q > codings[j].q
+		}
+		return codings[i].name < codings[j].name
+	})
+	var b strings.Builder
+	for i, c := range codings {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(c.name)
+		if c.q < 1 {
+			b.WriteString(";q=")
+			b.WriteString(strconv.FormatFloat(c.q, 'f', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+// advertise returns the request with the Accept-Encoding header generated by acceptEncoding, if req does not have the header.
+// It also returns the names of the advertised content codings, or nil if the header is not set
+func (r *RoundTripper) advertise(ctx context.Context, req *http.Request) (*http.Request, []string) {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return req, nil
+	}
+	ae := r.acceptEncoding(ctx)
+	if ae == "" {
+		return req, nil
+	}
+	// RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", ae)
+	var names []string
+	for _, c := range strings.Split(ae, ", ") {
+		name, _, _ := strings.Cut(c, ";")
+		names = append(names, name)
+	}
+	return req, names
+}
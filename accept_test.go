@@ -0,0 +1,214 @@
+package decompress_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_AcceptEncoding(t *testing.T) {
+	tt := []struct {
+		title       string
+		preferences map[string]float64
+		decoders    map[string]decompress.DecoderFactory
+		want        string
+	}{
+		{
+			title: "default",
+			want:  "br, deflate, gzip, zstd",
+		},
+		{
+			title:       "prefer zstd",
+			preferences: map[string]float64{"zstd": 1, "br": 0.9, "gzip": 0.5, "deflate": 0.25},
+			want:        "zstd, br;q=0.9, gzip;q=0.5, deflate;q=0.25",
+		},
+		{
+			title:       "disabled",
+			preferences: map[string]float64{"deflate": 0, "br": 0},
+			want:        "gzip, zstd",
+		},
+		{
+			title:       "without decoder",
+			preferences: map[string]float64{"x-unknown": 1},
+			want:        "br, deflate, gzip, zstd",
+		},
+		{
+			title:       "rounded",
+			preferences: map[string]float64{"gzip": 0.33333, "deflate": 0.0004},
+			want:        "br, zstd, gzip;q=0.333",
+		},
+		{
+			title:       "invalid",
+			preferences: map[string]float64{"gzip": 1.5, "deflate": -1, "br": math.NaN()},
+			want:        "zstd",
+		},
+		{
+			title:       "RoundTripper decoder",
+			preferences: map[string]float64{"x-rot13-local": 0.1},
+			decoders:    map[string]decompress.DecoderFactory{"x-rot13-local": decompress.DecoderFunc(newROT13Reader)},
+			want:        "br, deflate, gzip, zstd, x-rot13-local;q=0.1",
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{Preferences: te.preferences, Decoders: te.decoders}
+			if got, want := dr.AcceptEncoding(), te.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetPreference(t *testing.T) {
+	defer decompress.SetPreference("x-rot13-preference", 0)
+	dr := decompress.RoundTripper{
+		Preferences: map[string]float64{"gzip": 0, "deflate": 0, "br": 0, "zstd": 0},
+		Decoders:    map[string]decompress.DecoderFactory{"x-rot13-preference": decompress.DecoderFunc(newROT13Reader)},
+	}
+	decompress.SetPreference("x-rot13-preference", 0.66666)
+	if got, want := dr.AcceptEncoding(), "x-rot13-preference;q=0.667"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, q := range []float64{-0.1, 1.1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("SetPreference(%v) does not panic", q)
+				}
+			}()
+			decompress.SetPreference("x-rot13-preference", q)
+		}()
+	}
+}
+
+func TestRoundTripper_RoundTrip_AdvertiseEncodings(t *testing.T) {
+	tt := []struct {
+		title     string
+		advertise bool
+		header    string
+		want      string
+	}{
+		{title: "advertise", advertise: true, want: "br, deflate, gzip, zstd"},
+		{title: "explicit header", advertise: true, header: "gzip", want: "gzip"},
+		{title: "disabled", want: ""},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var got string
+			dr := decompress.RoundTripper{
+				Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					got = req.Header.Get("Accept-Encoding")
+					return newResponse(t, []byte("foobarbaz"), ""), nil
+				}),
+				AdvertiseEncodings: te.advertise,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			if te.header != "" {
+				req.Header.Set("Accept-Encoding", te.header)
+			}
+			if _, err := dr.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if want := te.want; got != want {
+				t.Errorf("Accept-Encoding got %v, want %v", got, want)
+			}
+			if got, want := req.Header.Get("Accept-Encoding"), te.header; got != want {
+				t.Errorf("original request modified: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_StrictAdvertised(t *testing.T) {
+	tt := []struct {
+		title           string
+		opts            []decompress.Option
+		header          string
+		contentEncoding string
+		body            []byte
+		wantBody        string
+		wantUnsolicited bool
+		wantPassThrough bool
+	}{
+		{
+			title:           "advertised",
+			opts:            []decompress.Option{decompress.WithAdvertiseEncodings()},
+			contentEncoding: "gzip",
+			body:            gzipBytes([]byte("foobarbaz")),
+			wantBody:        "foobarbaz",
+		},
+		{
+			title:           "advertised alias",
+			opts:            []decompress.Option{decompress.WithAdvertiseEncodings()},
+			contentEncoding: "x-gzip",
+			body:            gzipBytes([]byte("foobarbaz")),
+			wantBody:        "foobarbaz",
+		},
+		{
+			title:           "not advertised",
+			opts:            []decompress.Option{decompress.WithAdvertiseEncodings(), decompress.WithPreference("deflate", 0)},
+			contentEncoding: "deflate",
+			body:            deflateBytes([]byte("foobarbaz")),
+			wantUnsolicited: true,
+		},
+		{
+			title:           "explicit header",
+			opts:            []decompress.Option{decompress.WithAdvertiseEncodings()},
+			header:          "gzip",
+			contentEncoding: "gzip",
+			body:            gzipBytes([]byte("foobarbaz")),
+			wantUnsolicited: true,
+		},
+		{
+			title:           "pass through",
+			opts:            []decompress.Option{decompress.WithPassThroughUnsupported()},
+			contentEncoding: "gzip",
+			body:            gzipBytes([]byte("foobarbaz")),
+			wantPassThrough: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			opts := append([]decompress.Option{
+				decompress.WithTransport(&stubRoundTripper{response: newResponse(t, te.body, te.contentEncoding)}),
+				decompress.WithStrictAdvertised(),
+			}, te.opts...)
+			dr := decompress.New(opts...)
+			req, _ := http.NewRequest("GET", "/", nil)
+			if te.header != "" {
+				req.Header.Set("Accept-Encoding", te.header)
+			}
+			resp, err := dr.RoundTrip(req)
+			if te.wantUnsolicited {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Fatalf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				if !wantErr.Unsolicited {
+					t.Errorf("Unsolicited got false, want true")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBody := te.wantBody
+			if te.wantPassThrough {
+				wantBody = string(te.body)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,161 @@
+package decompress
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// aes128gcm implements a reader for the `aes128gcm` content coding. Refs RFC 8188
+
+const (
+	aes128gcmSaltSize   = 16
+	aes128gcmKeySize    = 16
+	aes128gcmNonceSize  = 12
+	aes128gcmTagSize    = 16
+	aes128gcmMinRecord  = aes128gcmTagSize + 2
+	aes128gcmHeaderSize = aes128gcmSaltSize + 4 + 1
+	// aes128gcmMaxRecord is the max record size accepted, since the buffer of a record is allocated by the size in
+	// the header before the limits of the decompressed body apply. The senders use 4096 bytes typically
+	aes128gcmMaxRecord = 1 << 20
+)
+
+var (
+	errAES128GCMTruncated = errors.New("decompress: truncated aes128gcm data")
+	errAES128GCMPadding   = errors.New("decompress: invalid aes128gcm padding")
+)
+
+type aes128gcmReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	nonce  []byte
+	rs     int
+	seq    uint64
+	record []byte
+	out    []byte
+	last   bool
+	err    error
+}
+
+// newAES128GCMReader reads the header of the aes128gcm format and returns the reader for the decrypted data.
+// key returns the input keying material for the key ID in the header
+func newAES128GCMReader(r io.Reader, key func(keyID []byte) ([]byte, error)) (*aes128gcmReader, error) {
+	header := make([]byte, aes128gcmHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	salt := header[:aes128gcmSaltSize]
+	rs := binary.BigEndian.Uint32(header[aes128gcmSaltSize:])
+	if rs < aes128gcmMinRecord || rs > aes128gcmMaxRecord {
+		return nil, fmt.Errorf("decompress: invalid aes128gcm record size %d", rs)
+	}
+	keyID := make([]byte, header[aes128gcmHeaderSize-1])
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	ikm, err := key(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("decompress: get aes128gcm key: %w", err)
+	}
+	prk := hkdfHMAC(salt, ikm)
+	cek := hkdfHMAC(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:aes128gcmKeySize]
+	nonce := hkdfHMAC(prk, []byte("Content-Encoding: nonce\x00\x01"))[:aes128gcmNonceSize]
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aes128gcmReader{
+		r:      r,
+		aead:   aead,
+		nonce:  nonce,
+		rs:     int(rs),
+		record: make([]byte, rs),
+	}, nil
+}
+
+func (a *aes128gcmReader) Read(p []byte) (int, error) {
+	for len(a.out) == 0 && a.err == nil {
+		a.decrypt()
+	}
+	if len(a.out) > 0 {
+		n := copy(p, a.out)
+		a.out = a.out[n:]
+		return n, nil
+	}
+	return 0, a.err
+}
+
+// decrypt reads and decrypts a record, and stores the plaintext into a.out
+func (a *aes128gcmReader) decrypt() {
+	n, err := io.ReadFull(a.r, a.record)
+	switch {
+	case err == io.EOF:
+		if !a.last {
+			a.err = errAES128GCMTruncated
+			return
+		}
+		a.err = io.EOF
+		return
+	case err == io.ErrUnexpectedEOF:
+	case err != nil:
+		a.err = err
+		return
+	}
+	if a.last {
+		a.err = errors.New("decompress: aes128gcm data after the last record")
+		return
+	}
+	nonce := make([]byte, aes128gcmNonceSize)
+	copy(nonce, a.nonce)
+	for i := 0; i < 8; i++ {
+		nonce[aes128gcmNonceSize-1-i] ^= byte(a.seq >> (8 * i))
+	}
+	a.seq++
+	plain, err := a.aead.Open(a.record[:0], nonce, a.record[:n], nil)
+	if err != nil {
+		a.err = fmt.Errorf("decompress: decrypt aes128gcm record: %w", err)
+		return
+	}
+	plain = bytes.TrimRight(plain, "\x00")
+	if len(plain) == 0 {
+		a.err = errAES128GCMPadding
+		return
+	}
+	switch plain[len(plain)-1] {
+	case 0x01:
+		if n < a.rs {
+			a.err = errAES128GCMTruncated
+			return
+		}
+	case 0x02:
+		a.last = true
+	default:
+		a.err = errAES128GCMPadding
+		return
+	}
+	a.out = plain[:len(plain)-1]
+}
+
+// hkdfHMAC returns HMAC-SHA-256(key, data), that is the HKDF-Extract with the salt key, or the first block of
+// the HKDF-Expand when data ends with 0x01. Refs RFC 5869
+func hkdfHMAC(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,203 @@
+package decompress_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_RoundTrip_AES128GCM(t *testing.T) {
+	keys := map[string][]byte{
+		"":   []byte("0123456789abcdef"),
+		"a1": b64(t, "BO3ZVPxUlnLORbVGMpbT1Q"),
+	}
+	single := aes128gcmBytes([]byte("I am the walrus"), keys[""], nil, 4096)
+	// test vector from RFC 8188 Section 3.2
+	multiple := b64(t, "uNCkWiNYzKTnBN9ji3-qWAAAABkCYTHOG8chz_gnvgOqdGYovxyjuqRyJFjEDyoF1Fvkj6hQPdPHI51OEUKEpgz3SsLWIqS_uA")
+	large := bytes.Repeat([]byte("foobarbaz"), 1000)
+	keyProvider := func(keyID []byte) ([]byte, error) {
+		key, ok := keys[string(keyID)]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return key, nil
+	}
+	tt := []struct {
+		title                      string
+		body                       []byte
+		keyProvider                func([]byte) ([]byte, error)
+		wantBody                   string
+		wantErr                    bool
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:       "single record",
+			body:        single,
+			keyProvider: keyProvider,
+			wantBody:    "I am the walrus",
+		},
+		{
+			title:       "multiple records",
+			body:        multiple,
+			keyProvider: keyProvider,
+			wantBody:    "I am the walrus",
+		},
+		{
+			title:       "large",
+			body:        aes128gcmBytes(large, keys[""], nil, 100),
+			keyProvider: keyProvider,
+			wantBody:    string(large),
+		},
+		{
+			title:       "truncated",
+			body:        multiple[:len(multiple)-25],
+			keyProvider: keyProvider,
+			wantErr:     true,
+		},
+		{
+			title:       "corrupted",
+			body:        append(append([]byte{}, single[:len(single)-1]...), single[len(single)-1]^0xff),
+			keyProvider: keyProvider,
+			wantErr:     true,
+		},
+		{
+			title: "wrong key",
+			body:  single,
+			keyProvider: func([]byte) ([]byte, error) {
+				return keys["a1"], nil
+			},
+			wantErr: true,
+		},
+		{
+			title:                      "no key provider",
+			body:                       single,
+			wantErrUnsupportedEncoding: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:        &stubRoundTripper{response: newResponse(t, te.body, "aes128gcm")},
+				KeyProvider: te.keyProvider,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				if !te.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_AES128GCM_RecordSize(t *testing.T) {
+	for i, rs := range []uint32{17, 1<<20 + 1, 0xffffffff} {
+		t.Run(fmt.Sprintf("#%d %d", i, rs), func(t *testing.T) {
+			body := binary.BigEndian.AppendUint32([]byte("salt-is-16-bytes"), rs)
+			body = append(body, 0)
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: newResponse(t, body, "aes128gcm")},
+				KeyProvider: func([]byte) ([]byte, error) {
+					return []byte("0123456789abcdef"), nil
+				},
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			if err == nil || !strings.Contains(err.Error(), "invalid aes128gcm record size") {
+				t.Errorf("got %v, want the error of the record size", err)
+			}
+		})
+	}
+}
+
+func b64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Clone(b)
+}
+
+// aes128gcmBytes encrypts b in the aes128gcm content coding without padding
+func aes128gcmBytes(b, key, keyID []byte, rs int) []byte {
+	salt := []byte("salt-is-16-bytes")
+	mac := func(key, data []byte) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write(data)
+		return h.Sum(nil)
+	}
+	prk := mac(salt, key)
+	cek := mac(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:16]
+	baseNonce := mac(prk, []byte("Content-Encoding: nonce\x00\x01"))[:12]
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	dst := bytes.NewBuffer(salt)
+	binary.Write(dst, binary.BigEndian, uint32(rs))
+	dst.WriteByte(byte(len(keyID)))
+	dst.Write(keyID)
+	size := rs - aead.Overhead() - 1
+	for seq := 0; ; seq++ {
+		n := size
+		delimiter := byte(1)
+		if len(b) <= size {
+			n = len(b)
+			delimiter = 2
+		}
+		nonce := append([]byte{}, baseNonce...)
+		nonce[11] ^= byte(seq)
+		nonce[10] ^= byte(seq >> 8)
+		plain := append(append([]byte{}, b[:n]...), delimiter)
+		dst.Write(aead.Seal(nil, nonce, plain, nil))
+		b = b[n:]
+		if delimiter == 2 {
+			return dst.Bytes()
+		}
+	}
+}
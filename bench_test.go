@@ -0,0 +1,71 @@
+package decompress_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// reusableBody is the response body reused by the benchmarks, so that the stub does not allocate per request
+type reusableBody struct {
+	*bytes.Reader
+}
+
+func (reusableBody) Close() error {
+	return nil
+}
+
+// BenchmarkRoundTripper_RoundTrip measures the steady-state allocations of RoundTrip and reading the body.
+// The allocations per request are the returned body, that can not be pooled since the callers may hold it after Close,
+// and the adler32 hash allocated by Reset of compress/zlib
+func BenchmarkRoundTripper_RoundTrip(b *testing.B) {
+	data := bytes.Repeat([]byte("foobarbaz"), 1000)
+	tt := []struct {
+		title           string
+		contentEncoding string
+		body            []byte
+	}{
+		{title: "gzip", contentEncoding: "gzip", body: gzipBytes(data)},
+		{title: "deflate", contentEncoding: "deflate", body: zlibBytes(data)},
+		{title: "deflate, gzip", contentEncoding: "deflate, gzip", body: gzipBytes(zlibBytes(data))},
+	}
+	for i, te := range tt {
+		b.Run(fmt.Sprintf("#%d %s", i, te.title), func(b *testing.B) {
+			raw := reusableBody{Reader: bytes.NewReader(te.body)}
+			ce := []string{te.contentEncoding}
+			resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+			dr := decompress.RoundTripper{
+				Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					raw.Reset(te.body)
+					resp.Header["Content-Encoding"] = ce
+					resp.Body = raw
+					return resp, nil
+				}),
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			buf := make([]byte, 32<<10)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res, err := dr.RoundTrip(req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for {
+					_, err := res.Body.Read(buf)
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				res.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// Package brotli provides the decoder for the `br` content coding (RFC 7932).
+// Importing the package registers the decoder by decompress.RegisterDecoder, and advertises `br` by decompress.SetPreference:
+//
+//	import _ "github.com/kei2100/decompress-roundtripper/brotli"
+//
+// The decoder uses github.com/andybalholm/brotli by default, or github.com/google/brotli/go/cbrotli (libbrotli)
+// with the cbrotli build tag.
+// Large-window brotli streams are always rejected, since they may require hundreds of MB of decoder state.
+// There is no option to allow them, since neither backend can decode them: github.com/andybalholm/brotli does not
+// implement the large-window mode, and github.com/google/brotli/go/cbrotli does not expose the parameter enabling it.
+//
+// Custom and shared brotli dictionaries are not supported. github.com/andybalholm/brotli supports the dictionaries
+// only in the encoder, and github.com/google/brotli/go/cbrotli does not expose the dictionaries of libbrotli.
+// For the same reason, the `dcb` content coding of the Compression Dictionary Transport (RFC 9842) is not supported.
+package brotli
+
+import (
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func init() {
+	decompress.SetPreference("br", 1)
+	decompress.RegisterDecoder("br", NewFactory())
+}
+
+// NewFactory returns the factory of the `br` decoders. The decoders are pooled and reused by Reset
+func NewFactory() decompress.DecoderFactory {
+	return decompress.NewPooledFactory(factory{})
+}
+
+type factory struct{}
+
+func (factory) NewDecoder(r io.Reader) (decompress.Decoder, error) {
+	return newDecoder(r)
+}
+
+// MemoryEstimate implements decompress.MemoryEstimator. It is the maximum window of the non large-window streams
+func (factory) MemoryEstimate() int64 {
+	return 16 << 20
+}
@@ -0,0 +1,18 @@
+//go:build cgo && cbrotli
+
+package brotli
+
+import (
+	"io"
+
+	"github.com/google/brotli/go/cbrotli"
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// newDecoder returns the decoder using github.com/google/brotli/go/cbrotli (libbrotli).
+// Since cbrotli.Reader can not be reset, Reset of the decoder creates a new reader
+func newDecoder(r io.Reader) (decompress.Decoder, error) {
+	return decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+		return cbrotli.NewReader(r), nil
+	}).NewDecoder(r)
+}
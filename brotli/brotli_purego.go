@@ -0,0 +1,23 @@
+//go:build !cgo || !cbrotli
+
+package brotli
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// newDecoder returns the decoder using github.com/andybalholm/brotli
+func newDecoder(r io.Reader) (decompress.Decoder, error) {
+	return &decoder{Reader: brotli.NewReader(r)}, nil
+}
+
+type decoder struct {
+	*brotli.Reader
+}
+
+func (d *decoder) Close() error {
+	return nil
+}
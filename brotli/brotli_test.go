@@ -0,0 +1,50 @@
+package brotli_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	dbrotli "github.com/kei2100/decompress-roundtripper/brotli"
+	"github.com/kei2100/decompress-roundtripper/codectest"
+)
+
+func TestNewFactory_LargeWindow(t *testing.T) {
+	// 0x11 is the WBITS header of a large-window brotli stream
+	body := append([]byte{0x11}, brotliBytes([]byte("foobarbaz"))[1:]...)
+	d, err := dbrotli.NewFactory().NewDecoder(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := io.ReadAll(d); err == nil {
+		t.Error("got nil, want error")
+	}
+}
+
+func TestNewFactory_Conformance(t *testing.T) {
+	codectest.Suite{
+		Factory: dbrotli.NewFactory(),
+		Encode: func(b []byte) ([]byte, error) {
+			return brotliBytes(b), nil
+		},
+		// github.com/andybalholm/brotli returns io.EOF for a stream truncated right after a chunk of output
+		SkipTruncated: true,
+	}.Run(t)
+}
+
+func brotliBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := brotli.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
@@ -0,0 +1,167 @@
+package decompress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MemoryEstimator is implemented by the DecoderFactory that reports the estimated memory of its decoder,
+// e.g. the window of the brotli and zstd decoders, used by MemoryBudget
+type MemoryEstimator interface {
+	// MemoryEstimate returns the estimated bytes of the memory a decoder holds
+	MemoryEstimate() int64
+}
+
+// defaultMemoryEstimate is the estimated memory of the decoders not implementing MemoryEstimator,
+// that is about the state of the flate decoder including its 32 KiB window
+const defaultMemoryEstimate = 64 << 10
+
+// memoryEstimate returns the estimated memory of the decoder created by f for the content coding name
+func memoryEstimate(name string, f DecoderFactory) int64 {
+	if e, ok := f.(MemoryEstimator); ok {
+		return e.MemoryEstimate()
+	}
+	if name == "bzip2" {
+		// the block of 900k and its inverse BWT table
+		return 4 << 20
+	}
+	return defaultMemoryEstimate
+}
+
+// BudgetPolicy is the behavior of MemoryBudget when the budget is exceeded
+type BudgetPolicy int
+
+const (
+	// BudgetWait makes the request wait until the memory is released by the other decoders, or the request context is done
+	BudgetWait BudgetPolicy = iota
+	// BudgetFail makes RoundTrip close the body and return ErrMemoryBudget
+	BudgetFail
+	// BudgetPassThrough makes RoundTrip return the response as is, without decompression
+	BudgetPassThrough
+)
+
+// MemoryBudget is the budget of the memory held by the active decoders, that is shared by the RoundTrippers,
+// e.g. to make the budget process-wide. The memory of a decoder is estimated by MemoryEstimator.
+// The memory is held from RoundTrip until the body is closed, or reaches EOF or an error.
+// MemoryBudget is safe for concurrent use
+type MemoryBudget struct {
+	limit  int64
+	policy BudgetPolicy
+
+	mu      sync.Mutex
+	used    int64
+	waiters []*budgetWaiter
+}
+
+type budgetWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// NewMemoryBudget returns the MemoryBudget of limit bytes, that behaves according to policy when it is exceeded
+func NewMemoryBudget(limit int64, policy BudgetPolicy) *MemoryBudget {
+	return &MemoryBudget{limit: limit, policy: policy}
+}
+
+// Used returns the estimated bytes held by the active decoders
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// acquire holds n bytes of the budget. A decoder larger than the whole budget is allowed when no other decoder is active
+func (b *MemoryBudget) acquire(ctx context.Context, n int64) (int64, error) {
+	n = min(n, b.limit)
+	b.mu.Lock()
+	if b.used+n <= b.limit && len(b.waiters) == 0 {
+		b.used += n
+		b.mu.Unlock()
+		return n, nil
+	}
+	if b.policy != BudgetWait {
+		b.mu.Unlock()
+		return 0, &ErrMemoryBudget{Limit: b.limit}
+	}
+	w := &budgetWaiter{n: n, ready: make(chan struct{})}
+	b.waiters = append(b.waiters, w)
+	b.mu.Unlock()
+	select {
+	case <-w.ready:
+		return n, nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		select {
+		case <-w.ready:
+			// acquired while canceling
+			b.used -= n
+		default:
+			for i, v := range b.waiters {
+				if v == w {
+					b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		b.notify()
+		b.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// release returns n bytes to the budget
+func (b *MemoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	b.notify()
+}
+
+// notify wakes the waiters in FIFO order while the budget allows. b.mu must be held
+func (b *MemoryBudget) notify() {
+	for len(b.waiters) > 0 && b.used+b.waiters[0].n <= b.limit {
+		w := b.waiters[0]
+		b.waiters = b.waiters[1:]
+		b.used += w.n
+		close(w.ready)
+	}
+}
+
+// ErrMemoryBudget represents the error that the memory of the decoders exceeds the MemoryBudget
+type ErrMemoryBudget struct {
+	Limit int64
+}
+
+// Error implements the error interface
+func (e *ErrMemoryBudget) Error() string {
+	return fmt.Sprintf("decompress: decoders exceed the memory budget %d bytes", e.Limit)
+}
+
+// budgetReadCloser releases the memory budget when rc is closed, or reaches EOF or an error
+type budgetReadCloser struct {
+	rc   io.ReadCloser
+	b    *MemoryBudget
+	n    int64
+	once sync.Once
+}
+
+func (l *budgetReadCloser) Read(p []byte) (int, error) {
+	n, err := l.rc.Read(p)
+	if err != nil {
+		l.release()
+	}
+	return n, err
+}
+
+func (l *budgetReadCloser) Close() error {
+	l.release()
+	return l.rc.Close()
+}
+
+func (l *budgetReadCloser) release() {
+	l.once.Do(func() {
+		l.b.release(l.n)
+	})
+}
@@ -0,0 +1,123 @@
+package decompress_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	roundTrip := func(ctx context.Context, t *testing.T, b *decompress.MemoryBudget) (*http.Response, error) {
+		t.Helper()
+		dr := decompress.RoundTripper{
+			Wrap:         &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")},
+			MemoryBudget: b,
+		}
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+		return dr.RoundTrip(req)
+	}
+	tt := []struct {
+		title           string
+		policy          decompress.BudgetPolicy
+		wantErr         bool
+		wantPassThrough bool
+	}{
+		{title: "fail", policy: decompress.BudgetFail, wantErr: true},
+		{title: "pass through", policy: decompress.BudgetPassThrough, wantPassThrough: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			b := decompress.NewMemoryBudget(100<<10, te.policy)
+			held, err := roundTrip(context.Background(), t, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Used() == 0 {
+				t.Error("Used got 0, want held")
+			}
+			resp, err := roundTrip(context.Background(), t, b)
+			if te.wantErr {
+				var wantErr *decompress.ErrMemoryBudget
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrMemoryBudget", err, err)
+				}
+			}
+			if te.wantPassThrough {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := resp.Header.Get("Content-Encoding"), "gzip"; got != want {
+					t.Errorf("Content-Encoding got %v, want %v", got, want)
+				}
+			}
+			held.Body.Close()
+			if got, want := b.Used(), int64(0); got != want {
+				t.Errorf("Used got %v, want %v", got, want)
+			}
+		})
+	}
+	t.Run("wait", func(t *testing.T) {
+		b := decompress.NewMemoryBudget(100<<10, decompress.BudgetWait)
+		held, err := roundTrip(context.Background(), t, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan []byte)
+		go func() {
+			resp, err := roundTrip(context.Background(), t, b)
+			if err != nil {
+				t.Error(err)
+				close(done)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			done <- body
+		}()
+		select {
+		case <-done:
+			t.Fatal("got the response, want waiting for the budget")
+		case <-time.After(50 * time.Millisecond):
+		}
+		// released at EOF
+		if _, err := io.ReadAll(held.Body); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(<-done), "foobarbaz"; got != want {
+			t.Errorf("body got %v, want %v", got, want)
+		}
+		held.Body.Close()
+		if got, want := b.Used(), int64(0); got != want {
+			t.Errorf("Used got %v, want %v", got, want)
+		}
+	})
+	t.Run("wait canceled", func(t *testing.T) {
+		b := decompress.NewMemoryBudget(100<<10, decompress.BudgetWait)
+		held, err := roundTrip(context.Background(), t, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer held.Body.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := roundTrip(ctx, t, b); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want context.DeadlineExceeded", err)
+		}
+	})
+	t.Run("larger than the budget", func(t *testing.T) {
+		b := decompress.NewMemoryBudget(1, decompress.BudgetFail)
+		resp, err := roundTrip(context.Background(), t, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+			t.Errorf("body got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package decompress
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// buffer reads the whole body of res into the buffer of the BufferPool, and sets the length of the body to res.
+// The buffer is returned to the pool when the body is closed.
+// If the body exceeds SpillThreshold bytes, the body is buffered in the temporary file instead
+func (r *RoundTripper) buffer(res *http.Response) error {
+	defer res.Body.Close()
+	limit, memLimit := r.BufferLimit, r.BufferLimit
+	if r.SpillThreshold > 0 && (limit <= 0 || r.SpillThreshold < limit) {
+		memLimit = r.SpillThreshold
+	}
+	pool := r.bufferPool()
+	buf := bytes.NewBuffer(pool.Get()[:0])
+	if _, err := buf.ReadFrom(io.LimitReader(res.Body, memLimit+1)); err != nil {
+		pool.Put(buf.Bytes())
+		return err
+	}
+	b := buf.Bytes()
+	if int64(len(b)) <= memLimit {
+		res.Body = &pooledBody{Reader: bytes.NewReader(b), buf: b, pool: pool, ByteCounter: counter(res.Body)}
+		setBufferedLength(res, int64(len(b)))
+		return nil
+	}
+	if memLimit == limit {
+		pool.Put(b)
+		return &ErrTooLarge{Limit: limit}
+	}
+	return r.spill(res, b, pool, limit)
+}
+
+// adaptiveBuffer reads the body of res into the buffer of the BufferPool up to AdaptiveBufferLimit bytes.
+// If the body reaches EOF within the limit, the length of the body is set to res like buffer.
+// Otherwise the buffered data is followed by the rest of the body, that is streamed
+func (r *RoundTripper) adaptiveBuffer(res *http.Response) error {
+	pool := r.bufferPool()
+	buf := bytes.NewBuffer(pool.Get()[:0])
+	if _, err := buf.ReadFrom(io.LimitReader(res.Body, r.AdaptiveBufferLimit+1)); err != nil {
+		pool.Put(buf.Bytes())
+		res.Body.Close()
+		return err
+	}
+	b := buf.Bytes()
+	if int64(len(b)) <= r.AdaptiveBufferLimit {
+		res.Body.Close()
+		res.Body = &pooledBody{Reader: bytes.NewReader(b), buf: b, pool: pool, ByteCounter: counter(res.Body)}
+		setBufferedLength(res, int64(len(b)))
+		return nil
+	}
+	res.Body = &prefixedBody{head: pooledBody{Reader: bytes.NewReader(b), buf: b, pool: pool}, rc: res.Body}
+	return nil
+}
+
+// prefixedBody is the body of the buffered head followed by rc. The buffer of the head is returned to the pool as soon
+// as it is read. The bytes are counted by rc
+type prefixedBody struct {
+	head pooledBody
+	rc   io.ReadCloser
+}
+
+func (b *prefixedBody) Read(p []byte) (int, error) {
+	if b.head.Len() > 0 {
+		n, _ := b.head.Read(p)
+		if b.head.Len() == 0 {
+			b.head.Close()
+		}
+		return n, nil
+	}
+	return b.rc.Read(p)
+}
+
+func (b *prefixedBody) WriteTo(w io.Writer) (int64, error) {
+	n, err := b.head.WriteTo(w)
+	b.head.Close()
+	if err != nil {
+		return n, err
+	}
+	m, err := writeTo(w, b.rc, b.head.pool)
+	return n + m, err
+}
+
+func (b *prefixedBody) Close() error {
+	b.head.Close()
+	return b.rc.Close()
+}
+
+// CompressedBytesRead implements ByteCounter
+func (b *prefixedBody) CompressedBytesRead() int64 {
+	return counter(b.rc).CompressedBytesRead()
+}
+
+// DecompressedBytesWritten implements ByteCounter
+func (b *prefixedBody) DecompressedBytesWritten() int64 {
+	return counter(b.rc).DecompressedBytesWritten()
+}
+
+// setBufferedLength sets the length of the buffered body to res
+func setBufferedLength(res *http.Response, n int64) {
+	res.ContentLength = n
+	res.Header.Set("Content-Length", strconv.FormatInt(n, 10))
+}
@@ -0,0 +1,380 @@
+package decompress_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_RoundTrip_BufferLimit(t *testing.T) {
+	tt := []struct {
+		title             string
+		limit             int64
+		contentEncoding   string
+		body              []byte
+		wantBody          string
+		wantContentLength int64
+		wantHeader        string
+		wantErr           bool
+		wantErrTooLarge   bool
+	}{
+		{
+			title:             "buffered",
+			limit:             100,
+			contentEncoding:   "gzip",
+			body:              gzipBytes([]byte("foobarbaz")),
+			wantBody:          "foobarbaz",
+			wantContentLength: 9,
+			wantHeader:        "9",
+		},
+		{
+			title:             "just the limit",
+			limit:             9,
+			contentEncoding:   "deflate, gzip",
+			body:              gzipBytes(deflateBytes([]byte("foobarbaz"))),
+			wantBody:          "foobarbaz",
+			wantContentLength: 9,
+			wantHeader:        "9",
+		},
+		{
+			title:             "empty",
+			limit:             100,
+			contentEncoding:   "gzip",
+			body:              gzipBytes(nil),
+			wantBody:          "",
+			wantContentLength: 0,
+			wantHeader:        "0",
+		},
+		{
+			title:           "exceeds the limit",
+			limit:           8,
+			contentEncoding: "gzip",
+			body:            gzipBytes([]byte("foobarbaz")),
+			wantErrTooLarge: true,
+		},
+		{
+			title:           "corrupted",
+			limit:           100,
+			contentEncoding: "gzip",
+			body:            gzipBytes([]byte("foobarbaz"))[:20],
+			wantErr:         true,
+		},
+		{
+			title:             "not buffered",
+			contentEncoding:   "gzip",
+			body:              gzipBytes([]byte("foobarbaz")),
+			wantBody:          "foobarbaz",
+			wantContentLength: -1,
+			wantHeader:        "",
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:        &stubRoundTripper{response: newResponse(t, te.body, te.contentEncoding)},
+				BufferLimit: te.limit,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrTooLarge {
+				var wantErr *decompress.ErrTooLarge
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrTooLarge", err, err)
+				}
+				return
+			}
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.ContentLength, te.wantContentLength; got != want {
+				t.Errorf("ContentLength got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Length"), te.wantHeader; got != want {
+				t.Errorf("Content-Length got %v, want %v", got, want)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// recordingBufferPool records the buffers got and put
+type recordingBufferPool struct {
+	got, put int
+}
+
+func (p *recordingBufferPool) Get() []byte {
+	p.got++
+	return make([]byte, 4)
+}
+
+func (p *recordingBufferPool) Put([]byte) {
+	p.put++
+}
+
+func TestRoundTripper_RoundTrip_BufferPool(t *testing.T) {
+	pool := &recordingBufferPool{}
+	dr := decompress.RoundTripper{
+		Wrap:        &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")},
+		BufferLimit: 100,
+		BufferPool:  pool,
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	resp, err := dr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "foobarbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+	if got, want := pool.put, 0; got != want {
+		t.Errorf("Put calls before Close got %v, want %v", got, want)
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	if got, want := pool.got, 1; got != want {
+		t.Errorf("Get calls got %v, want %v", got, want)
+	}
+	if got, want := pool.put, 1; got != want {
+		t.Errorf("Put calls got %v, want %v", got, want)
+	}
+}
+
+func TestRoundTripper_RoundTrip_BufferPool_Copy(t *testing.T) {
+	tt := []struct {
+		title   string
+		factory decompress.DecoderFactory
+	}{
+		{title: "default"},
+		{title: "not pooled", factory: decompress.NewGzipFactory(decompress.GzipOptions{}, func(r io.Reader) (decompress.GzipReader, error) {
+			return gzip.NewReader(r)
+		})},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			pool := &recordingBufferPool{}
+			dr := decompress.RoundTripper{
+				Wrap:       &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")},
+				BufferPool: pool,
+			}
+			if te.factory != nil {
+				dr.Decoders = map[string]decompress.DecoderFactory{"gzip": te.factory}
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var b strings.Builder
+			if _, err := io.Copy(&b, resp.Body); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := b.String(), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			if got, want := pool.got, 1; got != want {
+				t.Errorf("Get calls got %v, want %v", got, want)
+			}
+			if got, want := pool.put, 1; got != want {
+				t.Errorf("Put calls got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_SpillThreshold(t *testing.T) {
+	data := []byte("foobarbazqux")
+	tt := []struct {
+		title           string
+		threshold       int64
+		limit           int64
+		wantSpilled     bool
+		wantErrTooLarge bool
+	}{
+		{title: "in memory", threshold: 100},
+		{title: "just the threshold", threshold: 12},
+		{title: "spilled", threshold: 4, wantSpilled: true},
+		{title: "spilled within the limit", threshold: 4, limit: 12, wantSpilled: true},
+		{title: "spilled exceeds the limit", threshold: 4, limit: 8, wantErrTooLarge: true},
+		{title: "limit under the threshold", threshold: 100, limit: 8, wantErrTooLarge: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dir := t.TempDir()
+			dr := decompress.RoundTripper{
+				Wrap:           &stubRoundTripper{response: newResponse(t, gzipBytes(data), "gzip")},
+				BufferLimit:    te.limit,
+				SpillThreshold: te.threshold,
+				SpillDir:       dir,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrTooLarge {
+				var wantErr *decompress.ErrTooLarge
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrTooLarge", err, err)
+				}
+				if files, _ := os.ReadDir(dir); len(files) != 0 {
+					t.Errorf("temporary files got %v, want none", len(files))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.ContentLength, int64(len(data)); got != want {
+				t.Errorf("ContentLength got %v, want %v", got, want)
+			}
+			files, _ := os.ReadDir(dir)
+			if got, want := len(files) == 1, te.wantSpilled; got != want {
+				t.Errorf("spilled got %v, want %v", got, want)
+			}
+			b := make([]byte, 3)
+			if _, err := resp.Body.(io.ReaderAt).ReadAt(b, 6); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "baz"; got != want {
+				t.Errorf("ReadAt got %v, want %v", got, want)
+			}
+			if _, err := resp.Body.(io.Seeker).Seek(9, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			b, err = io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "qux"; got != want {
+				t.Errorf("body after Seek got %v, want %v", got, want)
+			}
+			if err := resp.Body.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := resp.Body.Close(); err != nil {
+				t.Errorf("second Close got %v, want nil", err)
+			}
+			if files, _ := os.ReadDir(dir); len(files) != 0 {
+				t.Errorf("temporary files after Close got %v, want none", len(files))
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_AdaptiveBufferLimit(t *testing.T) {
+	tt := []struct {
+		title             string
+		limit             int64
+		bufferLimit       int64
+		body              []byte
+		writeTo           bool
+		wantBody          string
+		wantContentLength int64
+		wantHeader        string
+		wantErr           bool
+	}{
+		{
+			title:             "buffered",
+			limit:             100,
+			body:              gzipBytes([]byte("foobarbaz")),
+			wantBody:          "foobarbaz",
+			wantContentLength: 9,
+			wantHeader:        "9",
+		},
+		{
+			title:             "just the limit",
+			limit:             9,
+			body:              gzipBytes([]byte("foobarbaz")),
+			wantBody:          "foobarbaz",
+			wantContentLength: 9,
+			wantHeader:        "9",
+		},
+		{
+			title:             "streamed",
+			limit:             4,
+			body:              gzipBytes([]byte("foobarbaz")),
+			wantBody:          "foobarbaz",
+			wantContentLength: -1,
+		},
+		{
+			title:             "streamed by WriteTo",
+			limit:             4,
+			body:              gzipBytes([]byte("foobarbaz")),
+			writeTo:           true,
+			wantBody:          "foobarbaz",
+			wantContentLength: -1,
+		},
+		{
+			title:       "BufferLimit takes precedence",
+			limit:       4,
+			bufferLimit: 8,
+			body:        gzipBytes([]byte("foobarbaz")),
+			wantErr:     true,
+		},
+		{
+			title:   "corrupted",
+			limit:   100,
+			body:    gzipBytes([]byte("foobarbaz"))[:20],
+			wantErr: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:                &stubRoundTripper{response: newResponse(t, te.body, "gzip")},
+				BufferLimit:         te.bufferLimit,
+				AdaptiveBufferLimit: te.limit,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got, want := resp.ContentLength, te.wantContentLength; got != want {
+				t.Errorf("ContentLength got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Length"), te.wantHeader; got != want {
+				t.Errorf("Content-Length got %v, want %v", got, want)
+			}
+			var b []byte
+			if te.writeTo {
+				var buf bytes.Buffer
+				if _, err := resp.Body.(io.WriterTo).WriteTo(&buf); err != nil {
+					t.Fatal(err)
+				}
+				b = buf.Bytes()
+			} else if b, err = io.ReadAll(iotest.OneByteReader(resp.Body)); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
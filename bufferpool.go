@@ -0,0 +1,93 @@
+package decompress
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// BufferPool is the pool of the byte buffers, that the package uses for copying and buffering the bodies instead of
+// allocating them per request. It has the same methods as httputil.BufferPool, so the pools can be shared with
+// httputil.ReverseProxy. The buffers may be grown before Put
+type BufferPool interface {
+	Get() []byte
+	Put(b []byte)
+}
+
+const (
+	// defaultBufferSize is the size of the buffers of the default BufferPool
+	defaultBufferSize = 32 << 10
+	// maxPooledBufferSize is the maximum capacity of the buffers kept by the default BufferPool,
+	// so that the pool does not pin the large buffers grown by the buffering mode
+	maxPooledBufferSize = 1 << 20
+)
+
+// defaultBufferPool is the BufferPool used if RoundTripper.BufferPool is nil, and by the package level functions
+var defaultBufferPool BufferPool = &syncBufferPool{}
+
+type syncBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *syncBufferPool) Get() []byte {
+	if b, ok := p.pool.Get().(*[]byte); ok {
+		return (*b)[:cap(*b)]
+	}
+	return make([]byte, defaultBufferSize)
+}
+
+func (p *syncBufferPool) Put(b []byte) {
+	if cap(b) < defaultBufferSize || cap(b) > maxPooledBufferSize {
+		return
+	}
+	p.pool.Put(&b)
+}
+
+// bufferPool returns r.BufferPool, or the default BufferPool if it is nil
+func (r *RoundTripper) bufferPool() BufferPool {
+	if r.BufferPool != nil {
+		return r.BufferPool
+	}
+	return defaultBufferPool
+}
+
+// pooledBody is the body read from the pooled buffer, that is returned to the pool on Close.
+// It implements io.Seeker and io.ReaderAt for the consumers requiring the random access
+type pooledBody struct {
+	*bytes.Reader
+	buf  []byte
+	pool BufferPool
+	once sync.Once
+	ByteCounter
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() {
+		b.pool.Put(b.buf)
+		b.Reader = bytes.NewReader(nil)
+	})
+	return nil
+}
+
+func (b *pooledBody) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, b.Reader, b.pool)
+}
+
+// poolWriterTo is implemented by the wrappers of the decoders, so that pool is passed down to the innermost copy
+type poolWriterTo interface {
+	writeToPool(w io.Writer, pool BufferPool) (int64, error)
+}
+
+// writeTo writes the data of r to w by r.WriteTo if r implements io.WriterTo, so that the decoder-internal copies are
+// used, otherwise by copying through a buffer of pool
+func writeTo(w io.Writer, r io.Reader, pool BufferPool) (int64, error) {
+	if pw, ok := r.(poolWriterTo); ok {
+		return pw.writeToPool(w, pool)
+	}
+	if wt, ok := r.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	buf := pool.Get()
+	defer pool.Put(buf)
+	return io.CopyBuffer(w, r, buf)
+}
@@ -0,0 +1,70 @@
+package decompress
+
+import (
+	"compress/bzip2"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// builtinDecoder returns the built-in decoder factory for the content coding name, or nil if the coding is unsupported
+// or not enabled by the configuration of r
+func (r *RoundTripper) builtinDecoder(name string, res *http.Response) DecoderFactory {
+	switch name {
+	case "gzip":
+		return stdGzipFactory(r.Gzip)
+	case "deflate":
+		return stdDeflateFactory
+	case "bzip2":
+		return DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		})
+	case "compress":
+		return lzwFactory{}
+	case "aes128gcm":
+		if r.KeyProvider == nil {
+			return nil
+		}
+		return DecoderFunc(func(rd io.Reader) (io.ReadCloser, error) {
+			ar, err := newAES128GCMReader(rd, r.KeyProvider)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(ar), nil
+		})
+	case "vcdiff":
+		if r.DeltaBase == nil {
+			return nil
+		}
+		return DecoderFunc(func(rd io.Reader) (io.ReadCloser, error) {
+			base, err := r.DeltaBase(res)
+			if err != nil {
+				return nil, fmt.Errorf("get delta base: %w", err)
+			}
+			vr, err := newVCDIFFReader(rd, base)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(vr), nil
+		})
+	case "base64":
+		if !r.Base64 {
+			return nil
+		}
+		return DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(base64.NewDecoder(base64.StdEncoding, r)), nil
+		})
+	}
+	return nil
+}
+
+type lzwFactory struct{}
+
+func (lzwFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	z, err := newLZWReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
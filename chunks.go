@@ -0,0 +1,52 @@
+//go:build go1.23
+
+package decompress
+
+import (
+	"io"
+	"iter"
+	"net/http"
+)
+
+// defaultChunkSize is the size of the chunks yielded by Chunks by default
+const defaultChunkSize = defaultBufferSize
+
+// Chunks returns the iterator over the chunks of the decompressed body of res, so that the streaming consumers can
+// range over the body without managing the readers and the order of closing them.
+// The body is decompressed if it is still compressed, and closed when the iteration ends.
+// A chunk is at most size bytes, or 32 KiB if size is not positive, and is valid only until the next iteration.
+// An error is yielded with a nil chunk, and ends the iteration. The iterator can be used only once
+func Chunks(res *http.Response, size int) iter.Seq2[[]byte, error] {
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	return func(yield func([]byte, error) bool) {
+		defer func() {
+			res.Body.Close()
+		}()
+		if err := DecodeResponse(res); err != nil {
+			yield(nil, err)
+			return
+		}
+		var buf []byte
+		if size == defaultChunkSize {
+			buf = defaultBufferPool.Get()[:size]
+			defer defaultBufferPool.Put(buf)
+		} else {
+			buf = make([]byte, size)
+		}
+		for {
+			n, err := res.Body.Read(buf)
+			if n > 0 && !yield(buf[:n], nil) {
+				return
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
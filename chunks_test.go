@@ -0,0 +1,80 @@
+//go:build go1.23
+
+package decompress_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestChunks(t *testing.T) {
+	data := bytes.Repeat([]byte("foobarbaz"), 1000)
+	tt := []struct {
+		title           string
+		body            []byte
+		contentEncoding string
+		size            int
+		want            string
+		wantMaxChunk    int
+		wantErr         bool
+	}{
+		{title: "compressed", body: gzipBytes(data), contentEncoding: "gzip", size: 100, want: string(data), wantMaxChunk: 100},
+		{title: "default size", body: gzipBytes(data), contentEncoding: "gzip", want: string(data), wantMaxChunk: 32 * 1024},
+		{title: "not compressed", body: data, size: 1000, want: string(data), wantMaxChunk: 1000},
+		{title: "empty", body: nil, contentEncoding: "gzip", want: ""},
+		{title: "truncated", body: gzipBytes(data)[:50], contentEncoding: "gzip", wantErr: true},
+		{title: "unsupported", body: data, contentEncoding: "x-unknown", wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, te.body, te.contentEncoding)
+			body := &closeRecorder{ReadCloser: resp.Body}
+			resp.Body = body
+			var (
+				got      bytes.Buffer
+				maxChunk int
+				gotErr   error
+			)
+			for chunk, err := range decompress.Chunks(resp, te.size) {
+				if err != nil {
+					gotErr = err
+					continue
+				}
+				got.Write(chunk)
+				maxChunk = max(maxChunk, len(chunk))
+			}
+			if !body.closed {
+				t.Error("body is not closed")
+			}
+			if te.wantErr {
+				if gotErr == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if gotErr != nil {
+				t.Fatal(gotErr)
+			}
+			if got, want := got.String(), te.want; got != want {
+				t.Errorf("got %d bytes, want %d bytes", len(got), len(want))
+			}
+			if maxChunk > te.wantMaxChunk {
+				t.Errorf("chunk size got %v, want at most %v", maxChunk, te.wantMaxChunk)
+			}
+		})
+	}
+	t.Run("break", func(t *testing.T) {
+		resp := newResponse(t, gzipBytes(data), "gzip")
+		body := &closeRecorder{ReadCloser: resp.Body}
+		resp.Body = body
+		for range decompress.Chunks(resp, 10) {
+			break
+		}
+		if !body.closed {
+			t.Error("body is not closed")
+		}
+	})
+}
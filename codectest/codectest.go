@@ -0,0 +1,228 @@
+// Package codectest provides the conformance tests for the decoders of decompress-roundtripper, so that the authors of
+// custom decoders can verify their implementations before registering them:
+//
+//	func TestDecoder(t *testing.T) {
+//		codectest.Suite{
+//			Factory: mycodec.NewFactory(),
+//			Encode:  mycodec.Encode,
+//		}.Run(t)
+//	}
+package codectest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// Suite is the conformance test suite for a decoder
+type Suite struct {
+	// Factory is the factory of the decoders under test
+	Factory decompress.DecoderFactory
+	// Encode encodes data in the content coding that the decoders of Factory decode
+	Encode func(data []byte) ([]byte, error)
+	// SkipTruncated skips the test for the truncated input, for the content codings that can not detect truncation
+	SkipTruncated bool
+}
+
+// Run runs the conformance tests as the subtests of t:
+//   - RoundTrip: the decoders reproduce the encoded data, including the empty and incompressible data
+//   - Truncated: the decoders report an error instead of io.EOF for truncated input.
+//     Empty input is not considered truncated, since the decoders may treat it as an empty stream
+//   - Reset: the decoders decode another stream after Reset, even if the current stream is not fully read
+//   - Concurrent: the decoders created by the factory can be used concurrently
+//   - PooledReuse: the decoders pooled by decompress.NewPooledFactory are reused concurrently over the *bufio.Reader
+//     sources, that are reset to the other streams after the decoders are closed like the bodies of RoundTrip
+func (s Suite) Run(t *testing.T) {
+	t.Run("RoundTrip", s.testRoundTrip)
+	t.Run("Truncated", s.testTruncated)
+	t.Run("Reset", s.testReset)
+	t.Run("Concurrent", s.testConcurrent)
+	t.Run("PooledReuse", s.testPooledReuse)
+}
+
+type payload struct {
+	name string
+	data []byte
+}
+
+func payloads() []payload {
+	random := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	return []payload{
+		{name: "empty", data: []byte{}},
+		{name: "short", data: []byte("foobarbaz")},
+		{name: "repetitive", data: bytes.Repeat([]byte("foobarbaz "), 100000)},
+		{name: "incompressible", data: random},
+	}
+}
+
+func (s Suite) testRoundTrip(t *testing.T) {
+	for _, p := range payloads() {
+		t.Run(p.name, func(t *testing.T) {
+			encoded := s.encode(t, p.data)
+			b := s.decode(t, bytes.NewReader(encoded))
+			if !bytes.Equal(b, p.data) {
+				t.Errorf("decoded data mismatch: got %d bytes, want %d bytes", len(b), len(p.data))
+			}
+			// the decoders must tolerate short reads of the input, and must follow the io.Reader contract
+			d, err := s.Factory.NewDecoder(iotest.OneByteReader(bytes.NewReader(encoded)))
+			if err != nil {
+				t.Fatalf("NewDecoder: %v", err)
+			}
+			defer d.Close()
+			if err := iotest.TestReader(d, p.data); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func (s Suite) testTruncated(t *testing.T) {
+	if s.SkipTruncated {
+		t.Skip("SkipTruncated is set")
+	}
+	encoded := s.encode(t, bytes.Repeat([]byte("foobarbaz "), 10000))
+	for _, n := range []int{len(encoded) / 2, len(encoded) - 1} {
+		t.Run(fmt.Sprintf("%d of %d bytes", n, len(encoded)), func(t *testing.T) {
+			d, err := s.Factory.NewDecoder(bytes.NewReader(encoded[:n]))
+			if err != nil {
+				return
+			}
+			defer d.Close()
+			if _, err := io.ReadAll(d); err == nil {
+				t.Error("got nil, want error")
+			}
+		})
+	}
+}
+
+func (s Suite) testReset(t *testing.T) {
+	first, second := []byte("foobarbaz"), bytes.Repeat([]byte("barbazfoo "), 10000)
+	d, err := s.Factory.NewDecoder(bytes.NewReader(s.encode(t, second)))
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	defer d.Close()
+	// read partially, then reset
+	if _, err := io.ReadFull(d, make([]byte, 10)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	for i, want := range [][]byte{first, second, first} {
+		if err := d.Reset(bytes.NewReader(s.encode(t, want))); err != nil {
+			t.Fatalf("#%d Reset: %v", i, err)
+		}
+		b, err := io.ReadAll(d)
+		if err != nil {
+			t.Fatalf("#%d Read: %v", i, err)
+		}
+		if !bytes.Equal(b, want) {
+			t.Errorf("#%d decoded data mismatch: got %d bytes, want %d bytes", i, len(b), len(want))
+		}
+	}
+}
+
+func (s Suite) testConcurrent(t *testing.T) {
+	ps := payloads()
+	encoded := make([][]byte, len(ps))
+	for i, p := range ps {
+		encoded[i] = s.encode(t, p.data)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := ps[i%len(ps)]
+			d, err := s.Factory.NewDecoder(bytes.NewReader(encoded[i%len(ps)]))
+			if err != nil {
+				t.Errorf("%s NewDecoder: %v", p.name, err)
+				return
+			}
+			defer d.Close()
+			b, err := io.ReadAll(d)
+			if err != nil {
+				t.Errorf("%s Read: %v", p.name, err)
+				return
+			}
+			if !bytes.Equal(b, p.data) {
+				t.Errorf("%s decoded data mismatch: got %d bytes, want %d bytes", p.name, len(b), len(p.data))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (s Suite) testPooledReuse(t *testing.T) {
+	f := decompress.NewPooledFactory(s.Factory)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the source is reused after the decoder is closed, so the decoders must not keep using it
+			var br *bufio.Reader
+			for n := 0; n < 50; n++ {
+				want := bytes.Repeat([]byte(fmt.Sprintf("%d-%d ", i, n)), 100)
+				encoded, err := s.Encode(want)
+				if err != nil {
+					t.Errorf("Encode: %v", err)
+					return
+				}
+				if br == nil {
+					br = bufio.NewReader(bytes.NewReader(encoded))
+				} else {
+					br.Reset(bytes.NewReader(encoded))
+				}
+				d, err := f.NewDecoder(br)
+				if err != nil {
+					t.Errorf("NewDecoder: %v", err)
+					return
+				}
+				b, err := io.ReadAll(d)
+				d.Close()
+				if err != nil {
+					t.Errorf("Read: %v", err)
+					return
+				}
+				if !bytes.Equal(b, want) {
+					t.Errorf("decoded data mismatch: got %q, want %q", b, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (s Suite) encode(t *testing.T, data []byte) []byte {
+	t.Helper()
+	b, err := s.Encode(data)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return b
+}
+
+func (s Suite) decode(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	d, err := s.Factory.NewDecoder(r)
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	b, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	return b
+}
@@ -0,0 +1,41 @@
+package decompress
+
+import "strings"
+
+// ParseContentEncoding parses the values of the Content-Encoding header fields into the content coding names in the
+// order they were applied, the same way as the RoundTripper with LenientParsing does, so that the server-side and
+// proxy code can share the interpretation of the RoundTripper.
+// The names are lower-cased, the parameters are stripped, the registered aliases are resolved, and the empty elements
+// and `identity` are omitted. e.g. `GZIP;q=1, , x-gzip` => [gzip gzip]
+func ParseContentEncoding(values ...string) []string {
+	r := RoundTripper{LenientParsing: true}
+	return r.ParseContentEncoding(values...)
+}
+
+// ParseContentEncoding parses the values of the Content-Encoding header fields into the content coding names in the
+// order they were applied, with the aliases of the RoundTripper. The parameters are stripped only if LenientParsing is set.
+// See ParseContentEncoding
+func (r *RoundTripper) ParseContentEncoding(values ...string) []string {
+	return r.appendContentEncoding(nil, values...)
+}
+
+// appendContentEncoding appends the content coding names parsed from values to dst, without allocating if dst has
+// enough capacity
+func (r *RoundTripper) appendContentEncoding(dst []string, values ...string) []string {
+	// the multiple header fields are combined into a comma-separated list. Refs RFC 9110 Section 5.3
+	for _, v := range values {
+		for more := true; more; {
+			var coding string
+			coding, v, more = strings.Cut(v, ",")
+			if r.LenientParsing {
+				coding, _, _ = strings.Cut(coding, ";")
+			}
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "identity" || coding == "" {
+				continue
+			}
+			dst = append(dst, r.resolveAlias(coding))
+		}
+	}
+	return dst
+}
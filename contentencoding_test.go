@@ -0,0 +1,52 @@
+package decompress_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestParseContentEncoding(t *testing.T) {
+	tt := []struct {
+		title  string
+		values []string
+		want   []string
+	}{
+		{title: "no values", values: nil, want: nil},
+		{title: "single", values: []string{"gzip"}, want: []string{"gzip"}},
+		{title: "list", values: []string{"deflate, gzip"}, want: []string{"deflate", "gzip"}},
+		{title: "multiple fields", values: []string{"deflate", "gzip"}, want: []string{"deflate", "gzip"}},
+		{title: "empty elements and identity", values: []string{" , identity,gzip,", ""}, want: []string{"gzip"}},
+		{title: "case and parameters", values: []string{"GZIP;q=1.0, Br ; charset=utf-8"}, want: []string{"gzip", "br"}},
+		{title: "aliases", values: []string{"x-gzip, X-Compress, x-rot13-alias"}, want: []string{"gzip", "compress", "x-rot13"}},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			if got, want := decompress.ParseContentEncoding(te.values...), te.want; !slices.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_ParseContentEncoding(t *testing.T) {
+	tt := []struct {
+		title string
+		dr    decompress.RoundTripper
+		value string
+		want  []string
+	}{
+		{title: "strict", value: "gzip;q=1.0, deflate", want: []string{"gzip;q=1.0", "deflate"}},
+		{title: "lenient", dr: decompress.RoundTripper{LenientParsing: true}, value: "gzip;q=1.0, deflate", want: []string{"gzip", "deflate"}},
+		{title: "aliases", dr: decompress.RoundTripper{Aliases: map[string]string{"X-GZ": "GZIP"}}, value: "x-gz, x-gzip", want: []string{"gzip", "gzip"}},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			if got, want := te.dr.ParseContentEncoding(te.value), te.want; !slices.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
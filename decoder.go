@@ -0,0 +1,196 @@
+package decompress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Decoder is the reader that decodes a content coding.
+// Closing the Decoder should release the resources of the decoder, but does not need to close the underlying reader
+type Decoder interface {
+	io.ReadCloser
+	// Reset discards the state of the decoder and makes it decode r, so that the decoder can be pooled and reused
+	// for another stream instead of allocating a new one
+	Reset(r io.Reader) error
+}
+
+// DecoderFactory creates the decoders for a content coding
+type DecoderFactory interface {
+	// NewDecoder returns the decoder that decodes r
+	NewDecoder(r io.Reader) (Decoder, error)
+}
+
+// DecoderFunc creates the reader that decodes r according to a content coding.
+// Closing the returned reader should release the resources of the decoder, but does not need to close r
+type DecoderFunc func(r io.Reader) (io.ReadCloser, error)
+
+// NewDecoder implements the DecoderFactory.
+// Since a plain reader can not be reset, Reset of the returned decoder closes the current reader and calls f again
+func (f DecoderFunc) NewDecoder(r io.Reader) (Decoder, error) {
+	rc, err := f(r)
+	if err != nil {
+		return nil, err
+	}
+	return &funcDecoder{ReadCloser: rc, fn: f}, nil
+}
+
+type funcDecoder struct {
+	io.ReadCloser
+	fn DecoderFunc
+}
+
+func (d *funcDecoder) Reset(r io.Reader) error {
+	d.ReadCloser.Close()
+	rc, err := d.fn(r)
+	if err != nil {
+		d.ReadCloser = io.NopCloser(&errReader{err: err})
+		return err
+	}
+	d.ReadCloser = rc
+	return nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// registryMu guards decoders, aliases and preferences, so that the registry can be updated while requests are in flight
+var registryMu sync.RWMutex
+
+var decoders = make(map[string]DecoderFactory)
+
+// RegisterDecoder registers the decoder factory for the content coding name, so that RoundTripper can decode custom or
+// proprietary encodings. The registered decoder takes precedence over the built-in decoder of the same name.
+// The name is case-insensitive.
+// RegisterDecoder is safe for concurrent use, and the decoder is used for the responses received after the call.
+// If f is nil, RegisterDecoder panics
+func RegisterDecoder(name string, f DecoderFactory) {
+	if f == nil {
+		panic(fmt.Sprintf("decompress: RegisterDecoder decoder is nil for %s", name))
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	decoders[strings.ToLower(name)] = f
+}
+
+// UnregisterDecoder removes the decoder registered for the content coding name.
+// The responses whose decoding has already started are not affected
+func UnregisterDecoder(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(decoders, strings.ToLower(name))
+}
+
+// aliases is the registered aliases of the content coding names. RFC 9110 requires `x-gzip` and `x-compress` to be
+// treated as equivalent to `gzip` and `compress`
+var aliases = map[string]string{
+	"x-gzip":     "gzip",
+	"x-compress": "compress",
+}
+
+// RegisterAlias registers alias as an alternative name of the content coding name, so that the responses of noncompliant
+// servers using vendor-specific tokens (e.g. `x-zstd`) are decoded by the decoder of name.
+// Aliases are resolved before looking up the decoders, and are not resolved recursively.
+// RegisterAlias is safe for concurrent use
+func RegisterAlias(alias, name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	aliases[strings.ToLower(alias)] = strings.ToLower(name)
+}
+
+// UnregisterAlias removes the alias registered by RegisterAlias
+func UnregisterAlias(alias string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(aliases, strings.ToLower(alias))
+}
+
+// RegisterDecoderFunc registers the decoder function for the content coding name. See RegisterDecoder
+func RegisterDecoderFunc(name string, fn func(r io.Reader) (io.ReadCloser, error)) {
+	if fn == nil {
+		panic(fmt.Sprintf("decompress: RegisterDecoderFunc decoder is nil for %s", name))
+	}
+	RegisterDecoder(name, DecoderFunc(fn))
+}
+
+type decodersKey struct{}
+
+// WithDecoders returns a copy of ctx with the decoders keyed by the content coding name.
+// The decoders extend or override the decoders of RoundTripper for the request with the context,
+// so that a single request can use a special decoder without reconfiguring the shared RoundTripper.
+// If ctx already has decoders, they are merged and the new decoders take precedence
+func WithDecoders(ctx context.Context, decoders map[string]DecoderFactory) context.Context {
+	merged := make(map[string]DecoderFactory)
+	if parent, ok := ctx.Value(decodersKey{}).(map[string]DecoderFactory); ok {
+		for name, f := range parent {
+			merged[name] = f
+		}
+	}
+	for name, f := range decoders {
+		merged[name] = f
+	}
+	return context.WithValue(ctx, decodersKey{}, merged)
+}
+
+// decoder returns the decoder factory for the content coding name of res. The name should be the lower case name
+// with the aliases resolved, that ParseContentEncoding returns.
+// The decoders of ctx take precedence over r.Decoders, r.Decoders takes precedence over the registered decoders,
+// and the registered decoders take precedence over the built-in decoders
+func (r *RoundTripper) decoder(ctx context.Context, name string, res *http.Response) (DecoderFactory, bool) {
+	if !r.allowed(ctx, name) {
+		return nil, false
+	}
+	if ctxDecoders, ok := ctx.Value(decodersKey{}).(map[string]DecoderFactory); ok {
+		if f, ok := lookupFold(ctxDecoders, name); ok && f != nil {
+			return f, true
+		}
+	}
+	if f, ok := lookupFold(r.Decoders, name); ok && f != nil {
+		return f, true
+	}
+	registryMu.RLock()
+	f, ok := decoders[name]
+	registryMu.RUnlock()
+	if ok {
+		return f, true
+	}
+	f = r.builtinDecoder(name, res)
+	return f, f != nil
+}
+
+// resolveAlias returns the content coding name that name is an alias of, or name itself if it is not an alias.
+// r.Aliases takes precedence over the registered aliases
+func (r *RoundTripper) resolveAlias(name string) string {
+	if to, ok := lookupFold(r.Aliases, name); ok {
+		return strings.ToLower(to)
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if to, ok := aliases[name]; ok {
+		return to
+	}
+	return name
+}
+
+// lookupFold returns the value of m keyed by name, matching the key case-insensitively.
+// The content coding names are case-insensitive tokens (RFC 9110 Section 8.4.1)
+func lookupFold[V any](m map[string]V, name string) (V, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	var zero V
+	return zero, false
+}
@@ -0,0 +1,501 @@
+package decompress_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/kei2100/decompress-roundtripper/codectest"
+)
+
+func init() {
+	decompress.RegisterDecoderFunc("x-rot13", newROT13Reader)
+	decompress.RegisterAlias("x-rot13-alias", "x-rot13")
+	decompress.RegisterDecoderFunc("X-Rot13-Upper", newROT13Reader)
+	decompress.RegisterDecoderFunc("x-broken", func(io.Reader) (io.ReadCloser, error) {
+		return nil, errors.New("broken")
+	})
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	tt := []struct {
+		title                      string
+		resp                       *http.Response
+		wantBody                   string
+		wantErr                    bool
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:    "registered",
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-rot13"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "registered with built-in",
+			resp:     newResponse(t, gzipBytes(rot13([]byte("foobarbaz"))), "x-rot13, gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:   "decoder error",
+			resp:    newResponse(t, []byte("foobarbaz"), "x-broken"),
+			wantErr: true,
+		},
+		{
+			title:                      "not registered",
+			resp:                       newResponse(t, []byte("foobarbaz"), "x-unknown"),
+			wantErrUnsupportedEncoding: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: te.resp}}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the decoder is created at the first Read
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUnregisterDecoder(t *testing.T) {
+	decompress.RegisterDecoderFunc("x-rot13-unregister", newROT13Reader)
+	decompress.RegisterAlias("x-rot13-unregister-alias", "x-rot13-unregister")
+	roundTrip := func(contentEncoding string) error {
+		dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: newResponse(t, rot13([]byte("foobarbaz")), contentEncoding)}}
+		req, _ := http.NewRequest("GET", "/", nil)
+		_, err := dr.RoundTrip(req)
+		return err
+	}
+	if err := roundTrip("x-rot13-unregister-alias"); err != nil {
+		t.Fatal(err)
+	}
+	decompress.UnregisterAlias("x-rot13-unregister-alias")
+	var wantErr *decompress.ErrUnsupportedEncoding
+	if err := roundTrip("x-rot13-unregister-alias"); !errors.As(err, &wantErr) {
+		t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+	}
+	decompress.UnregisterDecoder("x-rot13-unregister")
+	if err := roundTrip("x-rot13-unregister"); !errors.As(err, &wantErr) {
+		t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+	}
+}
+
+func TestRegisterDecoder_Concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				decompress.RegisterDecoderFunc("x-rot13-concurrent", newROT13Reader)
+				decompress.RegisterAlias("x-rot13-concurrent-alias", "x-rot13-concurrent")
+				decompress.SetPreference("x-rot13-concurrent", 0.5)
+				decompress.UnregisterDecoder("x-rot13-concurrent")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			dr := decompress.RoundTripper{}
+			for j := 0; j < 100; j++ {
+				dr.Wrap = &stubRoundTripper{response: newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-concurrent-alias")}
+				req, _ := http.NewRequest("GET", "/", nil)
+				var unsupported *decompress.ErrUnsupportedEncoding
+				if _, err := dr.RoundTrip(req); err != nil && !errors.As(err, &unsupported) {
+					t.Error(err)
+				}
+				dr.AcceptEncoding()
+			}
+		}()
+	}
+	wg.Wait()
+	decompress.UnregisterAlias("x-rot13-concurrent-alias")
+	decompress.SetPreference("x-rot13-concurrent", 0)
+}
+
+func TestRoundTripper_Decoders(t *testing.T) {
+	tt := []struct {
+		title                      string
+		decoders                   map[string]decompress.DecoderFactory
+		resp                       *http.Response
+		wantBody                   string
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:    "extend",
+			decoders: map[string]decompress.DecoderFactory{"x-rot13-local": decompress.DecoderFunc(newROT13Reader)},
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-local"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "override built-in",
+			decoders: map[string]decompress.DecoderFactory{"gzip": decompress.DecoderFunc(newROT13Reader)},
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title: "override registered",
+			decoders: map[string]decompress.DecoderFactory{"x-rot13": decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+				return io.NopCloser(r), nil
+			})},
+			resp:     newResponse(t, []byte("foobarbaz"), "x-rot13"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:                      "other RoundTripper",
+			resp:                       newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-local"),
+			wantErrUnsupportedEncoding: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: te.resp}, Decoders: te.decoders}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWithDecoders(t *testing.T) {
+	passthrough := decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	})
+	tt := []struct {
+		title                      string
+		ctx                        context.Context
+		decoders                   map[string]decompress.DecoderFactory
+		resp                       *http.Response
+		wantBody                   string
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:    "extend",
+			ctx:      decompress.WithDecoders(context.Background(), map[string]decompress.DecoderFactory{"x-rot13-ctx": decompress.DecoderFunc(newROT13Reader)}),
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-ctx"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "override RoundTripper decoders",
+			ctx:      decompress.WithDecoders(context.Background(), map[string]decompress.DecoderFactory{"x-rot13-local": passthrough}),
+			decoders: map[string]decompress.DecoderFactory{"x-rot13-local": decompress.DecoderFunc(newROT13Reader)},
+			resp:     newResponse(t, []byte("foobarbaz"), "x-rot13-local"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title: "merged",
+			ctx: decompress.WithDecoders(
+				decompress.WithDecoders(context.Background(), map[string]decompress.DecoderFactory{"x-rot13-ctx": decompress.DecoderFunc(newROT13Reader)}),
+				map[string]decompress.DecoderFactory{"x-passthrough": passthrough},
+			),
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-ctx, x-passthrough"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:                      "other request",
+			ctx:                        context.Background(),
+			resp:                       newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-ctx"),
+			wantErrUnsupportedEncoding: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: te.resp}, Decoders: te.decoders}
+			req, _ := http.NewRequestWithContext(te.ctx, "GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_Aliases(t *testing.T) {
+	tt := []struct {
+		title                      string
+		aliases                    map[string]string
+		resp                       *http.Response
+		wantBody                   string
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:    "registered",
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-alias"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "built-in",
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "x-gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "RoundTripper alias",
+			aliases:  map[string]string{"x-gz": "gzip"},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "x-gz"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "override registered",
+			aliases:  map[string]string{"x-rot13-alias": "gzip"},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "x-rot13-alias"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:                      "not recursive",
+			aliases:                    map[string]string{"x-alias-alias": "x-rot13-alias"},
+			resp:                       newResponse(t, rot13([]byte("foobarbaz")), "x-alias-alias"),
+			wantErrUnsupportedEncoding: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: te.resp}, Aliases: te.aliases}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_CaseInsensitive(t *testing.T) {
+	tt := []struct {
+		title    string
+		dr       decompress.RoundTripper
+		resp     *http.Response
+		wantBody string
+	}{
+		{
+			title:    "built-in",
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "GZIP"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "built-in chain",
+			resp:     newResponse(t, gzipBytes(deflateBytes([]byte("foobarbaz"))), "Deflate, Gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "built-in alias",
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "X-GZIP"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "registered",
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "X-ROT13"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "registered by mixed case name",
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-upper"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "registered alias",
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "X-Rot13-Alias"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "RoundTripper decoders and aliases",
+			dr:       decompress.RoundTripper{Decoders: map[string]decompress.DecoderFactory{"X-Local": decompress.DecoderFunc(newROT13Reader)}, Aliases: map[string]string{"X-Loc": "X-LOCAL"}},
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-loc"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "encodings",
+			dr:       decompress.RoundTripper{Encodings: []string{"GZIP"}},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantBody: "foobarbaz",
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := te.dr
+			dr.Wrap = &stubRoundTripper{response: te.resp}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecoderFunc_NewDecoder(t *testing.T) {
+	d, err := decompress.DecoderFunc(newROT13Reader).NewDecoder(bytes.NewReader(rot13([]byte("foo"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "foo"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+	if err := d.Reset(bytes.NewReader(rot13([]byte("barbaz")))); err != nil {
+		t.Fatal(err)
+	}
+	b, err = io.ReadAll(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "barbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+}
+
+func TestDecoder_Reset(t *testing.T) {
+	foo, barbaz := []byte("foo"), []byte("barbaz")
+	bar, baz := []byte("bar"), []byte("baz")
+	tt := []struct {
+		title    string
+		rt       decompress.RoundTripper
+		encoding string
+		first    []byte
+		second   []byte
+	}{
+		{title: "gzip", encoding: "gzip", first: gzipBytes(foo), second: gzipBytes(barbaz)},
+		{title: "gzip disable multistream", rt: decompress.RoundTripper{Gzip: decompress.GzipOptions{DisableMultistream: true}}, encoding: "gzip", first: gzipBytes(foo), second: concat(gzipBytes(barbaz), gzipBytes(foo))},
+		{title: "gzip strict", rt: decompress.RoundTripper{Gzip: decompress.GzipOptions{Strict: true}}, encoding: "gzip", first: gzipBytes(foo), second: concat(gzipBytes(bar), gzipBytes(baz))},
+		{title: "gzip skip checksum", rt: decompress.RoundTripper{Gzip: decompress.GzipOptions{SkipChecksum: true}}, encoding: "gzip", first: gzipBytes(foo), second: concat(gzipBytes(bar), gzipBytes(baz))},
+		{title: "deflate zlib to raw", encoding: "deflate", first: zlibBytes(foo), second: deflateBytes(barbaz)},
+		{title: "deflate raw to raw", encoding: "deflate", first: deflateBytes(foo), second: deflateBytes(barbaz)},
+		{title: "compress", encoding: "compress", first: compressBytes(foo, 16), second: compressBytes(barbaz, 9)},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			d, err := decompress.BuiltinDecoder(&te.rt, te.encoding).NewDecoder(bytes.NewReader(te.first))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			b, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "foo"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			if err := d.Reset(bytes.NewReader(te.second)); err != nil {
+				t.Fatal(err)
+			}
+			b, err = io.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "barbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBuiltinDecoder_Conformance(t *testing.T) {
+	tt := []struct {
+		title    string
+		encoding string
+		encode   func([]byte) []byte
+		// the compress format has no end marker
+		skipTruncated bool
+	}{
+		{title: "gzip", encoding: "gzip", encode: gzipBytes},
+		{title: "deflate", encoding: "deflate", encode: deflateBytes},
+		{title: "deflate zlib", encoding: "deflate", encode: zlibBytes},
+		{title: "compress", encoding: "compress", encode: func(b []byte) []byte { return compressBytes(b, 16) }, skipTruncated: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), codectest.Suite{
+			Factory: decompress.BuiltinDecoder(&decompress.RoundTripper{}, te.encoding),
+			Encode: func(b []byte) ([]byte, error) {
+				return te.encode(b), nil
+			},
+			SkipTruncated: te.skipTruncated,
+		}.Run)
+	}
+}
+
+func newROT13Reader(r io.Reader) (io.ReadCloser, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(rot13(b))), nil
+}
+
+func rot13(b []byte) []byte {
+	dst := make([]byte, len(b))
+	for i, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z':
+			c = 'a' + (c-'a'+13)%26
+		case 'A' <= c && c <= 'Z':
+			c = 'A' + (c-'A'+13)%26
+		}
+		dst[i] = c
+	}
+	return dst
+}
@@ -0,0 +1,82 @@
+package decompress
+
+import (
+	"compress/flate"
+	"compress/zlib"
+	"io"
+)
+
+// NewDeflateFactory returns the factory of the `deflate` decoders, that creates the zlib readers by newZlib and
+// the raw deflate readers by newFlate. It allows the implementations compatible with compress/zlib and compress/flate to be used.
+// The readers should implement flate.Resetter to be reset, otherwise new readers are created on Reset
+func NewDeflateFactory(newZlib func(r io.Reader) (io.ReadCloser, error), newFlate func(r io.Reader) io.ReadCloser) DecoderFactory {
+	return &deflateFactory{newZlib: newZlib, newFlate: newFlate}
+}
+
+type deflateFactory struct {
+	newZlib  func(r io.Reader) (io.ReadCloser, error)
+	newFlate func(r io.Reader) io.ReadCloser
+}
+
+func (f *deflateFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	d := &deflateDecoder{factory: f}
+	d.src.reset(r)
+	if err := d.init(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// deflateDecoder is the decoder for the `deflate` content coding.
+// RFC 9110 defines `deflate` as the zlib format, but some servers send raw deflate data without the zlib wrapper,
+// so the zlib header is peeked to decide which format is used.
+type deflateDecoder struct {
+	factory     *deflateFactory
+	src         sourceReader
+	rc          io.ReadCloser
+	zlibWrapped bool
+}
+
+func (d *deflateDecoder) Read(p []byte) (int, error) {
+	return d.rc.Read(p)
+}
+
+func (d *deflateDecoder) Close() error {
+	return d.rc.Close()
+}
+
+func (d *deflateDecoder) Reset(r io.Reader) error {
+	d.src.reset(r)
+	return d.init()
+}
+
+// init peeks the zlib header, and creates or resets the reader for the format
+func (d *deflateDecoder) init() error {
+	br := d.src.br
+	zlibWrapped := false
+	if h, err := br.Peek(2); err == nil {
+		zlibWrapped = isZlibHeader(h[0], h[1])
+	}
+	if rs, ok := d.rc.(flate.Resetter); ok && zlibWrapped == d.zlibWrapped {
+		return rs.Reset(br, nil)
+	}
+	d.zlibWrapped = zlibWrapped
+	if !zlibWrapped {
+		d.rc = d.factory.newFlate(br)
+		return nil
+	}
+	rc, err := d.factory.newZlib(br)
+	if err != nil {
+		d.rc = io.NopCloser(&errReader{err: err})
+		return err
+	}
+	d.rc = rc
+	return nil
+}
+
+// isZlibHeader reports whether the CMF and FLG bytes form a valid zlib header. Refs RFC 1950
+func isZlibHeader(cmf, flg byte) bool {
+	return cmf&0x0f == 8 && cmf>>4 <= 7 && (uint16(cmf)<<8|uint16(flg))%31 == 0
+}
+
+var stdDeflateFactory = NewPooledFactory(NewDeflateFactory(zlib.NewReader, flate.NewReader))
@@ -0,0 +1,50 @@
+package decompress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DictionaryProvider provides the dictionaries for the Compression Dictionary Transport (RFC 9842).
+// Only the `dcz` content coding is supported, see zstd.NewDCZFactory. The `dcb` content coding is not supported
+type DictionaryProvider interface {
+	// Dictionary returns the dictionary whose SHA-256 hash equals to the hash.
+	// If the dictionary is not found, an error should be returned
+	Dictionary(hash [sha256.Size]byte) ([]byte, error)
+}
+
+// DictionaryProviderFunc is an adapter to allow the use of ordinary functions as DictionaryProvider
+type DictionaryProviderFunc func(hash [sha256.Size]byte) ([]byte, error)
+
+// Dictionary calls f(hash)
+func (f DictionaryProviderFunc) Dictionary(hash [sha256.Size]byte) ([]byte, error) {
+	return f(hash)
+}
+
+// ReadDictionary reads the header of the dictionary-compressed stream, that consists of magic and the SHA-256 hash of
+// the dictionary, and returns the dictionary that p provides for the hash. Refs RFC 9842
+func ReadDictionary(r io.Reader, magic []byte, p DictionaryProvider) ([]byte, error) {
+	header := make([]byte, len(magic)+sha256.Size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, magic) {
+		return nil, errors.New("decompress: invalid dictionary-compressed stream header")
+	}
+	var hash [sha256.Size]byte
+	copy(hash[:], header[len(magic):])
+	dict, err := p.Dictionary(hash)
+	if err != nil {
+		return nil, fmt.Errorf("decompress: get dictionary %x: %w", hash, err)
+	}
+	if sha256.Sum256(dict) != hash {
+		return nil, fmt.Errorf("decompress: dictionary hash mismatch %x", hash)
+	}
+	return dict, nil
+}
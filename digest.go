@@ -0,0 +1,119 @@
+package decompress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// digestAlgorithms is the supported digest algorithms in the order of preference. Refs RFC 9530 Section 5
+var digestAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{name: "sha-512", new: sha512.New},
+	{name: "sha-256", new: sha256.New},
+}
+
+// digest is the expected digest of the representation
+type digest struct {
+	algorithm string
+	new       func() hash.Hash
+	want      []byte
+}
+
+// parseDigest returns the digest of the most preferred algorithm in the Repr-Digest header (RFC 9530),
+// or the legacy Digest header (RFC 3230) if the response does not have Repr-Digest.
+// It returns nil if the headers have no supported algorithm
+func parseDigest(h http.Header) *digest {
+	digests := make(map[string][]byte)
+	if values := h.Values("Repr-Digest"); len(values) > 0 {
+		// a dictionary of the byte sequences, e.g. `sha-256=:base64:`
+		for _, v := range values {
+			for _, member := range strings.Split(v, ",") {
+				k, v, _ := strings.Cut(member, "=")
+				v, _, _ = strings.Cut(v, ";")
+				v = strings.TrimSpace(v)
+				if len(v) < 2 || v[0] != ':' || v[len(v)-1] != ':' {
+					continue
+				}
+				if b, err := base64.StdEncoding.DecodeString(v[1 : len(v)-1]); err == nil {
+					digests[strings.TrimSpace(k)] = b
+				}
+			}
+		}
+	} else {
+		// e.g. `SHA-256=base64`
+		for _, v := range h.Values("Digest") {
+			for _, member := range strings.Split(v, ",") {
+				k, v, _ := strings.Cut(member, "=")
+				if b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v)); err == nil {
+					digests[strings.ToLower(strings.TrimSpace(k))] = b
+				}
+			}
+		}
+	}
+	for _, a := range digestAlgorithms {
+		if want, ok := digests[a.name]; ok {
+			return &digest{algorithm: a.name, new: a.new, want: want}
+		}
+	}
+	return nil
+}
+
+// ErrDigestMismatch represents the error that the digest of the representation does not match the Repr-Digest or
+// Digest header
+type ErrDigestMismatch struct {
+	Algorithm string
+}
+
+// Error implements the error interface
+func (e *ErrDigestMismatch) Error() string {
+	return fmt.Sprintf("decompress: %s digest mismatch", e.Algorithm)
+}
+
+// Is reports whether target is ErrCorrupted
+func (e *ErrDigestMismatch) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// hashingReadCloser writes the bytes read to h
+type hashingReadCloser struct {
+	io.ReadCloser
+	h hash.Hash
+}
+
+func (c *hashingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+// digestReadCloser verifies the digest of the bytes read from rc at EOF, and returns ErrDigestMismatch on the mismatch.
+// RFC 9530 defines the digest of the representation with the content codings applied, while some servers compute it
+// of the decoded data, so either the digest of the decoded data or that of the encoded data read from encoded is accepted
+type digestReadCloser struct {
+	rc      io.ReadCloser
+	d       *digest
+	h       hash.Hash
+	encoded *hashingReadCloser
+}
+
+func (l *digestReadCloser) Read(p []byte) (int, error) {
+	n, err := l.rc.Read(p)
+	l.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(l.h.Sum(nil), l.d.want) && !bytes.Equal(l.encoded.h.Sum(nil), l.d.want) {
+		return n, &ErrDigestMismatch{Algorithm: l.d.algorithm}
+	}
+	return n, err
+}
+
+func (l *digestReadCloser) Close() error {
+	return l.rc.Close()
+}
@@ -0,0 +1,78 @@
+package decompress_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_RoundTrip_VerifyDigest(t *testing.T) {
+	encoded := gzipBytes([]byte("foobarbaz"))
+	sha256Of := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	sha512Of := func(b []byte) string {
+		sum := sha512.Sum512(b)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	md5Of := func(b []byte) string {
+		sum := md5.Sum(b)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	tt := []struct {
+		title        string
+		header       http.Header
+		wantMismatch bool
+	}{
+		{title: "repr-digest of the decoded data", header: http.Header{"Repr-Digest": {"sha-256=:" + sha256Of([]byte("foobarbaz")) + ":"}}},
+		{title: "repr-digest of the encoded data", header: http.Header{"Repr-Digest": {"sha-256=:" + sha256Of(encoded) + ":"}}},
+		{title: "repr-digest mismatch", header: http.Header{"Repr-Digest": {"sha-256=:" + sha256Of([]byte("foo")) + ":"}}, wantMismatch: true},
+		{title: "repr-digest prefers sha-512", header: http.Header{"Repr-Digest": {"sha-256=:" + sha256Of([]byte("foo")) + ":, sha-512=:" + sha512Of([]byte("foobarbaz")) + ":"}}},
+		{title: "legacy digest", header: http.Header{"Digest": {"SHA-256=" + sha256Of([]byte("foobarbaz"))}}},
+		{title: "legacy digest mismatch", header: http.Header{"Digest": {"SHA-256=" + sha256Of([]byte("foo"))}}, wantMismatch: true},
+		{title: "repr-digest takes precedence over digest", header: http.Header{"Repr-Digest": {"sha-256=:" + sha256Of([]byte("foobarbaz")) + ":"}, "Digest": {"SHA-256=" + sha256Of([]byte("foo"))}}},
+		{title: "unsupported algorithm", header: http.Header{"Digest": {"MD5=" + md5Of([]byte("foo"))}}},
+		{title: "no digest", header: http.Header{}},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, encoded, "gzip")
+			for k, v := range te.header {
+				resp.Header[k] = v
+			}
+			dr := decompress.RoundTripper{
+				Wrap:         &stubRoundTripper{response: resp},
+				VerifyDigest: true,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if te.wantMismatch {
+				var wantErr *decompress.ErrDigestMismatch
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrDigestMismatch", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package decompress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+)
+
+// DumpResponse is like httputil.DumpResponse, but dumps the body decompressed if it is still compressed, e.g. for
+// debugging the responses of the transports without the RoundTripper. The removed Content-Encoding and Content-Length
+// headers are annotated as X-Original-Content-Encoding and X-Original-Content-Length, and Content-Length is set to
+// the length of the decompressed body. The body of an unsupported content coding is dumped as is.
+// If body is true, the body of res is read, and replaced with the copy of the bytes read to be read again.
+// If body is false, the headers are dumped as is, since the body is not decompressed
+func DumpResponse(res *http.Response, body bool) ([]byte, error) {
+	if !body {
+		return httputil.DumpResponse(res, false)
+	}
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	cp := *res
+	cp.Header = res.Header.Clone()
+	cp.Body = io.NopCloser(bytes.NewReader(b))
+	ctx := context.Background()
+	if res.Request != nil {
+		ctx = res.Request.Context()
+	}
+	r := RoundTripper{PreserveOriginalHeaders: true, PassThroughUnsupported: true}
+	decoded, err := r.decode(ctx, res.Request, &cp, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !decoded.Uncompressed {
+		return httputil.DumpResponse(decoded, true)
+	}
+	defer decoded.Body.Close()
+	if b, err = io.ReadAll(decoded.Body); err != nil {
+		return nil, err
+	}
+	decoded.Body = io.NopCloser(bytes.NewReader(b))
+	decoded.ContentLength = int64(len(b))
+	decoded.TransferEncoding = nil
+	return httputil.DumpResponse(decoded, true)
+}
@@ -0,0 +1,87 @@
+package decompress_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestDumpResponse(t *testing.T) {
+	gz := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title    string
+		body     []byte
+		encoding string
+		dumpBody bool
+		wantDump string
+		wantErr  bool
+	}{
+		{
+			title:    "decompressed",
+			body:     gz,
+			encoding: "gzip",
+			dumpBody: true,
+			wantDump: "HTTP/1.1 200 OK\r\nContent-Length: 9\r\nX-Original-Content-Encoding: gzip\r\n" +
+				"X-Original-Content-Length: " + strconv.Itoa(len(gz)) + "\r\n\r\nfoobarbaz",
+		},
+		{
+			title:    "not compressed",
+			body:     []byte("foobarbaz"),
+			dumpBody: true,
+			wantDump: "HTTP/1.1 200 OK\r\nContent-Length: 9\r\n\r\nfoobarbaz",
+		},
+		{
+			title:    "unsupported",
+			body:     []byte("foobarbaz"),
+			encoding: "x-unknown",
+			dumpBody: true,
+			wantDump: "HTTP/1.1 200 OK\r\nContent-Length: 9\r\nContent-Encoding: x-unknown\r\n\r\nfoobarbaz",
+		},
+		{
+			title:    "without body",
+			body:     gz,
+			encoding: "gzip",
+			wantDump: "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(gz)) + "\r\nContent-Encoding: gzip\r\n\r\n",
+		},
+		{
+			title:    "corrupted",
+			body:     gz[:20],
+			encoding: "gzip",
+			dumpBody: true,
+			wantErr:  true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, te.body, te.encoding)
+			b, err := decompress.DumpResponse(resp, te.dumpBody)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantDump; got != want {
+				t.Errorf("dump got %q, want %q", got, want)
+			}
+			// the response remains untouched
+			if got, want := resp.Header.Get("Content-Encoding"), te.encoding; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := body, te.body; !bytes.Equal(got, want) {
+				t.Errorf("body got %x, want %x", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package decompress
+
+import (
+	"errors"
+	"io"
+)
+
+// The causes of the decode errors, so that the callers can implement the retry and alerting policies per cause:
+//
+//	if errors.Is(err, decompress.ErrTruncated) {
+//		// retry the request
+//	}
+//
+// The errors of the decoders are matched by ErrTruncated if the compressed stream ends unexpectedly, or by ErrCorrupted
+// otherwise. The errors of the underlying body, e.g. the network errors, are not matched by them, and are returned as is.
+// The typed errors are also matched by the causes, e.g. ErrChecksum and ErrDigestMismatch by ErrCorrupted, and
+// ErrReadTimeout by ErrTimeout. The limits of the size, ErrTooLarge and ErrRatioExceeded, are matched by errors.As
+var (
+	// ErrCorrupted is the cause of the errors that the compressed stream is malformed
+	ErrCorrupted = errors.New("decompress: corrupted stream")
+	// ErrTruncated is the cause of the errors that the compressed stream ends unexpectedly
+	ErrTruncated = errors.New("decompress: truncated stream")
+	// ErrTimeout is the cause of the errors that the decoding does not complete in time
+	ErrTimeout = errors.New("decompress: timeout")
+)
+
+// decodeError is the error of a decoder annotated by the cause. The message is the same as err
+type decodeError struct {
+	err   error
+	cause error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *decodeError) Unwrap() []error {
+	return []error{e.err, e.cause}
+}
+
+// classifyDecodeError annotates err returned by a decoder with the cause. src is the last error of the underlying body,
+// and dst is the error of the writer of WriteTo, that are returned as is
+func classifyDecodeError(err, src, dst error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	var de *decodeError
+	if errors.As(err, &de) {
+		// annotated by the previous decoder already
+		return err
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return &decodeError{err: err, cause: ErrTruncated}
+	}
+	if err == errDecoderClosed || (src != nil && errors.Is(err, src)) || (dst != nil && errors.Is(err, dst)) {
+		return err
+	}
+	var panicErr *ErrDecoderPanic
+	if errors.As(err, &panicErr) {
+		return err
+	}
+	return &decodeError{err: err, cause: ErrCorrupted}
+}
+
+// recordingWriter records the error of w
+type recordingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,137 @@
+package decompress_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// failingBody returns err after the bytes of r
+type failingBody struct {
+	r   io.Reader
+	err error
+}
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *failingBody) Close() error {
+	return nil
+}
+
+func TestRoundTripper_RoundTrip_ErrorCauses(t *testing.T) {
+	gz := gzipBytes([]byte("foobarbaz"))
+	checksum := gzipBytes([]byte("foobarbaz"))
+	checksum[len(checksum)-8] ^= 0xff
+	errNetwork := errors.New("connection reset")
+	withBody := func(resp *http.Response, body io.ReadCloser) *http.Response {
+		resp.Body = body
+		return resp
+	}
+	tt := []struct {
+		title     string
+		dr        decompress.RoundTripper
+		resp      *http.Response
+		wantErr   error
+		wantCause error
+	}{
+		{
+			title:     "truncated",
+			resp:      newResponse(t, gz[:20], "gzip"),
+			wantErr:   io.ErrUnexpectedEOF,
+			wantCause: decompress.ErrTruncated,
+		},
+		{
+			title:     "invalid header",
+			resp:      newResponse(t, []byte("foobarbazqux"), "gzip"),
+			wantCause: decompress.ErrCorrupted,
+		},
+		{
+			title:     "checksum mismatch",
+			resp:      newResponse(t, checksum, "gzip"),
+			wantCause: decompress.ErrCorrupted,
+		},
+		{
+			title:     "chained",
+			resp:      newResponse(t, gzipBytes([]byte("foobarbazqux")), "gzip, gzip"),
+			wantCause: decompress.ErrCorrupted,
+		},
+		{
+			title:   "network error",
+			resp:    withBody(newResponse(t, nil, "gzip"), &failingBody{r: bytes.NewReader(gz[:20]), err: errNetwork}),
+			wantErr: errNetwork,
+		},
+		{
+			title:     "read timeout",
+			dr:        decompress.RoundTripper{ReadTimeout: 10 * time.Millisecond},
+			resp:      withBody(newResponse(t, nil, "gzip"), newStallBody(gz, true)),
+			wantCause: decompress.ErrTimeout,
+		},
+		{
+			title:     "content length mismatch",
+			dr:        decompress.RoundTripper{VerifyContentLength: true},
+			resp:      withBody(newResponse(t, gz, "gzip"), &failingBody{r: bytes.NewReader(gz[:20]), err: io.ErrUnexpectedEOF}),
+			wantCause: decompress.ErrTruncated,
+		},
+	}
+	causes := []error{decompress.ErrCorrupted, decompress.ErrTruncated, decompress.ErrTimeout}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := te.dr
+			dr.Wrap = &stubRoundTripper{response: te.resp}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			if err == nil {
+				t.Fatal("got nil, want error")
+			}
+			if te.wantErr != nil && !errors.Is(err, te.wantErr) {
+				t.Errorf("got %v, want %v", err, te.wantErr)
+			}
+			for _, cause := range causes {
+				if got, want := errors.Is(err, cause), cause == te.wantCause; got != want {
+					t.Errorf("errors.Is(%v, %v) got %v, want %v", err, cause, got, want)
+				}
+			}
+		})
+	}
+}
+
+// failingWriter fails all the writes
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func TestRoundTripper_RoundTrip_ErrorCauses_WriteTo(t *testing.T) {
+	dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")}}
+	req, _ := http.NewRequest("GET", "/", nil)
+	resp, err := dr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, err = resp.Body.(io.WriterTo).WriteTo(failingWriter{})
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("got %v, want %v", err, io.ErrShortWrite)
+	}
+	if errors.Is(err, decompress.ErrCorrupted) {
+		t.Errorf("errors.Is(%v, ErrCorrupted) got true, want false", err)
+	}
+}
@@ -0,0 +1,19 @@
+package decompress
+
+import "io"
+
+// BuiltinDecoder exports builtinDecoder for testing
+func BuiltinDecoder(r *RoundTripper, name string) DecoderFactory {
+	return r.builtinDecoder(name, nil)
+}
+
+// NewVCDIFFReader exports newVCDIFFReader for testing, with the size of the decoded data retained for
+// the VCD_TARGET windows
+func NewVCDIFFReader(r io.Reader, base []byte, history int) (io.Reader, error) {
+	v, err := newVCDIFFReader(r, base)
+	if err != nil {
+		return nil, err
+	}
+	v.history = history
+	return v, nil
+}
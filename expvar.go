@@ -0,0 +1,12 @@
+package decompress
+
+import "expvar"
+
+// PublishExpvar publishes the Stats of r as the expvar variable name, so that the decompression activity is served by
+// the /debug/vars handler of expvar, e.g. `{"Decoded": {"gzip": 10}, "PassThroughs": 1, ...}`. The variable reports
+// the snapshot of the Stats at each request. Like expvar.Publish, PublishExpvar panics if name is already registered
+func (r *RoundTripper) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return r.Stats()
+	}))
+}
@@ -0,0 +1,65 @@
+package decompress
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// allowedMethod reports whether the method of req is allowed by r.Methods
+func (r *RoundTripper) allowedMethod(req *http.Request) bool {
+	if r.Methods == nil {
+		return true
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	return slices.Contains(r.Methods, method)
+}
+
+// skip reports whether res should be returned without decompression
+func (r *RoundTripper) skip(res *http.Response) bool {
+	if r.StatusFilter != nil && !r.StatusFilter(res.StatusCode) {
+		return true
+	}
+	return r.skipContentType(res.Header.Get("Content-Type"))
+}
+
+// skipContentType reports whether the media type of contentType matches r.SkipContentTypes
+func (r *RoundTripper) skipContentType(contentType string) bool {
+	if len(r.SkipContentTypes) == 0 || contentType == "" {
+		return false
+	}
+	mediaType := parseMediaType(contentType)
+	for _, t := range r.SkipContentTypes {
+		t = strings.ToLower(t)
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isEventStream reports whether res is the stream of the server-sent events, that must not be buffered
+func isEventStream(res *http.Response) bool {
+	return parseMediaType(res.Header.Get("Content-Type")) == "text/event-stream"
+}
+
+// parseMediaType returns the lower-cased media type of contentType without the parameters
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// tolerate the invalid parameters
+		mediaType, _, _ = strings.Cut(contentType, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	}
+	return mediaType
+}
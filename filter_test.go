@@ -0,0 +1,137 @@
+package decompress_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_RoundTrip_SkipContentTypes(t *testing.T) {
+	body := gzipBytes([]byte("foobarbaz"))
+	skipTypes := []string{"application/zip", "Application/Octet-Stream", "video/*"}
+	tt := []struct {
+		title       string
+		contentType string
+		wantSkipped bool
+	}{
+		{title: "match", contentType: "application/zip", wantSkipped: true},
+		{title: "match case insensitive with parameters", contentType: "application/octet-stream; name=\"a.bin\"", wantSkipped: true},
+		{title: "match wildcard", contentType: "video/mp4", wantSkipped: true},
+		{title: "invalid parameters", contentType: "application/zip; ;", wantSkipped: true},
+		{title: "not match", contentType: "application/json"},
+		{title: "not match wildcard prefix", contentType: "videos/mp4"},
+		{title: "no content type"},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			res := newResponse(t, body, "gzip")
+			if te.contentType != "" {
+				res.Header.Set("Content-Type", te.contentType)
+			}
+			dr := decompress.RoundTripper{
+				Wrap:             &stubRoundTripper{response: res},
+				SkipContentTypes: skipTypes,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBody, wantContentEncoding := "foobarbaz", ""
+			if te.wantSkipped {
+				wantBody, wantContentEncoding = string(body), "gzip"
+			}
+			if got, want := string(copyAndReadAll(t, resp)), wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Encoding"), wantContentEncoding; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_StatusFilter(t *testing.T) {
+	body := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title       string
+		opt         decompress.Option
+		statusCode  int
+		wantSkipped bool
+	}{
+		{title: "no filter", statusCode: 500},
+		{title: "classes match", opt: decompress.WithStatusClasses(2), statusCode: 204},
+		{title: "classes not match", opt: decompress.WithStatusClasses(2), statusCode: 302, wantSkipped: true},
+		{title: "multiple classes", opt: decompress.WithStatusClasses(2, 4), statusCode: 404},
+		{title: "filter", opt: decompress.WithStatusFilter(func(code int) bool { return code == 200 }), statusCode: 201, wantSkipped: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			res := newResponse(t, body, "gzip")
+			res.StatusCode = te.statusCode
+			opts := []decompress.Option{decompress.WithTransport(&stubRoundTripper{response: res})}
+			if te.opt != nil {
+				opts = append(opts, te.opt)
+			}
+			dr := decompress.New(opts...)
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBody := "foobarbaz"
+			if te.wantSkipped {
+				wantBody = string(body)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_Methods(t *testing.T) {
+	body := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title       string
+		methods     []string
+		method      string
+		wantSkipped bool
+	}{
+		{title: "no methods", method: "PROPFIND"},
+		{title: "match", methods: []string{"GET", "POST"}, method: "POST"},
+		{title: "empty method is GET", methods: []string{"GET"}, method: ""},
+		{title: "not match", methods: []string{"GET", "POST"}, method: "PROPFIND", wantSkipped: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var acceptEncoding string
+			dr := decompress.RoundTripper{
+				Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					acceptEncoding = req.Header.Get("Accept-Encoding")
+					return newResponse(t, body, "gzip"), nil
+				}),
+				AdvertiseEncodings: true,
+				Methods:            te.methods,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Method = te.method
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBody, wantAdvertised := "foobarbaz", true
+			if te.wantSkipped {
+				wantBody, wantAdvertised = string(body), false
+			}
+			if got, want := string(copyAndReadAll(t, resp)), wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+			if got, want := acceptEncoding != "", wantAdvertised; got != want {
+				t.Errorf("Accept-Encoding advertised got %v, want %v", got, want)
+			}
+		})
+	}
+}
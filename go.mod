@@ -1,5 +1,13 @@
 module github.com/kei2100/decompress-roundtripper
 
-go 1.20
+go 1.21
 
-require github.com/andybalholm/brotli v1.1.1
+require (
+	github.com/DataDog/zstd v1.5.7
+	github.com/andybalholm/brotli v1.1.1
+	github.com/google/brotli/go/cbrotli v1.1.0
+	github.com/klauspost/compress v1.17.11
+	github.com/klauspost/pgzip v1.2.6
+	github.com/pierrec/lz4/v4 v4.1.21
+	github.com/ulikunitz/xz v0.5.15
+)
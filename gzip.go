@@ -0,0 +1,363 @@
+package decompress
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// GzipReader is the interface of the gzip readers, that is implemented by the gzip.Reader of compress/gzip and
+// the compatible implementations such as github.com/klauspost/compress/gzip
+type GzipReader interface {
+	io.ReadCloser
+	Reset(r io.Reader) error
+	Multistream(ok bool)
+}
+
+// NewGzipFactory returns the factory of the `gzip` decoders, that creates the gzip readers by newReader.
+// It allows the implementations compatible with compress/gzip to be used with the options
+// If opts.SkipChecksum is set, the factory decodes the members by compress/flate instead, see NewGzipFlateFactory
+func NewGzipFactory(opts GzipOptions, newReader func(r io.Reader) (GzipReader, error)) DecoderFactory {
+	if opts.SkipChecksum && !opts.Strict {
+		return NewGzipFlateFactory(opts, func(r io.Reader) io.ReadCloser { return flate.NewReader(r) })
+	}
+	return &gzipFactory{multistream: !opts.DisableMultistream, strict: opts.Strict, newReader: newReader}
+}
+
+type gzipFactory struct {
+	multistream bool
+	strict      bool
+	newReader   func(r io.Reader) (GzipReader, error)
+}
+
+func (f *gzipFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	if f.strict {
+		return newStrictGzipDecoder(r, f.multistream, f.newReader)
+	}
+	gr, err := f.newReader(r)
+	if err != nil {
+		return nil, err
+	}
+	gr.Multistream(f.multistream)
+	return &gzipDecoder{GzipReader: gr, multistream: f.multistream}, nil
+}
+
+type gzipDecoder struct {
+	GzipReader
+	multistream bool
+}
+
+// Reset resets the reader, and restores the multistream mode that the underlying Reset turns on
+func (d *gzipDecoder) Reset(r io.Reader) error {
+	if err := d.GzipReader.Reset(r); err != nil {
+		return err
+	}
+	d.Multistream(d.multistream)
+	return nil
+}
+
+// WriteTo uses WriteTo of the underlying reader if it implements io.WriterTo, e.g. github.com/klauspost/compress/gzip
+func (d *gzipDecoder) WriteTo(w io.Writer) (int64, error) {
+	return d.writeToPool(w, defaultBufferPool)
+}
+
+func (d *gzipDecoder) writeToPool(w io.Writer, pool BufferPool) (int64, error) {
+	return writeTo(w, d.GzipReader, pool)
+}
+
+func newStdGzipReader(r io.Reader) (GzipReader, error) {
+	return gzip.NewReader(r)
+}
+
+// NewGzipFlateFactory returns the factory of the `gzip` decoders, that parse the gzip members by itself and decompress
+// the deflate data of them by newFlateReader, without verifying the CRC32 and ISIZE fields. It is used for
+// GzipOptions.SkipChecksum, and allows the implementations compatible with compress/flate to be used
+func NewGzipFlateFactory(opts GzipOptions, newFlateReader func(r io.Reader) io.ReadCloser) DecoderFactory {
+	return &gzipFlateFactory{multistream: !opts.DisableMultistream, newFlateReader: newFlateReader}
+}
+
+type gzipFlateFactory struct {
+	multistream    bool
+	newFlateReader func(r io.Reader) io.ReadCloser
+}
+
+func (f *gzipFlateFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	d := &gzipFlateDecoder{multistream: f.multistream, newFlateReader: f.newFlateReader}
+	if err := d.Reset(r); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// gzipFlateDecoder is the gzip decoder that skips the trailer of the members without verifying it
+type gzipFlateDecoder struct {
+	src            sourceReader
+	br             *bufio.Reader
+	fr             io.ReadCloser
+	multistream    bool
+	newFlateReader func(r io.Reader) io.ReadCloser
+	err            error
+}
+
+func (d *gzipFlateDecoder) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.fr.Read(p)
+	switch {
+	case err == io.EOF:
+		// skip the CRC32 and ISIZE
+		if _, err := d.br.Discard(8); err != nil {
+			d.err = io.ErrUnexpectedEOF
+			return n, d.err
+		}
+		if d.multistream {
+			if _, perr := d.br.Peek(1); perr == nil {
+				if err := d.readHeader(); err != nil {
+					d.err = err
+					return n, err
+				}
+				return n, nil
+			}
+		}
+		d.err = io.EOF
+	case err != nil:
+		d.err = err
+	}
+	return n, d.err
+}
+
+// readHeader reads the header of the gzip member, and resets the flate reader to the deflate data following it.
+// Refs RFC 1952 Section 2.3
+func (d *gzipFlateDecoder) readHeader() error {
+	var h [10]byte
+	if _, err := io.ReadFull(d.br, h[:]); err != nil {
+		return err
+	}
+	if h[0] != 0x1f || h[1] != 0x8b || h[2] != 8 {
+		return gzip.ErrHeader
+	}
+	const (
+		fhcrc    = 1 << 1
+		fextra   = 1 << 2
+		fname    = 1 << 3
+		fcomment = 1 << 4
+	)
+	flg := h[3]
+	if flg&fextra != 0 {
+		if _, err := io.ReadFull(d.br, h[:2]); err != nil {
+			return noEOF(err)
+		}
+		if _, err := d.br.Discard(int(binary.LittleEndian.Uint16(h[:2]))); err != nil {
+			return noEOF(err)
+		}
+	}
+	for _, f := range []byte{fname, fcomment} {
+		if flg&f == 0 {
+			continue
+		}
+		// zero-terminated
+		if _, err := d.br.ReadBytes(0); err != nil {
+			return noEOF(err)
+		}
+	}
+	if flg&fhcrc != 0 {
+		if _, err := d.br.Discard(2); err != nil {
+			return noEOF(err)
+		}
+	}
+	if d.fr == nil {
+		d.fr = d.newFlateReader(d.br)
+		return nil
+	}
+	if rs, ok := d.fr.(flate.Resetter); ok {
+		return rs.Reset(d.br, nil)
+	}
+	d.fr = d.newFlateReader(d.br)
+	return nil
+}
+
+func (d *gzipFlateDecoder) Reset(r io.Reader) error {
+	d.br = d.src.reset(r)
+	d.err = d.readHeader()
+	return d.err
+}
+
+func (d *gzipFlateDecoder) Close() error {
+	if d.fr == nil {
+		return nil
+	}
+	return d.fr.Close()
+}
+
+// noEOF converts io.EOF in the middle of the header to io.ErrUnexpectedEOF
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ErrChecksum represents the error that the checksum of the decoded data does not match
+type ErrChecksum struct {
+	Encoding string
+	// Err is the underlying error reported by the decoder, if any
+	Err error
+}
+
+// Error implements the error interface
+func (e *ErrChecksum) Error() string {
+	return fmt.Sprintf("decompress: %s checksum mismatch", e.Encoding)
+}
+
+// Unwrap returns the underlying error
+func (e *ErrChecksum) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrCorrupted
+func (e *ErrChecksum) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// strictGzipDecoder verifies the CRC32 and ISIZE fields of each gzip member by itself, regardless of the verification
+// of the underlying reader. The members are decoded one by one with the multistream mode off, and the trailer of
+// a member is the last 8 bytes read from the byte-exact source
+type strictGzipDecoder struct {
+	gr          GzipReader
+	src         *trailerReader
+	multistream bool
+	newReader   func(r io.Reader) (GzipReader, error)
+	crc         hash.Hash32
+	size        uint32
+	err         error
+}
+
+func newStrictGzipDecoder(r io.Reader, multistream bool, newReader func(r io.Reader) (GzipReader, error)) (*strictGzipDecoder, error) {
+	d := &strictGzipDecoder{multistream: multistream, newReader: newReader, crc: crc32.NewIEEE()}
+	if err := d.Reset(r); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *strictGzipDecoder) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.gr.Read(p)
+	d.crc.Write(p[:n])
+	d.size += uint32(n)
+	switch {
+	case err == io.EOF:
+		if err := d.verify(); err != nil {
+			d.err = err
+			return n, err
+		}
+		if d.multistream {
+			if _, perr := d.src.br.Peek(1); perr == nil {
+				// the next member
+				if err := d.gr.Reset(d.src); err != nil {
+					d.err = err
+					return n, err
+				}
+				d.gr.Multistream(false)
+				d.crc.Reset()
+				d.size = 0
+				return n, nil
+			}
+		}
+		d.err = io.EOF
+	case err != nil && isGzipChecksumError(err):
+		d.err = &ErrChecksum{Encoding: "gzip", Err: err}
+	case err != nil:
+		d.err = err
+	}
+	return n, d.err
+}
+
+// verify compares the CRC32 and ISIZE fields of the trailer with the decoded data. Refs RFC 1952 Section 2.3.1
+func (d *strictGzipDecoder) verify() error {
+	trailer := d.src.trailer()
+	if binary.LittleEndian.Uint32(trailer[:4]) != d.crc.Sum32() || binary.LittleEndian.Uint32(trailer[4:]) != d.size {
+		return &ErrChecksum{Encoding: "gzip"}
+	}
+	return nil
+}
+
+func (d *strictGzipDecoder) Reset(r io.Reader) error {
+	d.src = &trailerReader{br: bufio.NewReader(r)}
+	d.crc.Reset()
+	d.size = 0
+	d.err = nil
+	var err error
+	if d.gr == nil {
+		d.gr, err = d.newReader(d.src)
+	} else {
+		err = d.gr.Reset(d.src)
+	}
+	if err != nil {
+		d.err = err
+		return err
+	}
+	d.gr.Multistream(false)
+	return nil
+}
+
+func (d *strictGzipDecoder) Close() error {
+	if d.gr == nil {
+		return nil
+	}
+	return d.gr.Close()
+}
+
+// isGzipChecksumError reports whether err is the checksum error of compress/gzip or the compatible implementations
+func isGzipChecksumError(err error) bool {
+	return errors.Is(err, gzip.ErrChecksum) || err.Error() == gzip.ErrChecksum.Error()
+}
+
+// trailerReader is the byte-exact reader, that keeps the last 8 bytes read.
+// Since it implements io.ByteReader, the gzip readers read it without buffering
+type trailerReader struct {
+	br   *bufio.Reader
+	last [8]byte
+	n    int // the bytes of last in use
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	n, err := t.br.Read(p)
+	t.record(p[:n])
+	return n, err
+}
+
+func (t *trailerReader) ReadByte() (byte, error) {
+	c, err := t.br.ReadByte()
+	if err == nil {
+		t.record([]byte{c})
+	}
+	return c, err
+}
+
+func (t *trailerReader) record(b []byte) {
+	if len(b) >= len(t.last) {
+		copy(t.last[:], b[len(b)-len(t.last):])
+		t.n = len(t.last)
+		return
+	}
+	// shift out the old bytes
+	keep := min(t.n, len(t.last)-len(b))
+	copy(t.last[:keep], t.last[t.n-keep:t.n])
+	copy(t.last[keep:], b)
+	t.n = keep + len(b)
+}
+
+// trailer returns the last 8 bytes read
+func (t *trailerReader) trailer() []byte {
+	return t.last[:t.n]
+}
@@ -0,0 +1,37 @@
+// Package klauspost provides the decoders for the `gzip` and `deflate` content codings using github.com/klauspost/compress,
+// that is faster than the standard library.
+// Unlike the other subpackages, importing the package does not register the decoders, since the built-in decoders
+// already support the content codings. Set the decoders to RoundTripper.Decoders to use them:
+//
+//	rt := &decompress.RoundTripper{
+//		Decoders: map[string]decompress.DecoderFactory{
+//			"gzip":    klauspost.NewGzipFactory(decompress.GzipOptions{}),
+//			"deflate": klauspost.NewDeflateFactory(),
+//		},
+//	}
+package klauspost
+
+import (
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+)
+
+// NewGzipFactory returns the factory of the `gzip` decoders with the options. The decoders are pooled and reused by Reset
+func NewGzipFactory(opts decompress.GzipOptions) decompress.DecoderFactory {
+	if opts.SkipChecksum && !opts.Strict {
+		return decompress.NewPooledFactory(decompress.NewGzipFlateFactory(opts, flate.NewReader))
+	}
+	return decompress.NewPooledFactory(decompress.NewGzipFactory(opts, func(r io.Reader) (decompress.GzipReader, error) {
+		return gzip.NewReader(r)
+	}))
+}
+
+// NewDeflateFactory returns the factory of the `deflate` decoders, that support both zlib-wrapped and raw deflate data.
+// The decoders are pooled and reused by Reset
+func NewDeflateFactory() decompress.DecoderFactory {
+	return decompress.NewPooledFactory(decompress.NewDeflateFactory(zlib.NewReader, flate.NewReader))
+}
@@ -0,0 +1,150 @@
+package klauspost_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/kei2100/decompress-roundtripper/codectest"
+	"github.com/kei2100/decompress-roundtripper/klauspost"
+)
+
+func TestFactories(t *testing.T) {
+	tt := []struct {
+		title    string
+		factory  decompress.DecoderFactory
+		first    []byte
+		second   []byte
+		wantBody string
+	}{
+		{
+			title:    "gzip",
+			factory:  klauspost.NewGzipFactory(decompress.GzipOptions{}),
+			first:    gzipBytes([]byte("foo")),
+			second:   append(gzipBytes([]byte("bar")), gzipBytes([]byte("baz"))...),
+			wantBody: "barbaz",
+		},
+		{
+			title:    "gzip disable multistream",
+			factory:  klauspost.NewGzipFactory(decompress.GzipOptions{DisableMultistream: true}),
+			first:    gzipBytes([]byte("foo")),
+			second:   append(gzipBytes([]byte("bar")), gzipBytes([]byte("baz"))...),
+			wantBody: "bar",
+		},
+		{
+			title:    "gzip strict",
+			factory:  klauspost.NewGzipFactory(decompress.GzipOptions{Strict: true}),
+			first:    gzipBytes([]byte("foo")),
+			second:   append(gzipBytes([]byte("bar")), gzipBytes([]byte("baz"))...),
+			wantBody: "barbaz",
+		},
+		{
+			title:    "gzip skip checksum",
+			factory:  klauspost.NewGzipFactory(decompress.GzipOptions{SkipChecksum: true}),
+			first:    gzipBytes([]byte("foo")),
+			second:   append(gzipBytes([]byte("bar")), gzipBytes([]byte("baz"))...),
+			wantBody: "barbaz",
+		},
+		{
+			title:    "deflate",
+			factory:  klauspost.NewDeflateFactory(),
+			first:    zlibBytes([]byte("foo")),
+			second:   deflateBytes([]byte("barbaz")),
+			wantBody: "barbaz",
+		},
+		{
+			title:    "deflate zlib",
+			factory:  klauspost.NewDeflateFactory(),
+			first:    deflateBytes([]byte("foo")),
+			second:   zlibBytes([]byte("barbaz")),
+			wantBody: "barbaz",
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			d, err := te.factory.NewDecoder(bytes.NewReader(te.first))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			if b, err := io.ReadAll(d); err != nil || string(b) != "foo" {
+				t.Fatalf("got %q %v, want foo", b, err)
+			}
+			if err := d.Reset(bytes.NewReader(te.second)); err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFactories_Conformance(t *testing.T) {
+	t.Run("gzip", codectest.Suite{
+		Factory: klauspost.NewGzipFactory(decompress.GzipOptions{}),
+		Encode: func(b []byte) ([]byte, error) {
+			return gzipBytes(b), nil
+		},
+	}.Run)
+	t.Run("deflate", codectest.Suite{
+		Factory: klauspost.NewDeflateFactory(),
+		Encode: func(b []byte) ([]byte, error) {
+			return deflateBytes(b), nil
+		},
+	}.Run)
+	t.Run("deflate zlib", codectest.Suite{
+		Factory: klauspost.NewDeflateFactory(),
+		Encode: func(b []byte) ([]byte, error) {
+			return zlibBytes(b), nil
+		},
+	}.Run)
+}
+
+func gzipBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := gzip.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func deflateBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w, err := flate.NewWriter(&dst, flate.DefaultCompression)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func zlibBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := zlib.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
@@ -0,0 +1,81 @@
+package decompress
+
+import (
+	"context"
+	"io"
+)
+
+// Limiter limits the number of the responses being decompressed at once, so that a burst of the large responses
+// does not starve the rest of the process of the CPU. A response holds the Limiter only while a Read of the body
+// is decompressing, and releases it while waiting on the compressed body from the network,
+// so that the idle and the slow readers do not block the others.
+// Limiter can be shared by the RoundTrippers, and is safe for concurrent use
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter returns the Limiter that allows n responses to be decompressed at once
+func NewLimiter(n int) *Limiter {
+	return &Limiter{sem: make(chan struct{}, max(n, 1))}
+}
+
+func (l *Limiter) acquire(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.sem
+}
+
+// limiterReadCloser holds the Limiter while reading rc, except while rc reads the compressed body through limiterSource
+type limiterReadCloser struct {
+	rc   io.ReadCloser
+	l    *Limiter
+	ctx  context.Context
+	held bool
+}
+
+func (l *limiterReadCloser) Read(p []byte) (int, error) {
+	if err := l.l.acquire(l.ctx); err != nil {
+		return 0, err
+	}
+	l.held = true
+	defer l.release()
+	return l.rc.Read(p)
+}
+
+func (l *limiterReadCloser) release() {
+	if l.held {
+		l.held = false
+		l.l.release()
+	}
+}
+
+func (l *limiterReadCloser) Close() error {
+	return l.rc.Close()
+}
+
+// limiterSource is the compressed body read by the decoders. It releases the Limiter held by lr while reading
+// the body, and acquires it again before returning to the decoders
+type limiterSource struct {
+	io.ReadCloser
+	lr *limiterReadCloser
+}
+
+func (s *limiterSource) Read(p []byte) (int, error) {
+	if !s.lr.held {
+		return s.ReadCloser.Read(p)
+	}
+	s.lr.release()
+	n, err := s.ReadCloser.Read(p)
+	if aerr := s.lr.l.acquire(s.lr.ctx); aerr != nil {
+		return n, aerr
+	}
+	s.lr.held = true
+	return n, err
+}
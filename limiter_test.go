@@ -0,0 +1,143 @@
+package decompress_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// blockingReader blocks the first Read until release is closed
+type blockingReader struct {
+	io.Reader
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	if b.started != nil {
+		close(b.started)
+		b.started = nil
+		<-b.release
+	}
+	return b.Reader.Read(p)
+}
+
+func TestLimiter(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	first := true
+	dr := decompress.RoundTripper{
+		Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return newResponse(t, []byte("foobarbaz"), "x-blocking"), nil
+		}),
+		Decoders: map[string]decompress.DecoderFactory{
+			"x-blocking": decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+				if first {
+					first = false
+					return io.NopCloser(&blockingReader{Reader: r, started: started, release: release}), nil
+				}
+				return io.NopCloser(r), nil
+			}),
+		},
+		Limiter: decompress.NewLimiter(1),
+	}
+	roundTrip := func(ctx context.Context) (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+		return dr.RoundTrip(req)
+	}
+	resp1, err := roundTrip(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp1.Body.Close()
+	done := make(chan error)
+	go func() {
+		_, err := io.ReadAll(resp1.Body)
+		done <- err
+	}()
+	<-started
+
+	// waits for the limiter until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	resp2, err := roundTrip(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	if _, err := io.ReadAll(resp2.Body); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// released
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	resp3, err := roundTrip(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp3.Body.Close()
+	b, err := io.ReadAll(resp3.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "foobarbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+}
+
+func TestLimiter_StalledBody(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	first := true
+	dr := decompress.RoundTripper{
+		Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			res := newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")
+			if first {
+				first = false
+				res.Body = io.NopCloser(&blockingReader{Reader: res.Body, started: started, release: release})
+			}
+			return res, nil
+		}),
+		Limiter: decompress.NewLimiter(1),
+	}
+	resp1, err := dr.RoundTrip(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp1.Body.Close()
+	done := make(chan error)
+	go func() {
+		_, err := io.ReadAll(resp1.Body)
+		done <- err
+	}()
+	<-started
+
+	// the stalled body does not hold the limiter while waiting on the network
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+	resp2, err := dr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	b, err := io.ReadAll(resp2.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "foobarbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
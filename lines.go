@@ -0,0 +1,75 @@
+//go:build go1.23
+
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+)
+
+// Lines returns the iterator over the lines of the decompressed body of res, e.g. for the log-streaming endpoints.
+// The lines do not include the trailing `\n` or `\r\n`. A line is at most maxLen bytes, or 64 KiB if maxLen is not
+// positive, and the longer line yields bufio.ErrTooLong. The line is valid only until the next iteration.
+// The body is decompressed if it is still compressed, and closed when the iteration ends.
+// An error is yielded with a nil line, and ends the iteration. The iterator can be used only once
+func Lines(res *http.Response, maxLen int) iter.Seq2[[]byte, error] {
+	if maxLen <= 0 {
+		maxLen = bufio.MaxScanTokenSize
+	}
+	return func(yield func([]byte, error) bool) {
+		defer func() {
+			res.Body.Close()
+		}()
+		if err := DecodeResponse(res); err != nil {
+			yield(nil, err)
+			return
+		}
+		s := bufio.NewScanner(res.Body)
+		// +2 for the `\r\n`, so that maxLen is the length of the line without it
+		s.Buffer(make([]byte, 0, min(maxLen+2, 4096)), maxLen+2)
+		for s.Scan() {
+			line := bytes.TrimSuffix(s.Bytes(), []byte("\r"))
+			if len(line) > maxLen {
+				yield(nil, bufio.ErrTooLong)
+				return
+			}
+			if !yield(line, nil) {
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// NDJSON returns the iterator over the records of the decompressed body of res in the newline delimited JSON format,
+// e.g. for the bulk-export endpoints. The empty lines are skipped, and an invalid record yields an error.
+// The record is valid only until the next iteration. See Lines
+func NDJSON(res *http.Response, maxLen int) iter.Seq2[json.RawMessage, error] {
+	return func(yield func(json.RawMessage, error) bool) {
+		n := 0
+		for line, err := range Lines(res, maxLen) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			n++
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			if !json.Valid(line) {
+				yield(nil, fmt.Errorf("decompress: invalid JSON at line %d", n))
+				return
+			}
+			if !yield(json.RawMessage(line), nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+//go:build go1.23
+
+package decompress_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestLines(t *testing.T) {
+	tt := []struct {
+		title  string
+		body   string
+		maxLen int
+		// identity makes the body uncompressed, and read with io.EOF together with the last bytes
+		identity    bool
+		want        []string
+		wantErrLong bool
+	}{
+		{title: "lines", body: "foo\nbar\r\nbaz", want: []string{"foo", "bar", "baz"}},
+		{title: "trailing newline", body: "foo\nbar\n", want: []string{"foo", "bar"}},
+		{title: "empty lines", body: "foo\n\nbar", want: []string{"foo", "", "bar"}},
+		{title: "empty", body: "", want: nil},
+		{title: "just the max length", body: "foobarbaz\nfoo", maxLen: 9, want: []string{"foobarbaz", "foo"}},
+		{title: "just the max length with CRLF", body: "foobarbaz\r\nfoo", maxLen: 9, want: []string{"foobarbaz", "foo"}},
+		{title: "too long", body: "foo\nfoobarbaz\n", maxLen: 8, want: []string{"foo"}, wantErrLong: true},
+		{title: "too long with CRLF", body: "foo\nfoobarbaz\r\n", maxLen: 8, want: []string{"foo"}, wantErrLong: true},
+		{title: "too long last line", body: "foo\nfoobarbaz", maxLen: 8, want: []string{"foo"}, wantErrLong: true},
+		{title: "too long last line read with EOF", body: "foobarbaz", maxLen: 8, identity: true, wantErrLong: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, gzipBytes([]byte(te.body)), "gzip")
+			if te.identity {
+				resp = newResponse(t, []byte(te.body), "")
+				resp.Body = io.NopCloser(iotest.DataErrReader(resp.Body))
+			}
+			body := &closeRecorder{ReadCloser: resp.Body}
+			resp.Body = body
+			var (
+				got    []string
+				gotErr error
+			)
+			for line, err := range decompress.Lines(resp, te.maxLen) {
+				if err != nil {
+					gotErr = err
+					continue
+				}
+				got = append(got, string(line))
+			}
+			if !body.closed {
+				t.Error("body is not closed")
+			}
+			if got, want := strings.Join(got, "|"), strings.Join(te.want, "|"); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := errors.Is(gotErr, bufio.ErrTooLong), te.wantErrLong; got != want {
+				t.Errorf("ErrTooLong got %v (%v), want %v", got, gotErr, want)
+			}
+			if !te.wantErrLong && gotErr != nil {
+				t.Error(gotErr)
+			}
+		})
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	tt := []struct {
+		title   string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{title: "records", body: "{\"foo\":1}\n{\"foo\":2}\n", want: []string{`{"foo":1}`, `{"foo":2}`}},
+		{title: "blank lines", body: "{\"foo\":1}\r\n\n  \n[1,2]", want: []string{`{"foo":1}`, `[1,2]`}},
+		{title: "invalid record", body: "{\"foo\":1}\n{\"foo\":\n", want: []string{`{"foo":1}`}, wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, gzipBytes([]byte(te.body)), "gzip")
+			var (
+				got    []string
+				gotErr error
+			)
+			for rec, err := range decompress.NDJSON(resp, 0) {
+				if err != nil {
+					gotErr = err
+					continue
+				}
+				got = append(got, string(rec))
+			}
+			if got, want := strings.Join(got, "|"), strings.Join(te.want, "|"); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := gotErr != nil, te.wantErr; got != want {
+				t.Errorf("error got %v, want error %v", gotErr, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package decompress
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logMetrics logs the events of the decompression of a response by the Logger of the RoundTripper
+type logMetrics struct {
+	logger *slog.Logger
+	ctx    context.Context
+	host   string
+}
+
+// newLogMetrics returns the logMetrics of res
+func newLogMetrics(ctx context.Context, logger *slog.Logger, res *http.Response) *logMetrics {
+	return &logMetrics{logger: logger, ctx: ctx, host: responseHost(res)}
+}
+
+func (m *logMetrics) DecodeStarted(encoding string) {
+	m.logger.LogAttrs(m.ctx, slog.LevelDebug, "decompress: decoding the response",
+		slog.String("host", m.host), slog.String("encoding", encoding))
+}
+
+func (m *logMetrics) DecodeFinished(encoding string, compressed, decompressed int64, d time.Duration) {
+	m.logger.LogAttrs(m.ctx, slog.LevelDebug, "decompress: decoded the response",
+		slog.String("host", m.host), slog.String("encoding", encoding),
+		slog.Int64("compressed", compressed), slog.Int64("decompressed", decompressed), slog.Duration("duration", d))
+}
+
+func (m *logMetrics) DecodeFailed(encoding string, err error) {
+	m.logger.LogAttrs(m.ctx, slog.LevelWarn, "decompress: failed to decode the response",
+		slog.String("host", m.host), slog.String("encoding", encoding), slog.Any("error", err))
+}
+
+// logDecision logs the decision of the RoundTripper on res, e.g. the pass-through of the unsupported encoding.
+// r.Logger must not be nil
+func (r *RoundTripper) logDecision(ctx context.Context, res *http.Response, level slog.Level, msg string, attrs ...slog.Attr) {
+	attrs = append(attrs, slog.String("host", responseHost(res)))
+	r.Logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// responseHost returns the host of the request of res, or empty if it is unknown
+func responseHost(res *http.Response) string {
+	if res.Request == nil || res.Request.URL == nil {
+		return ""
+	}
+	return res.Request.URL.Host
+}
+
+// multiMetrics sends the events to all the Metrics
+type multiMetrics []Metrics
+
+func (mm multiMetrics) DecodeStarted(encoding string) {
+	for _, m := range mm {
+		m.DecodeStarted(encoding)
+	}
+}
+
+func (mm multiMetrics) DecodeFinished(encoding string, compressed, decompressed int64, d time.Duration) {
+	for _, m := range mm {
+		m.DecodeFinished(encoding, compressed, decompressed, d)
+	}
+}
+
+func (mm multiMetrics) DecodeFailed(encoding string, err error) {
+	for _, m := range mm {
+		m.DecodeFailed(encoding, err)
+	}
+}
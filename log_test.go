@@ -0,0 +1,130 @@
+package decompress_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// recordingHandler records the messages and the attributes of the logs
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	s := fmt.Sprintf("%s %s", r.Level, r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "host", "encoding", "encodings", "remaining", "decompressed":
+			s += fmt.Sprintf(" %s=%v", a.Key, a.Value)
+		}
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, s)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *recordingHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func TestRoundTripper_RoundTrip_Logger(t *testing.T) {
+	tt := []struct {
+		title       string
+		resp        *http.Response
+		dr          decompress.RoundTripper
+		wantRecords []string
+	}{
+		{
+			title: "decoded",
+			resp:  newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantRecords: []string{
+				"DEBUG decompress: decoding the response host=example.com encoding=gzip",
+				"DEBUG decompress: decoded the response host=example.com encoding=gzip decompressed=9",
+			},
+		},
+		{
+			title: "unsupported",
+			resp:  newResponse(t, []byte("foobarbaz"), "x-unknown"),
+			wantRecords: []string{
+				"WARN decompress: failed to decode the response host=example.com encoding=unsupported",
+			},
+		},
+		{
+			title: "passed through",
+			resp:  newResponse(t, []byte("foobarbaz"), "x-unknown"),
+			dr:    decompress.RoundTripper{PassThroughUnsupported: true},
+			wantRecords: []string{
+				"INFO decompress: passed through the unsupported encoding encodings=x-unknown encoding=x-unknown host=example.com",
+			},
+		},
+		{
+			title: "partially decoded",
+			resp:  newResponse(t, gzipBytes([]byte("foobarbaz")), "x-unknown, gzip"),
+			dr:    decompress.RoundTripper{PartialDecoding: true},
+			wantRecords: []string{
+				"INFO decompress: partially decoding the response encodings=x-unknown, gzip remaining=x-unknown host=example.com",
+				"DEBUG decompress: decoding the response host=example.com encoding=gzip",
+				"DEBUG decompress: decoded the response host=example.com encoding=gzip decompressed=9",
+			},
+		},
+		{
+			title: "skipped",
+			resp:  newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			dr:    decompress.RoundTripper{StatusFilter: func(int) bool { return false }},
+			wantRecords: []string{
+				"DEBUG decompress: skipped the response by the filters encodings=gzip host=example.com",
+			},
+		},
+		{
+			title: "corrupted",
+			resp:  newResponse(t, []byte("foobarbaz"), "gzip"),
+			wantRecords: []string{
+				"DEBUG decompress: decoding the response host=example.com encoding=gzip",
+				"WARN decompress: failed to decode the response host=example.com encoding=gzip",
+				"DEBUG decompress: decoded the response host=example.com encoding=gzip decompressed=0",
+			},
+		},
+		{
+			title:       "not compressed",
+			resp:        newResponse(t, []byte("foobarbaz"), ""),
+			wantRecords: nil,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			h := &recordingHandler{}
+			req, _ := http.NewRequest("GET", "http://example.com/", nil)
+			te.resp.Request = req
+			dr := te.dr
+			dr.Wrap = &stubRoundTripper{response: te.resp}
+			dr.Logger = slog.New(h)
+			resp, err := dr.RoundTrip(req)
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			if got, want := h.records, te.wantRecords; !reflect.DeepEqual(got, want) {
+				t.Errorf("records got %q,\nwant %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Package lz4 provides the decoder for the `lz4` content coding (LZ4 frame format) using github.com/pierrec/lz4/v4.
+// Importing the package registers the decoder by decompress.RegisterDecoder:
+//
+//	import _ "github.com/kei2100/decompress-roundtripper/lz4"
+package lz4
+
+import (
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/pierrec/lz4/v4"
+)
+
+func init() {
+	decompress.RegisterDecoder("lz4", NewFactory())
+}
+
+// NewFactory returns the factory of the `lz4` decoders
+func NewFactory() decompress.DecoderFactory {
+	return factory{}
+}
+
+type factory struct{}
+
+func (factory) NewDecoder(r io.Reader) (decompress.Decoder, error) {
+	return &decoder{Reader: lz4.NewReader(r)}, nil
+}
+
+type decoder struct {
+	*lz4.Reader
+}
+
+func (d *decoder) Reset(r io.Reader) error {
+	d.Reader.Reset(r)
+	return nil
+}
+
+func (d *decoder) Close() error {
+	return nil
+}
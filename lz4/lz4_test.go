@@ -0,0 +1,31 @@
+package lz4_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper/codectest"
+	dlz4 "github.com/kei2100/decompress-roundtripper/lz4"
+	"github.com/pierrec/lz4/v4"
+)
+
+func TestNewFactory_Conformance(t *testing.T) {
+	codectest.Suite{
+		Factory: dlz4.NewFactory(),
+		Encode: func(b []byte) ([]byte, error) {
+			return lz4Bytes(b), nil
+		},
+	}.Run(t)
+}
+
+func lz4Bytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := lz4.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
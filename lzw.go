@@ -0,0 +1,237 @@
+package decompress
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// lzw implements a reader for the `compress` content coding, that is the format of the UNIX compress(1) program.
+// The standard compress/lzw package can not be used for it since the bit packing and the code table handling are different.
+// Refs https://github.com/vapier/ncompress/blob/main/compress.c
+
+const (
+	lzwMagic0     = 0x1f
+	lzwMagic1     = 0x9d
+	lzwBitMask    = 0x1f
+	lzwBlockMode  = 0x80
+	lzwReserved   = 0x60
+	lzwInitBits   = 9
+	lzwMinMaxBits = 9
+	lzwMaxMaxBits = 16
+	lzwClear      = 256
+	lzwFirst      = 257
+)
+
+var errLZWCorrupt = errors.New("decompress: corrupt compress data")
+
+type lzwReader struct {
+	src        sourceReader
+	r          *bufio.Reader
+	blockMode  bool
+	maxbits    uint
+	maxmaxcode int
+
+	nbits   uint
+	maxcode int
+	freeEnt int
+	oldcode int
+	finchar byte
+
+	bits   uint32
+	nbits0 uint // number of valid bits in bits
+	ncodes int  // number of codes read since the last code width change
+
+	prefix []uint16
+	suffix []byte
+	stack  []byte
+	out    []byte
+	err    error
+}
+
+// newLZWReader reads the header of the compress format and returns the reader for the decompressed data
+func newLZWReader(r io.Reader) (*lzwReader, error) {
+	z := &lzwReader{}
+	z.r = z.src.reset(r)
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Reset discards the state of z and makes it read the compress format from r.
+// The code table is reused if it is large enough for the max bits of r
+func (z *lzwReader) Reset(r io.Reader) error {
+	z.r = z.src.reset(r)
+	return z.init()
+}
+
+func (z *lzwReader) Close() error {
+	return nil
+}
+
+// init reads the header of the compress format and initializes the state of z
+func (z *lzwReader) init() error {
+	flags, err := readLZWHeader(z.r)
+	if err != nil {
+		z.out, z.err = nil, err
+		return err
+	}
+	maxbits := uint(flags & lzwBitMask)
+	prefix, suffix, stack := z.prefix, z.suffix, z.stack
+	if len(prefix) < 1<<maxbits {
+		prefix, suffix, stack = make([]uint16, 1<<maxbits), make([]byte, 1<<maxbits), make([]byte, 0, 1<<maxbits)
+	}
+	*z = lzwReader{
+		r:          z.r,
+		src:        z.src,
+		blockMode:  flags&lzwBlockMode != 0,
+		maxbits:    maxbits,
+		maxmaxcode: 1 << maxbits,
+		nbits:      lzwInitBits,
+		maxcode:    1<<lzwInitBits - 1,
+		oldcode:    -1,
+		prefix:     prefix,
+		suffix:     suffix,
+		stack:      stack,
+	}
+	z.freeEnt = 256
+	if z.blockMode {
+		z.freeEnt = lzwFirst
+	}
+	return nil
+}
+
+// readLZWHeader reads the header of the compress format and returns the flags byte
+func readLZWHeader(br *bufio.Reader) (byte, error) {
+	// peeked instead of read into an array, that escapes to the heap per Reset
+	header, err := br.Peek(3)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	br.Discard(3)
+	if header[0] != lzwMagic0 || header[1] != lzwMagic1 {
+		return 0, errors.New("decompress: invalid compress header")
+	}
+	if header[2]&lzwReserved != 0 {
+		return 0, errors.New("decompress: invalid compress header flags")
+	}
+	if maxbits := header[2] & lzwBitMask; maxbits < lzwMinMaxBits || maxbits > lzwMaxMaxBits {
+		return 0, fmt.Errorf("decompress: unsupported compress max bits %d", maxbits)
+	}
+	return header[2], nil
+}
+
+func (z *lzwReader) Read(p []byte) (int, error) {
+	for len(z.out) == 0 && z.err == nil {
+		z.decode()
+	}
+	if len(z.out) > 0 {
+		n := copy(p, z.out)
+		z.out = z.out[n:]
+		return n, nil
+	}
+	return 0, z.err
+}
+
+// decode decodes a code and stores the resulting bytes into z.out
+func (z *lzwReader) decode() {
+	if z.freeEnt > z.maxcode {
+		if !z.skipGroup() {
+			return
+		}
+		z.nbits++
+		if z.nbits == z.maxbits {
+			z.maxcode = z.maxmaxcode
+		} else {
+			z.maxcode = 1<<z.nbits - 1
+		}
+	}
+	code, ok := z.readCode()
+	if !ok {
+		return
+	}
+	if z.oldcode == -1 {
+		if code >= 256 {
+			z.err = errLZWCorrupt
+			return
+		}
+		z.oldcode = code
+		z.finchar = byte(code)
+		z.out = append(z.stack[:0], z.finchar)
+		return
+	}
+	if code == lzwClear && z.blockMode {
+		if !z.skipGroup() {
+			return
+		}
+		z.freeEnt = lzwFirst - 1
+		z.nbits = lzwInitBits
+		z.maxcode = 1<<lzwInitBits - 1
+		return
+	}
+	incode := code
+	stack := z.stack[:0]
+	if code >= z.freeEnt {
+		// special case for KwKwK string
+		if code > z.freeEnt {
+			z.err = errLZWCorrupt
+			return
+		}
+		stack = append(stack, z.finchar)
+		code = z.oldcode
+	}
+	for code >= 256 {
+		stack = append(stack, z.suffix[code])
+		code = int(z.prefix[code])
+	}
+	z.finchar = byte(code)
+	stack = append(stack, z.finchar)
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	z.stack = stack
+	z.out = stack
+	if code := z.freeEnt; code < z.maxmaxcode {
+		z.prefix[code] = uint16(z.oldcode)
+		z.suffix[code] = z.finchar
+		z.freeEnt = code + 1
+	}
+	z.oldcode = incode
+}
+
+// readCode reads a code of the current code width.
+// A partial code at the end of the stream is ignored.
+func (z *lzwReader) readCode() (int, bool) {
+	for z.nbits0 < z.nbits {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			z.err = err
+			return 0, false
+		}
+		z.bits |= uint32(b) << z.nbits0
+		z.nbits0 += 8
+	}
+	code := int(z.bits & (1<<z.nbits - 1))
+	z.bits >>= z.nbits
+	z.nbits0 -= z.nbits
+	z.ncodes++
+	return code, true
+}
+
+// skipGroup discards the rest of the current group of 8 codes.
+// compress(1) writes codes in groups, and starts a new group whenever the code width changes.
+func (z *lzwReader) skipGroup() bool {
+	remaining := (8 - z.ncodes%8) % 8
+	for i := 0; i < remaining; i++ {
+		if _, ok := z.readCode(); !ok {
+			return false
+		}
+	}
+	z.ncodes = 0
+	return true
+}
@@ -0,0 +1,173 @@
+package decompress_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_RoundTrip_Compress(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	words := []string{"foo", "bar", "baz", "qux", "quux", "corge", "grault", "garply"}
+	var text bytes.Buffer
+	for text.Len() < 256*1024 {
+		text.WriteString(words[rnd.Intn(len(words))])
+		text.WriteByte(byte(' ' + rnd.Intn(3)))
+	}
+	random := make([]byte, 64*1024)
+	rnd.Read(random)
+
+	tt := []struct {
+		title   string
+		body    []byte
+		maxbits uint
+	}{
+		{title: "empty", body: []byte{}, maxbits: 16},
+		{title: "single byte", body: []byte("f"), maxbits: 16},
+		{title: "KwKwK", body: bytes.Repeat([]byte("a"), 1000), maxbits: 16},
+		{title: "text 16 bits", body: text.Bytes(), maxbits: 16},
+		{title: "text 12 bits", body: text.Bytes(), maxbits: 12},
+		{title: "text 9 bits", body: text.Bytes(), maxbits: 9},
+		{title: "random 16 bits", body: random, maxbits: 16},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, compressBytes(te.body, te.maxbits), "compress")
+			dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: resp}}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, te.body) {
+				t.Errorf("body mismatch: got %d bytes, want %d bytes", len(b), len(te.body))
+			}
+		})
+	}
+}
+
+func TestLZWDecoder_Reset(t *testing.T) {
+	data := compressBytes([]byte("foobarbaz"), 16)
+	d, err := decompress.BuiltinDecoder(&decompress.RoundTripper{}, "compress").NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+	buf := make([]byte, len("foobarbaz"))
+	allocs := testing.AllocsPerRun(10, func() {
+		r.Reset(data)
+		if err := d.Reset(r); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(d, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, []byte("foobarbaz")) {
+			t.Errorf("body got %s, want foobarbaz", buf)
+		}
+	})
+	if got, want := allocs, float64(0); got != want {
+		t.Errorf("allocs per Reset got %v, want %v", got, want)
+	}
+}
+
+// compressBytes compresses b in the format of the UNIX compress(1) program with the block mode.
+// The code table is cleared as soon as it becomes full.
+func compressBytes(b []byte, maxbits uint) []byte {
+	const clear, first = 256, 257
+	var (
+		dst     = bytes.NewBuffer([]byte{0x1f, 0x9d, byte(maxbits) | 0x80})
+		maxmax  = 1 << maxbits
+		nbits   = uint(9)
+		maxcode = 1<<nbits - 1
+		dfree   = first // free entry from the decoder's point of view
+		initial = true
+		ncodes  int
+		acc     uint32
+		nacc    uint
+	)
+	writeBits := func(code int, n uint) {
+		acc |= uint32(code) << nacc
+		nacc += n
+		for nacc >= 8 {
+			dst.WriteByte(byte(acc))
+			acc >>= 8
+			nacc -= 8
+		}
+	}
+	padGroup := func() {
+		for ; ncodes%8 != 0; ncodes++ {
+			writeBits(0, nbits)
+		}
+		ncodes = 0
+	}
+	emit := func(code int) {
+		if dfree > maxcode {
+			padGroup()
+			nbits++
+			if nbits == maxbits {
+				maxcode = maxmax
+			} else {
+				maxcode = 1<<nbits - 1
+			}
+		}
+		writeBits(code, nbits)
+		ncodes++
+		if code == clear {
+			padGroup()
+			nbits = 9
+			maxcode = 1<<nbits - 1
+			dfree = first - 1
+			return
+		}
+		if initial {
+			initial = false
+		} else if dfree < maxmax {
+			dfree++
+		}
+	}
+
+	if len(b) > 0 {
+		table := map[string]int{}
+		next := first
+		w := b[:1]
+		for i := 1; i < len(b); i++ {
+			wc := b[i-len(w) : i+1]
+			if _, ok := table[string(wc)]; ok {
+				w = wc
+				continue
+			}
+			emit(lzwCode(table, w))
+			if next < maxmax {
+				table[string(wc)] = next
+				next++
+			} else {
+				emit(clear)
+				table = map[string]int{}
+				next = first
+			}
+			w = b[i : i+1]
+		}
+		emit(lzwCode(table, w))
+	}
+	if nacc > 0 {
+		dst.WriteByte(byte(acc))
+	}
+	return dst.Bytes()
+}
+
+func lzwCode(table map[string]int, w []byte) int {
+	if len(w) == 1 {
+		return int(w[0])
+	}
+	return table[string(w)]
+}
@@ -0,0 +1,70 @@
+package decompress
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Metrics receives the events of the decompression, so that any metrics system can be wired in, e.g. by counting
+// the events and observing the histograms of the sizes and the durations.
+// encoding is the decoded content codings joined by ", " in the order of the Content-Encoding header, e.g. "gzip",
+// or UnsupportedEncodingLabel or UnknownEncodingLabel if the decoding fails before the codings are resolved.
+// The methods are called concurrently by the responses, and should not block
+type Metrics interface {
+	// DecodeStarted is called when RoundTrip starts decoding the response
+	DecodeStarted(encoding string)
+	// DecodeFinished is called when the decoded body is closed, with the bytes of the compressed body read from
+	// the wire, the bytes of the decompressed body produced, and the duration since the decoding started
+	DecodeFinished(encoding string, compressed, decompressed int64, d time.Duration)
+	// DecodeFailed is called once per response, when RoundTrip or reading the body fails to decode the response.
+	// The errors caused by closing the body are not reported
+	DecodeFailed(encoding string, err error)
+}
+
+// ContextMetrics is implemented by the Metrics that receive the events in the context of the request, e.g. to record
+// them on the span of the request. ForContext is called once per response, and the returned Metrics receives
+// the events of the response instead
+type ContextMetrics interface {
+	Metrics
+	ForContext(ctx context.Context) Metrics
+}
+
+// DecoderMetrics is implemented by the Metrics that receive the events of the decoders, e.g. to verify the effectiveness
+// of the pooling of NewPooledFactory and to detect the leaks of the bodies not closed. The methods are called on
+// the Metrics of the RoundTripper, not on the Metrics returned by ForContext, with a single content coding
+type DecoderMetrics interface {
+	Metrics
+	// DecoderCreated is called when a decoder is created. pooled reports whether the factory is pooled by
+	// NewPooledFactory, and reused whether the decoder is reused from the pool
+	DecoderCreated(encoding string, pooled, reused bool)
+	// DecoderClosed is called when the decoder is closed
+	DecoderClosed(encoding string)
+}
+
+// The encoding labels of the failures before the content codings are resolved. The Content-Encoding header is not used
+// as the label, since it is controlled by the server and the number of the metric series would be unbounded
+const (
+	// UnsupportedEncodingLabel is the label of ErrUnsupportedEncoding
+	UnsupportedEncodingLabel = "unsupported"
+	// UnknownEncodingLabel is the label of the other failures, e.g. ErrTooManyEncodings and ErrQuotaExceeded
+	UnknownEncodingLabel = "unknown"
+)
+
+// unresolvedLabel returns the encoding label of err, that occurred before the content codings are resolved
+func unresolvedLabel(err error) string {
+	var unsupported *ErrUnsupportedEncoding
+	if errors.As(err, &unsupported) {
+		return UnsupportedEncodingLabel
+	}
+	return UnknownEncodingLabel
+}
+
+// encodingLabel returns the label of the content codings for Metrics
+func encodingLabel(codings []string) string {
+	if len(codings) == 1 {
+		return codings[0]
+	}
+	return strings.Join(codings, ", ")
+}
@@ -0,0 +1,217 @@
+package decompress_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// recordingMetrics records the events of Metrics
+type recordingMetrics struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *recordingMetrics) DecodeStarted(encoding string) {
+	m.record("started %s", encoding)
+}
+
+func (m *recordingMetrics) DecodeFinished(encoding string, compressed, decompressed int64, _ time.Duration) {
+	m.record("finished %s %d", encoding, decompressed)
+}
+
+func (m *recordingMetrics) DecodeFailed(encoding string, _ error) {
+	m.record("failed %s", encoding)
+}
+
+func (m *recordingMetrics) record(format string, args ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, fmt.Sprintf(format, args...))
+}
+
+// recordingDecoderMetrics records the events of DecoderMetrics in addition to Metrics
+type recordingDecoderMetrics struct {
+	recordingMetrics
+}
+
+func (m *recordingDecoderMetrics) DecoderCreated(encoding string, pooled, _ bool) {
+	m.record("created %s pooled=%v", encoding, pooled)
+}
+
+func (m *recordingDecoderMetrics) DecoderClosed(encoding string) {
+	m.record("closed %s", encoding)
+}
+
+func TestRoundTripper_RoundTrip_Metrics(t *testing.T) {
+	tt := []struct {
+		title      string
+		resp       *http.Response
+		dr         decompress.RoundTripper
+		wantEvents []string
+	}{
+		{
+			title:      "decoded",
+			resp:       newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantEvents: []string{"started gzip", "finished gzip 9"},
+		},
+		{
+			title:      "chained",
+			resp:       newResponse(t, gzipBytes(deflateBytes([]byte("foobarbaz"))), "deflate, gzip"),
+			wantEvents: []string{"started deflate, gzip", "finished deflate, gzip 9"},
+		},
+		{
+			title:      "partially decoded",
+			resp:       newResponse(t, gzipBytes([]byte("foobarbaz")), "x-unknown, gzip"),
+			dr:         decompress.RoundTripper{PartialDecoding: true},
+			wantEvents: []string{"started gzip", "finished gzip 9"},
+		},
+		{
+			title:      "not compressed",
+			resp:       newResponse(t, []byte("foobarbaz"), ""),
+			wantEvents: nil,
+		},
+		{
+			title:      "unsupported",
+			resp:       newResponse(t, []byte("foobarbaz"), "x-unknown"),
+			wantEvents: []string{"failed unsupported"},
+		},
+		{
+			title:      "too many encodings",
+			resp:       newResponse(t, gzipBytes(gzipBytes([]byte("foobarbaz"))), "gzip, gzip"),
+			dr:         decompress.RoundTripper{MaxEncodings: 1},
+			wantEvents: []string{"failed unknown"},
+		},
+		{
+			title:      "corrupted",
+			resp:       newResponse(t, []byte("foobarbaz"), "gzip"),
+			wantEvents: []string{"started gzip", "failed gzip", "finished gzip 0"},
+		},
+		{
+			title:      "buffered too large",
+			resp:       newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			dr:         decompress.RoundTripper{BufferLimit: 4},
+			wantEvents: []string{"started gzip", "finished gzip 5", "failed gzip"},
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			m := &recordingMetrics{}
+			dr := te.dr
+			dr.Wrap = &stubRoundTripper{response: te.resp}
+			dr.Metrics = m
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			if got, want := m.events, te.wantEvents; !reflect.DeepEqual(got, want) {
+				t.Errorf("events got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// contextMetrics records the events with the value of the request context
+type contextMetrics struct {
+	recordingMetrics
+}
+
+type contextKey struct{}
+
+func (m *contextMetrics) ForContext(ctx context.Context) decompress.Metrics {
+	return &valueMetrics{m: &m.recordingMetrics, value: ctx.Value(contextKey{})}
+}
+
+type valueMetrics struct {
+	m     *recordingMetrics
+	value any
+}
+
+func (m *valueMetrics) DecodeStarted(encoding string) {
+	m.m.record("started %s %v", encoding, m.value)
+}
+
+func (m *valueMetrics) DecodeFinished(encoding string, _, decompressed int64, _ time.Duration) {
+	m.m.record("finished %s %d %v", encoding, decompressed, m.value)
+}
+
+func (m *valueMetrics) DecodeFailed(encoding string, _ error) {
+	m.m.record("failed %s %v", encoding, m.value)
+}
+
+func TestRoundTripper_RoundTrip_ContextMetrics(t *testing.T) {
+	m := &contextMetrics{}
+	dr := decompress.RoundTripper{
+		Wrap:    &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")},
+		Metrics: m,
+	}
+	ctx := context.WithValue(context.Background(), contextKey{}, "req1")
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+	resp, err := dr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if got, want := m.events, []string{"started gzip req1", "finished gzip 9 req1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events got %q, want %q", got, want)
+	}
+}
+
+func TestRoundTripper_RoundTrip_DecoderMetrics(t *testing.T) {
+	tt := []struct {
+		title      string
+		resp       *http.Response
+		wantEvents []string
+	}{
+		{
+			title:      "pooled",
+			resp:       newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantEvents: []string{"started gzip", "created gzip pooled=true", "closed gzip", "finished gzip 9"},
+		},
+		{
+			title: "chained",
+			resp:  newResponse(t, gzipBytes(rot13([]byte("foobarbaz"))), "x-rot13, gzip"),
+			wantEvents: []string{
+				"started x-rot13, gzip",
+				"created gzip pooled=true", "created x-rot13 pooled=false",
+				"closed x-rot13", "closed gzip",
+				"finished x-rot13, gzip 9",
+			},
+		},
+		{
+			title:      "not compressed",
+			resp:       newResponse(t, []byte("foobarbaz"), ""),
+			wantEvents: nil,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			m := &recordingDecoderMetrics{}
+			dr := decompress.RoundTripper{
+				Wrap:     &stubRoundTripper{response: te.resp},
+				Decoders: map[string]decompress.DecoderFactory{"x-rot13": decompress.DecoderFunc(newROT13Reader)},
+				Metrics:  m,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if got, want := m.events, te.wantEvents; !reflect.DeepEqual(got, want) {
+				t.Errorf("events got %v, want %v", got, want)
+			}
+		})
+	}
+}
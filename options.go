@@ -0,0 +1,433 @@
+package decompress
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Option configures the RoundTripper created by New
+type Option func(r *RoundTripper)
+
+// New returns the RoundTripper configured by the options
+func New(opts ...Option) *RoundTripper {
+	r := &RoundTripper{}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Wrap returns the RoundTripper that decompresses the responses of rt, configured by the options.
+// It is useful for the middleware-style composition of the transports:
+//
+//	client.Transport = decompress.Wrap(client.Transport, decompress.WithAdvertiseEncodings())
+func Wrap(rt http.RoundTripper, opts ...Option) http.RoundTripper {
+	r := New(opts...)
+	r.Wrap = rt
+	return r
+}
+
+// NewClient returns a copy of base, whose transport is wrapped by the RoundTripper configured by the options.
+// base is not modified. If base is nil, a zero http.Client is used
+func NewClient(base *http.Client, opts ...Option) *http.Client {
+	var cli http.Client
+	if base != nil {
+		cli = *base
+	}
+	cli.Transport = Wrap(cli.Transport, opts...)
+	return &cli
+}
+
+// WithTransport sets the actual RoundTripper. See RoundTripper.Wrap
+func WithTransport(rt http.RoundTripper) Option {
+	return func(r *RoundTripper) {
+		r.Wrap = rt
+	}
+}
+
+// WithDecoder adds the decoder for the content coding name. See RoundTripper.Decoders
+func WithDecoder(name string, f DecoderFactory) Option {
+	return func(r *RoundTripper) {
+		if r.Decoders == nil {
+			r.Decoders = make(map[string]DecoderFactory)
+		}
+		r.Decoders[name] = f
+	}
+}
+
+// WithAlias adds alias as an alternative name of the content coding name. See RoundTripper.Aliases
+func WithAlias(alias, name string) Option {
+	return func(r *RoundTripper) {
+		if r.Aliases == nil {
+			r.Aliases = make(map[string]string)
+		}
+		r.Aliases[alias] = name
+	}
+}
+
+// WithEncodings restricts the content codings to be decoded. See RoundTripper.Encodings
+func WithEncodings(names ...string) Option {
+	return func(r *RoundTripper) {
+		r.Encodings = append([]string{}, names...)
+	}
+}
+
+// WithGzipOptions sets the options for the gzip decoder. See RoundTripper.Gzip
+func WithGzipOptions(o GzipOptions) Option {
+	return func(r *RoundTripper) {
+		r.Gzip = o
+	}
+}
+
+// WithKeyProvider enables the `aes128gcm` content coding with the key provider. See RoundTripper.KeyProvider
+func WithKeyProvider(fn func(keyID []byte) ([]byte, error)) Option {
+	return func(r *RoundTripper) {
+		r.KeyProvider = fn
+	}
+}
+
+// WithDeltaBase enables the `vcdiff` delta encoding with the base provider. See RoundTripper.DeltaBase
+func WithDeltaBase(fn func(res *http.Response) ([]byte, error)) Option {
+	return func(r *RoundTripper) {
+		r.DeltaBase = fn
+	}
+}
+
+// WithPreference sets the preference of the content coding name. See RoundTripper.Preferences
+func WithPreference(name string, q float64) Option {
+	return func(r *RoundTripper) {
+		if r.Preferences == nil {
+			r.Preferences = make(map[string]float64)
+		}
+		r.Preferences[name] = q
+	}
+}
+
+// WithAdvertiseEncodings makes the RoundTripper set the Accept-Encoding header. See RoundTripper.AdvertiseEncodings
+func WithAdvertiseEncodings() Option {
+	return func(r *RoundTripper) {
+		r.AdvertiseEncodings = true
+	}
+}
+
+// WithLenientParsing makes the RoundTripper tolerate the parameters of the content codings. See RoundTripper.LenientParsing
+func WithLenientParsing() Option {
+	return func(r *RoundTripper) {
+		r.LenientParsing = true
+	}
+}
+
+// WithBase64 enables the non-standard `base64` content coding. See RoundTripper.Base64
+func WithBase64() Option {
+	return func(r *RoundTripper) {
+		r.Base64 = true
+	}
+}
+
+// WithCloneResponse makes the RoundTripper return a copy of the response. See RoundTripper.CloneResponse
+func WithCloneResponse() Option {
+	return func(r *RoundTripper) {
+		r.CloneResponse = true
+	}
+}
+
+// WithPreserveOriginalHeaders makes the RoundTripper keep the removed headers. See RoundTripper.PreserveOriginalHeaders
+func WithPreserveOriginalHeaders() Option {
+	return func(r *RoundTripper) {
+		r.PreserveOriginalHeaders = true
+	}
+}
+
+// WithAnnotationHeader makes the RoundTripper set the decoded content codings to the response header named name.
+// See RoundTripper.AnnotationHeader
+func WithAnnotationHeader(name string) Option {
+	return func(r *RoundTripper) {
+		r.AnnotationHeader = name
+	}
+}
+
+// WithPassThroughUnsupported makes the RoundTripper return the untouched response of an unsupported content coding.
+// See RoundTripper.PassThroughUnsupported
+func WithPassThroughUnsupported() Option {
+	return func(r *RoundTripper) {
+		r.PassThroughUnsupported = true
+	}
+}
+
+// WithPartialDecoding makes the RoundTripper decode the supported codings of a mixed chain. See RoundTripper.PartialDecoding
+func WithPartialDecoding() Option {
+	return func(r *RoundTripper) {
+		r.PartialDecoding = true
+	}
+}
+
+// WithDisableHeader sets the name of the request header that disables the decompression. See RoundTripper.DisableHeader
+func WithDisableHeader(name string) Option {
+	return func(r *RoundTripper) {
+		r.DisableHeader = name
+	}
+}
+
+// WithPolicy adds the policy for the requests matching its patterns. See RoundTripper.Policies
+func WithPolicy(p Policy) Option {
+	return func(r *RoundTripper) {
+		r.Policies = append(r.Policies, p)
+	}
+}
+
+// WithSkipContentTypes sets the media types of the responses not to be decompressed. See RoundTripper.SkipContentTypes
+func WithSkipContentTypes(types ...string) Option {
+	return func(r *RoundTripper) {
+		r.SkipContentTypes = append([]string{}, types...)
+	}
+}
+
+// WithStatusFilter sets the filter of the status codes of the responses to be decompressed. See RoundTripper.StatusFilter
+func WithStatusFilter(fn func(code int) bool) Option {
+	return func(r *RoundTripper) {
+		r.StatusFilter = fn
+	}
+}
+
+// WithStatusClasses restricts the decompression to the responses of the status classes, e.g. 2 for 2xx.
+// See RoundTripper.StatusFilter
+func WithStatusClasses(classes ...int) Option {
+	classes = append([]int{}, classes...)
+	return WithStatusFilter(func(code int) bool {
+		return slices.Contains(classes, code/100)
+	})
+}
+
+// WithMethods restricts the decompression to the responses of the request methods. See RoundTripper.Methods
+func WithMethods(methods ...string) Option {
+	return func(r *RoundTripper) {
+		r.Methods = append([]string{}, methods...)
+	}
+}
+
+// WithStrictAdvertised makes the RoundTripper decompress only the advertised content codings. See RoundTripper.StrictAdvertised
+func WithStrictAdvertised() Option {
+	return func(r *RoundTripper) {
+		r.StrictAdvertised = true
+	}
+}
+
+// WithBufferLimit makes the RoundTripper buffer the decompressed body up to n bytes. See RoundTripper.BufferLimit
+func WithBufferLimit(n int64) Option {
+	return func(r *RoundTripper) {
+		r.BufferLimit = n
+	}
+}
+
+// WithSpill makes the RoundTripper buffer the decompressed body, in a temporary file of dir beyond threshold bytes.
+// See RoundTripper.SpillThreshold
+func WithSpill(threshold int64, dir string) Option {
+	return func(r *RoundTripper) {
+		r.SpillThreshold = threshold
+		r.SpillDir = dir
+	}
+}
+
+// WithAdaptiveBufferLimit makes the RoundTripper buffer the decompressed body up to n bytes, and stream the larger body.
+// See RoundTripper.AdaptiveBufferLimit
+func WithAdaptiveBufferLimit(n int64) Option {
+	return func(r *RoundTripper) {
+		r.AdaptiveBufferLimit = n
+	}
+}
+
+// WithMaxDecompressedBytes limits the size of the decompressed body to n bytes. See RoundTripper.MaxDecompressedBytes
+func WithMaxDecompressedBytes(n int64) Option {
+	return func(r *RoundTripper) {
+		r.MaxDecompressedBytes = n
+	}
+}
+
+// WithMaxCompressionRatio limits the compression ratio of the body to ratio after reading minBytes decompressed bytes.
+// See RoundTripper.MaxCompressionRatio
+func WithMaxCompressionRatio(ratio float64, minBytes int64) Option {
+	return func(r *RoundTripper) {
+		r.MaxCompressionRatio = ratio
+		r.CompressionRatioMinBytes = minBytes
+	}
+}
+
+// WithMaxEncodings limits the number of the chained content codings to n. See RoundTripper.MaxEncodings
+func WithMaxEncodings(n int) Option {
+	return func(r *RoundTripper) {
+		r.MaxEncodings = n
+	}
+}
+
+// WithQuota sets the budget of the decompressed bytes. See RoundTripper.Quota
+func WithQuota(q *Quota) Option {
+	return func(r *RoundTripper) {
+		r.Quota = q
+	}
+}
+
+// WithReadTimeout limits the time of each Read of the decompressed body. See RoundTripper.ReadTimeout
+func WithReadTimeout(d time.Duration) Option {
+	return func(r *RoundTripper) {
+		r.ReadTimeout = d
+	}
+}
+
+// WithMemoryBudget sets the budget of the memory held by the decoders. See RoundTripper.MemoryBudget
+func WithMemoryBudget(b *MemoryBudget) Option {
+	return func(r *RoundTripper) {
+		r.MemoryBudget = b
+	}
+}
+
+// WithVerifyDigest makes the decompressed body verify the Repr-Digest or Digest header. See RoundTripper.VerifyDigest
+func WithVerifyDigest() Option {
+	return func(r *RoundTripper) {
+		r.VerifyDigest = true
+	}
+}
+
+// WithVerifyContentLength makes the decompressed body verify the size of the compressed stream by the Content-Length.
+// See RoundTripper.VerifyContentLength
+func WithVerifyContentLength() Option {
+	return func(r *RoundTripper) {
+		r.VerifyContentLength = true
+	}
+}
+
+// WithDrainOnClose sets the maximum bytes of the remaining compressed stream discarded on Close. See RoundTripper.DrainOnClose
+func WithDrainOnClose(n int64) Option {
+	return func(r *RoundTripper) {
+		r.DrainOnClose = n
+	}
+}
+
+// WithBufferSizes sets the size of the buffer between the network body and the decoder, and the size of the buffer of
+// the decompressed body. See RoundTripper.ReadBufferSize and RoundTripper.OutputBufferSize
+func WithBufferSizes(read, output int) Option {
+	return func(r *RoundTripper) {
+		r.ReadBufferSize = read
+		r.OutputBufferSize = output
+	}
+}
+
+// WithLimiter limits the number of the responses being decompressed at once. See RoundTripper.Limiter
+func WithLimiter(l *Limiter) Option {
+	return func(r *RoundTripper) {
+		r.Limiter = l
+	}
+}
+
+// WithBufferPool sets the pool of the buffers used for copying and buffering the bodies. See RoundTripper.BufferPool
+func WithBufferPool(p BufferPool) Option {
+	return func(r *RoundTripper) {
+		r.BufferPool = p
+	}
+}
+
+// WithPipelineDepth enables the pipelined decompression in a background goroutine. See RoundTripper.PipelineDepth
+func WithPipelineDepth(depth int) Option {
+	return func(r *RoundTripper) {
+		r.PipelineDepth = depth
+	}
+}
+
+// WithLowLatency makes the decompressed body deliver the decoded bytes per flush of the server. See RoundTripper.LowLatency
+func WithLowLatency() Option {
+	return func(r *RoundTripper) {
+		r.LowLatency = true
+	}
+}
+
+// PresetBalanced configures the RoundTripper for the general use: the moderately sized buffers without the pipelining.
+// The decompression is not limited by the preset, since the streamed responses (e.g. text/event-stream) stay open
+// for long. Use WithLimiter following the preset to limit it.
+// The options following the preset override its settings
+func PresetBalanced() Option {
+	return func(r *RoundTripper) {
+		r.ReadBufferSize = 16 << 10
+		r.OutputBufferSize = 0
+		r.PipelineDepth = 0
+		r.Limiter = nil
+		r.MemoryBudget = nil
+	}
+}
+
+// PresetThroughput configures the RoundTripper for the large downloads: the large buffers, and the pipelined
+// decompression overlapping the network reads with the decoding. For the gzip and deflate content codings, the decoders
+// of the klauspost subpackage speed it up further:
+//
+//	decompress.New(
+//		decompress.PresetThroughput(),
+//		decompress.WithDecoder("gzip", klauspost.NewGzipFactory(decompress.GzipOptions{})),
+//		decompress.WithDecoder("deflate", klauspost.NewDeflateFactory()),
+//	)
+//
+// The options following the preset override its settings
+func PresetThroughput() Option {
+	return func(r *RoundTripper) {
+		r.ReadBufferSize = 64 << 10
+		r.OutputBufferSize = 0
+		r.PipelineDepth = 4
+		r.Limiter = nil
+		r.MemoryBudget = nil
+	}
+}
+
+// PresetLowMemory configures the RoundTripper for the memory-constrained environments: the small buffers, and
+// the memory of the decoders limited to 32 MiB in total, that makes the responses wait for the others to finish.
+// The options following the preset override its settings
+func PresetLowMemory() Option {
+	return func(r *RoundTripper) {
+		r.ReadBufferSize = 1 << 10
+		r.OutputBufferSize = 0
+		r.PipelineDepth = 0
+		r.Limiter = nil
+		r.MemoryBudget = NewMemoryBudget(32<<20, BudgetWait)
+	}
+}
+
+// WithMetrics sets the receiver of the events of the decompression. See RoundTripper.Metrics
+func WithMetrics(m Metrics) Option {
+	return func(r *RoundTripper) {
+		r.Metrics = m
+	}
+}
+
+// WithLogger sets the logger of the decisions and the errors of the decompression. See RoundTripper.Logger
+func WithLogger(l *slog.Logger) Option {
+	return func(r *RoundTripper) {
+		r.Logger = l
+	}
+}
+
+// WithTrace sets the hooks run at the stages of the decompression. See RoundTripper.Trace
+func WithTrace(t *DecodeTrace) Option {
+	return func(r *RoundTripper) {
+		r.Trace = t
+	}
+}
+
+// WithTeeCompressed copies the compressed streams to the writers returned by fn. See RoundTripper.TeeCompressed
+func WithTeeCompressed(fn func(res *http.Response) io.Writer) Option {
+	return func(r *RoundTripper) {
+		r.TeeCompressed = fn
+	}
+}
+
+// WithOnDecode sets the callback called when the decoding of a response starts. See RoundTripper.OnDecode
+func WithOnDecode(fn func(req *http.Request, res *http.Response, encodings []string)) Option {
+	return func(r *RoundTripper) {
+		r.OnDecode = fn
+	}
+}
+
+// WithOnError sets the callback called when the decoding of a response fails. See RoundTripper.OnError
+func WithOnError(fn func(req *http.Request, err error)) Option {
+	return func(r *RoundTripper) {
+		r.OnError = fn
+	}
+}
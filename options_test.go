@@ -0,0 +1,199 @@
+package decompress_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestPreset(t *testing.T) {
+	tt := []struct {
+		title            string
+		opts             []decompress.Option
+		wantReadBuffer   int
+		wantPipeline     int
+		wantLimiter      bool
+		wantMemoryBudget bool
+	}{
+		{title: "balanced", opts: []decompress.Option{decompress.PresetBalanced()}, wantReadBuffer: 16 << 10},
+		{
+			title:          "balanced with limiter",
+			opts:           []decompress.Option{decompress.PresetBalanced(), decompress.WithLimiter(decompress.NewLimiter(2))},
+			wantReadBuffer: 16 << 10,
+			wantLimiter:    true,
+		},
+		{title: "throughput", opts: []decompress.Option{decompress.PresetThroughput()}, wantReadBuffer: 64 << 10, wantPipeline: 4},
+		{title: "low memory", opts: []decompress.Option{decompress.PresetLowMemory()}, wantReadBuffer: 1 << 10, wantMemoryBudget: true},
+		{
+			title:          "overridden",
+			opts:           []decompress.Option{decompress.PresetThroughput(), decompress.WithPipelineDepth(1), decompress.WithBufferSizes(512, 0)},
+			wantReadBuffer: 512,
+			wantPipeline:   1,
+		},
+		{
+			title:          "last preset wins",
+			opts:           []decompress.Option{decompress.PresetLowMemory(), decompress.PresetThroughput()},
+			wantReadBuffer: 64 << 10,
+			wantPipeline:   4,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.New(te.opts...)
+			if got, want := dr.ReadBufferSize, te.wantReadBuffer; got != want {
+				t.Errorf("ReadBufferSize got %v, want %v", got, want)
+			}
+			if got, want := dr.PipelineDepth, te.wantPipeline; got != want {
+				t.Errorf("PipelineDepth got %v, want %v", got, want)
+			}
+			if got, want := dr.Limiter != nil, te.wantLimiter; got != want {
+				t.Errorf("Limiter got %v, want %v", got, want)
+			}
+			if got, want := dr.MemoryBudget != nil, te.wantMemoryBudget; got != want {
+				t.Errorf("MemoryBudget got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tt := []struct {
+		title                      string
+		opts                       []decompress.Option
+		resp                       *http.Response
+		wantBody                   string
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:    "no options",
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "decoder",
+			opts:     []decompress.Option{decompress.WithDecoder("x-rot13-local", decompress.DecoderFunc(newROT13Reader))},
+			resp:     newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-local"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "alias",
+			opts:     []decompress.Option{decompress.WithAlias("x-gz", "gzip")},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "x-gz"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "encodings",
+			opts:     []decompress.Option{decompress.WithEncodings("gzip")},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "x-gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:                      "encodings not allowed",
+			opts:                       []decompress.Option{decompress.WithEncodings("gzip")},
+			resp:                       newResponse(t, deflateBytes([]byte("foobarbaz")), "deflate"),
+			wantErrUnsupportedEncoding: true,
+		},
+		{
+			title:    "lenient parsing and base64",
+			opts:     []decompress.Option{decompress.WithLenientParsing(), decompress.WithBase64()},
+			resp:     newResponse(t, []byte(base64.StdEncoding.EncodeToString([]byte("foobarbaz"))), "base64;q=1"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "preset balanced",
+			opts:     []decompress.Option{decompress.PresetBalanced()},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "preset throughput",
+			opts:     []decompress.Option{decompress.PresetThroughput()},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:    "preset low memory",
+			opts:     []decompress.Option{decompress.PresetLowMemory()},
+			resp:     newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantBody: "foobarbaz",
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			opts := append([]decompress.Option{decompress.WithTransport(&stubRoundTripper{response: te.resp})}, te.opts...)
+			dr := decompress.New(opts...)
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNew_AcceptEncoding(t *testing.T) {
+	dr := decompress.New(
+		decompress.WithEncodings("gzip", "zstd", "br"),
+		decompress.WithPreference("gzip", 0.5),
+		decompress.WithAdvertiseEncodings(),
+	)
+	if got, want := dr.AcceptEncoding(), "br, zstd, gzip;q=0.5"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := dr.AdvertiseEncodings, true; got != want {
+		t.Errorf("AdvertiseEncodings got %v, want %v", got, want)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	rt := decompress.Wrap(
+		&stubRoundTripper{response: newResponse(t, rot13([]byte("foobarbaz")), "x-rot13-local")},
+		decompress.WithDecoder("x-rot13-local", decompress.DecoderFunc(newROT13Reader)),
+	)
+	req, _ := http.NewRequest("GET", "/", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	transport := &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")}
+	base := &http.Client{Transport: transport, Timeout: time.Minute}
+	cli := decompress.NewClient(base, decompress.WithEncodings("gzip"))
+	if got, want := base.Transport, http.RoundTripper(transport); got != want {
+		t.Errorf("base Transport modified: got %v, want %v", got, want)
+	}
+	if got, want := cli.Timeout, time.Minute; got != want {
+		t.Errorf("Timeout got %v, want %v", got, want)
+	}
+	resp, err := cli.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+
+	if _, ok := decompress.NewClient(nil).Transport.(*decompress.RoundTripper); !ok {
+		t.Error("Transport of the client from nil is not RoundTripper")
+	}
+}
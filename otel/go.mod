@@ -0,0 +1,22 @@
+module github.com/kei2100/decompress-roundtripper/otel
+
+go 1.21
+
+require (
+	github.com/kei2100/decompress-roundtripper v0.0.0-20261015080837-280d162cd1c1
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/metric v1.28.0
+	go.opentelemetry.io/otel/sdk v1.28.0
+	go.opentelemetry.io/otel/sdk/metric v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+)
+
+// for the local development only. The modules depending on this module ignore it, and use the required version above
+replace github.com/kei2100/decompress-roundtripper => ../
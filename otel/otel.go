@@ -0,0 +1,188 @@
+// Package otel provides the OpenTelemetry instrumentation of the decompression. It records the events of
+// the decompression on the span of the request context, and optionally the metrics by the meter API.
+// The instrumentation implements decompress.ContextMetrics, so that it is wired in by setting it to the RoundTripper:
+//
+//	inst, err := otel.New(otel.Options{MeterProvider: otelglobal.GetMeterProvider()})
+//	if err != nil {
+//		return err
+//	}
+//	rt := decompress.New(decompress.WithMetrics(inst))
+//
+// The span is annotated with the events "decompress.start" and "decompress.done", whose attributes are
+// the content codings decoded, the compressed and decompressed sizes and the decode duration in seconds.
+// The decode errors are recorded by Span.RecordError.
+// The instrumentation also implements decompress.DecoderMetrics, and records the pool gets and the live decoders
+// as the metrics without the span
+package otel
+
+import (
+	"context"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of the meter
+const ScopeName = "github.com/kei2100/decompress-roundtripper/otel"
+
+// The attribute keys of the span events and the metrics
+const (
+	EncodingKey         = attribute.Key("decompress.encoding")
+	CompressedSizeKey   = attribute.Key("decompress.compressed_size")
+	DecompressedSizeKey = attribute.Key("decompress.decompressed_size")
+	DurationKey         = attribute.Key("decompress.duration")
+	PoolResultKey       = attribute.Key("decompress.pool.result")
+)
+
+// Options is the options for the instrumentation
+type Options struct {
+	// MeterProvider is the provider of the meter recording the metrics. If nil, the metrics are not recorded,
+	// and only the spans are annotated
+	MeterProvider metric.MeterProvider
+}
+
+// Instrumentation records the events of the decompression. It implements decompress.ContextMetrics
+type Instrumentation struct {
+	metered      bool
+	responses    metric.Int64Counter
+	errors       metric.Int64Counter
+	compressed   metric.Int64Counter
+	decompressed metric.Int64Counter
+	duration     metric.Float64Histogram
+	poolGets     metric.Int64Counter
+	liveDecoders metric.Int64UpDownCounter
+}
+
+var (
+	_ decompress.ContextMetrics = (*Instrumentation)(nil)
+	_ decompress.DecoderMetrics = (*Instrumentation)(nil)
+)
+
+// New returns the Instrumentation with the options. It returns an error if the instruments cannot be created
+func New(o Options) (*Instrumentation, error) {
+	inst := &Instrumentation{}
+	if o.MeterProvider == nil {
+		return inst, nil
+	}
+	m := o.MeterProvider.Meter(ScopeName)
+	var err error
+	if inst.responses, err = m.Int64Counter("decompress.responses",
+		metric.WithDescription("Number of the responses started to be decoded."), metric.WithUnit("{response}")); err != nil {
+		return nil, err
+	}
+	if inst.errors, err = m.Int64Counter("decompress.errors",
+		metric.WithDescription("Number of the responses failed to be decoded."), metric.WithUnit("{response}")); err != nil {
+		return nil, err
+	}
+	if inst.compressed, err = m.Int64Counter("decompress.compressed_bytes",
+		metric.WithDescription("Bytes of the compressed bodies read from the wire."), metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	if inst.decompressed, err = m.Int64Counter("decompress.decompressed_bytes",
+		metric.WithDescription("Bytes of the decompressed bodies produced."), metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	if inst.duration, err = m.Float64Histogram("decompress.duration",
+		metric.WithDescription("Duration from the start of the decoding until the body is closed."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if inst.poolGets, err = m.Int64Counter("decompress.decoder_pool.gets",
+		metric.WithDescription("Number of the decoders got from the pools, by the result of hit or miss."), metric.WithUnit("{decoder}")); err != nil {
+		return nil, err
+	}
+	if inst.liveDecoders, err = m.Int64UpDownCounter("decompress.decoders.live",
+		metric.WithDescription("Number of the decoders created and not closed yet."), metric.WithUnit("{decoder}")); err != nil {
+		return nil, err
+	}
+	inst.metered = true
+	return inst, nil
+}
+
+// ForContext implements decompress.ContextMetrics. The returned Metrics records the events on the span of ctx
+func (i *Instrumentation) ForContext(ctx context.Context) decompress.Metrics {
+	return &requestMetrics{inst: i, ctx: ctx, span: trace.SpanFromContext(ctx)}
+}
+
+// DecodeStarted implements decompress.Metrics. It records only the metrics, since there is no span without the context
+func (i *Instrumentation) DecodeStarted(encoding string) {
+	i.ForContext(context.Background()).DecodeStarted(encoding)
+}
+
+// DecodeFinished implements decompress.Metrics. See DecodeStarted
+func (i *Instrumentation) DecodeFinished(encoding string, compressed, decompressed int64, d time.Duration) {
+	i.ForContext(context.Background()).DecodeFinished(encoding, compressed, decompressed, d)
+}
+
+// DecodeFailed implements decompress.Metrics. See DecodeStarted
+func (i *Instrumentation) DecodeFailed(encoding string, err error) {
+	i.ForContext(context.Background()).DecodeFailed(encoding, err)
+}
+
+// DecoderCreated implements decompress.DecoderMetrics
+func (i *Instrumentation) DecoderCreated(encoding string, pooled, reused bool) {
+	if !i.metered {
+		return
+	}
+	ctx, enc := context.Background(), EncodingKey.String(encoding)
+	i.liveDecoders.Add(ctx, 1, metric.WithAttributes(enc))
+	if reused {
+		i.poolGets.Add(ctx, 1, metric.WithAttributes(enc, PoolResultKey.String("hit")))
+	} else if pooled {
+		i.poolGets.Add(ctx, 1, metric.WithAttributes(enc, PoolResultKey.String("miss")))
+	}
+}
+
+// DecoderClosed implements decompress.DecoderMetrics
+func (i *Instrumentation) DecoderClosed(encoding string) {
+	if i.metered {
+		i.liveDecoders.Add(context.Background(), -1, metric.WithAttributes(EncodingKey.String(encoding)))
+	}
+}
+
+// requestMetrics records the events of a response
+type requestMetrics struct {
+	inst *Instrumentation
+	ctx  context.Context
+	span trace.Span
+}
+
+func (m *requestMetrics) DecodeStarted(encoding string) {
+	enc := EncodingKey.String(encoding)
+	if m.span.IsRecording() {
+		m.span.AddEvent("decompress.start", trace.WithAttributes(enc))
+	}
+	if m.inst.metered {
+		m.inst.responses.Add(m.ctx, 1, metric.WithAttributes(enc))
+	}
+}
+
+func (m *requestMetrics) DecodeFinished(encoding string, compressed, decompressed int64, d time.Duration) {
+	enc := EncodingKey.String(encoding)
+	if m.span.IsRecording() {
+		m.span.AddEvent("decompress.done", trace.WithAttributes(
+			enc,
+			CompressedSizeKey.Int64(compressed),
+			DecompressedSizeKey.Int64(decompressed),
+			DurationKey.Float64(d.Seconds()),
+		))
+	}
+	if m.inst.metered {
+		opt := metric.WithAttributes(enc)
+		m.inst.compressed.Add(m.ctx, compressed, opt)
+		m.inst.decompressed.Add(m.ctx, decompressed, opt)
+		m.inst.duration.Record(m.ctx, d.Seconds(), opt)
+	}
+}
+
+func (m *requestMetrics) DecodeFailed(encoding string, err error) {
+	enc := EncodingKey.String(encoding)
+	if m.span.IsRecording() {
+		m.span.RecordError(err, trace.WithAttributes(enc))
+	}
+	if m.inst.metered {
+		m.inst.errors.Add(m.ctx, 1, metric.WithAttributes(enc))
+	}
+}
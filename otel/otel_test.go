@@ -0,0 +1,141 @@
+package otel_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+	dotel "github.com/kei2100/decompress-roundtripper/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstrumentation(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	inst, err := dotel.New(dotel.Options{MeterProvider: mp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := gzipBytes([]byte("foobarbaz"))
+	responses := map[string][]byte{"gzip": body, "x-unknown": []byte("foobarbaz")}
+	dr := decompress.New(
+		decompress.WithMetrics(inst),
+		decompress.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ce := req.URL.Query().Get("ce")
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Encoding": {ce}},
+				Body:       io.NopCloser(bytes.NewReader(responses[ce])),
+			}, nil
+		})),
+	)
+	for _, ce := range []string{"gzip", "x-unknown"} {
+		ctx, span := tp.Tracer("test").Start(context.Background(), ce)
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/?ce="+ce, nil)
+		if resp, err := dr.RoundTrip(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		span.End()
+	}
+
+	ended := spans.Ended()
+	if got, want := len(ended), 2; got != want {
+		t.Fatalf("spans got %v, want %v", got, want)
+	}
+	var names []string
+	for _, e := range ended[0].Events() {
+		names = append(names, e.Name)
+		if e.Name != "decompress.done" {
+			continue
+		}
+		attrs := map[string]int64{}
+		for _, a := range e.Attributes {
+			attrs[string(a.Key)] = a.Value.AsInt64()
+		}
+		if got, want := attrs[string(dotel.CompressedSizeKey)], int64(len(body)); got != want {
+			t.Errorf("compressed size got %v, want %v", got, want)
+		}
+		if got, want := attrs[string(dotel.DecompressedSizeKey)], int64(9); got != want {
+			t.Errorf("decompressed size got %v, want %v", got, want)
+		}
+	}
+	if got, want := names, []string{"decompress.start", "decompress.done"}; !slices.Equal(got, want) {
+		t.Errorf("events of the decoded span got %v, want %v", got, want)
+	}
+	if got, want := len(ended[1].Events()), 1; got != want {
+		t.Fatalf("events of the failed span got %v, want %v", got, want)
+	}
+	if got, want := ended[1].Events()[0].Name, "exception"; got != want {
+		t.Errorf("event of the failed span got %v, want %v", got, want)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	sums := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range sum.DataPoints {
+					sums[m.Name] += dp.Value
+				}
+			}
+		}
+	}
+	want := map[string]int64{
+		"decompress.responses":          1,
+		"decompress.errors":             1,
+		"decompress.compressed_bytes":   int64(len(body)),
+		"decompress.decompressed_bytes": 9,
+		"decompress.decoder_pool.gets":  1,
+		"decompress.decoders.live":      0,
+	}
+	for name, v := range want {
+		if got := sums[name]; got != v {
+			t.Errorf("%s got %v, want %v", name, got, v)
+		}
+	}
+}
+
+func TestInstrumentation_NoMeter(t *testing.T) {
+	inst, err := dotel.New(dotel.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no span and no meter
+	inst.DecodeStarted("gzip")
+	inst.DecodeFinished("gzip", 1, 2, 0)
+	inst.DecodeFailed("gzip", io.ErrUnexpectedEOF)
+	inst.DecoderCreated("gzip", true, false)
+	inst.DecoderClosed("gzip")
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func gzipBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := gzip.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
@@ -0,0 +1,31 @@
+package decompress
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// PeekBody returns the first n bytes of the decompressed body of res without consuming them, e.g. for sniffing,
+// routing or logging. The body of res is replaced by the body that yields the whole stream including the peeked bytes.
+// The body is decompressed if it is still compressed. If the body is shorter than n bytes, the whole body is returned
+// without an error. If an error occurs while reading, the bytes read until then are returned with the error
+func PeekBody(res *http.Response, n int) ([]byte, error) {
+	if err := DecodeResponse(res); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	m, err := io.ReadFull(res.Body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	buf = buf[:m]
+	res.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(buf), res.Body), Closer: res.Body}
+	return buf, err
+}
+
+// peekedBody is the body that yields the peeked bytes followed by the rest of the body
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,62 @@
+package decompress_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestPeekBody(t *testing.T) {
+	tt := []struct {
+		title           string
+		body            []byte
+		contentEncoding string
+		n               int
+		wantPeek        string
+		wantBody        string
+		wantErr         bool
+	}{
+		{title: "compressed", body: gzipBytes([]byte("foobarbaz")), contentEncoding: "gzip", n: 3, wantPeek: "foo", wantBody: "foobarbaz"},
+		{title: "not compressed", body: []byte("foobarbaz"), n: 6, wantPeek: "foobar", wantBody: "foobarbaz"},
+		{title: "shorter than n", body: gzipBytes([]byte("foo")), contentEncoding: "gzip", n: 10, wantPeek: "foo", wantBody: "foo"},
+		{title: "empty", body: nil, contentEncoding: "gzip", n: 10, wantPeek: "", wantBody: ""},
+		{title: "zero", body: gzipBytes([]byte("foobarbaz")), contentEncoding: "gzip", n: 0, wantPeek: "", wantBody: "foobarbaz"},
+		{title: "corrupted", body: []byte("foobarbaz"), contentEncoding: "gzip", n: 3, wantErr: true},
+		{title: "unsupported", body: []byte("foobarbaz"), contentEncoding: "x-unknown", n: 3, wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, te.body, te.contentEncoding)
+			body := &closeRecorder{ReadCloser: resp.Body}
+			resp.Body = body
+			peek, err := decompress.PeekBody(resp, te.n)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(peek), te.wantPeek; got != want {
+				t.Errorf("peek got %q, want %q", got, want)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+			if err := resp.Body.Close(); err != nil {
+				t.Error(err)
+			}
+			if !body.closed {
+				t.Error("body is not closed")
+			}
+		})
+	}
+}
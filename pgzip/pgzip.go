@@ -0,0 +1,47 @@
+// Package pgzip provides the decoder for the `gzip` content coding using github.com/klauspost/pgzip.
+// The compressed body is read ahead and the decompressed blocks are checksummed on the background goroutines, that
+// speeds up decoding large bodies. Note that the inflation itself runs on a single goroutine, so the decoder does not
+// decompress the blocks in parallel.
+// Since a block is delivered only after it is fully read, the decoder is not suitable for the streaming bodies,
+// such as text/event-stream.
+// Unlike the other subpackages, importing the package does not register the decoder, since the built-in decoder
+// already supports the content coding. Set the decoder to RoundTripper.Decoders to use it:
+//
+//	rt := &decompress.RoundTripper{
+//		Decoders: map[string]decompress.DecoderFactory{"gzip": pgzip.NewFactory(pgzip.Options{})},
+//	}
+package pgzip
+
+import (
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/klauspost/pgzip"
+)
+
+// Options is the options for the read-ahead gzip decoder
+type Options struct {
+	// BlockSize is the size of the decompressed blocks buffered ahead of the reader. If 0, 250000 is used
+	BlockSize int
+	// Blocks is the read-ahead depth, that is the maximum number of the decompressed blocks buffered ahead of
+	// the reader. It is not the number of the workers, since the blocks are inflated one by one. If 0, 16 is used
+	Blocks int
+	// DisableMultistream makes the decoder stop at the end of the first gzip member.
+	// By default, a body consisting of concatenated gzip members is decoded as a single stream
+	DisableMultistream bool
+}
+
+// NewFactory returns the factory of the `gzip` decoders with the options
+func NewFactory(o Options) decompress.DecoderFactory {
+	blockSize, blocks := o.BlockSize, o.Blocks
+	if blockSize <= 0 {
+		blockSize = 250000
+	}
+	if blocks <= 0 {
+		blocks = 16
+	}
+	opts := decompress.GzipOptions{DisableMultistream: o.DisableMultistream}
+	return decompress.NewGzipFactory(opts, func(r io.Reader) (decompress.GzipReader, error) {
+		return pgzip.NewReaderN(r, blockSize, blocks)
+	})
+}
@@ -0,0 +1,71 @@
+package pgzip_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper/codectest"
+	"github.com/kei2100/decompress-roundtripper/pgzip"
+)
+
+func TestNewFactory(t *testing.T) {
+	large := bytes.Repeat([]byte("foobarbaz"), 100000)
+	multi := append(gzipBytes([]byte("foobar")), gzipBytes([]byte("baz"))...)
+	tt := []struct {
+		title    string
+		opts     pgzip.Options
+		body     []byte
+		wantBody []byte
+	}{
+		{title: "defaults", body: gzipBytes(large), wantBody: large},
+		{title: "small blocks", opts: pgzip.Options{BlockSize: 1024, Blocks: 2}, body: gzipBytes(large), wantBody: large},
+		{title: "multistream", body: multi, wantBody: []byte("foobarbaz")},
+		{title: "disable multistream", opts: pgzip.Options{DisableMultistream: true}, body: multi, wantBody: []byte("foobar")},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			d, err := pgzip.NewFactory(te.opts).NewDecoder(bytes.NewReader(gzipBytes([]byte("foo"))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			if b, err := io.ReadAll(d); err != nil || string(b) != "foo" {
+				t.Fatalf("got %q %v, want foo", b, err)
+			}
+			if err := d.Reset(bytes.NewReader(te.body)); err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, te.wantBody) {
+				t.Errorf("body mismatch: got %d bytes, want %d bytes", len(b), len(te.wantBody))
+			}
+		})
+	}
+}
+
+func TestNewFactory_Conformance(t *testing.T) {
+	codectest.Suite{
+		Factory: pgzip.NewFactory(pgzip.Options{BlockSize: 1024, Blocks: 4}),
+		Encode: func(b []byte) ([]byte, error) {
+			return gzipBytes(b), nil
+		},
+	}.Run(t)
+}
+
+func gzipBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := gzip.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
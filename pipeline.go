@@ -0,0 +1,98 @@
+package decompress
+
+import (
+	"io"
+	"sync"
+)
+
+// pipelineChunk is a chunk of the decoded data read by the background goroutine
+type pipelineChunk struct {
+	buf []byte
+	n   int
+	err error
+}
+
+// pipelineReadCloser decodes rc in a background goroutine into the bounded queue of chunks, so that the network reads
+// and the decoding overlap with the consumer. The goroutine owns rc, and closes it when it stops
+type pipelineReadCloser struct {
+	chunks chan pipelineChunk
+	done   chan struct{}
+	raw    io.Closer
+	pool   BufferPool
+	once   sync.Once
+
+	buf []byte
+	cur []byte
+	err error
+}
+
+// newPipelineReadCloser starts decoding rc into depth chunks ahead. raw is the underlying body, that is closed on Close
+// to unblock the goroutine reading the network
+func newPipelineReadCloser(rc io.ReadCloser, raw io.Closer, depth int, pool BufferPool) *pipelineReadCloser {
+	p := &pipelineReadCloser{
+		chunks: make(chan pipelineChunk, depth),
+		done:   make(chan struct{}),
+		raw:    raw,
+		pool:   pool,
+	}
+	go p.run(rc)
+	return p
+}
+
+func (p *pipelineReadCloser) run(rc io.ReadCloser) {
+	defer rc.Close()
+	var buf []byte
+	for {
+		if buf == nil {
+			buf = p.pool.Get()
+		}
+		// the chunk is sent as soon as it is read, not to delay the streaming bodies
+		n, err := rc.Read(buf)
+		if n == 0 && err == nil {
+			continue
+		}
+		select {
+		case p.chunks <- pipelineChunk{buf: buf, n: n, err: err}:
+			buf = nil
+		case <-p.done:
+			p.pool.Put(buf)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *pipelineReadCloser) Read(b []byte) (int, error) {
+	if len(p.cur) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if p.buf != nil {
+			p.pool.Put(p.buf)
+			p.buf = nil
+		}
+		select {
+		case c := <-p.chunks:
+			p.buf, p.cur, p.err = c.buf, c.buf[:c.n], c.err
+		case <-p.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(b, p.cur)
+	p.cur = p.cur[n:]
+	if len(p.cur) == 0 && p.err != nil {
+		return n, p.err
+	}
+	return n, nil
+}
+
+func (p *pipelineReadCloser) Close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.done)
+		err = p.raw.Close()
+	})
+	return err
+}
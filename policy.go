@@ -0,0 +1,81 @@
+package decompress
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Policy is the settings of the RoundTripper for the requests matching the host and the path pattern,
+// so that a single RoundTripper can treat e.g. the trusted internal hosts differently from the arbitrary origins
+type Policy struct {
+	// Host is the pattern of the host of the request URL without the port, e.g. `*.internal.example.com`.
+	// The syntax of the pattern is the same as path.Match. If Host is empty, the policy matches all the hosts
+	Host string
+	// PathPrefix is the prefix of the path of the request URL, e.g. `/api/`. If PathPrefix is empty, the policy matches all the paths
+	PathPrefix string
+	// Encodings overrides RoundTripper.Encodings for the matching requests. If Encodings is nil, RoundTripper.Encodings is used
+	Encodings []string
+	// MaxDecompressedBytes overrides RoundTripper.MaxDecompressedBytes for the matching requests if it is not 0.
+	// A negative value removes the limit, e.g. for the trusted hosts
+	MaxDecompressedBytes int64
+	// Disabled disables the decompression of the matching requests. The request and the response pass through as is
+	Disabled bool
+}
+
+// match reports whether the policy matches req
+func (p *Policy) match(req *http.Request) bool {
+	if p.Host != "" {
+		host := req.URL.Hostname()
+		if host == "" {
+			host = req.Host
+			if h, _, ok := strings.Cut(host, ":"); ok {
+				host = h
+			}
+		}
+		if ok, _ := path.Match(strings.ToLower(p.Host), strings.ToLower(host)); !ok {
+			return false
+		}
+	}
+	return strings.HasPrefix(req.URL.Path, p.PathPrefix)
+}
+
+// policy returns the first policy of r.Policies matching req, or nil if there is no such policy
+func (r *RoundTripper) policy(req *http.Request) *Policy {
+	for i := range r.Policies {
+		if r.Policies[i].match(req) {
+			return &r.Policies[i]
+		}
+	}
+	return nil
+}
+
+type policyKey struct{}
+
+func withPolicy(ctx context.Context, p *Policy) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, policyKey{}, p)
+}
+
+// allowed reports whether the content coding name is allowed by the policy of ctx or r.Encodings
+func (r *RoundTripper) allowed(ctx context.Context, name string) bool {
+	encodings := r.Encodings
+	if p, ok := ctx.Value(policyKey{}).(*Policy); ok && p.Encodings != nil {
+		encodings = p.Encodings
+	}
+	return encodings == nil || slices.ContainsFunc(encodings, func(e string) bool {
+		return strings.EqualFold(e, name)
+	})
+}
+
+// maxDecompressedBytes returns the limit of the decompressed body by the policy of ctx or r.MaxDecompressedBytes
+func (r *RoundTripper) maxDecompressedBytes(ctx context.Context) int64 {
+	if p, ok := ctx.Value(policyKey{}).(*Policy); ok && p.MaxDecompressedBytes != 0 {
+		return p.MaxDecompressedBytes
+	}
+	return r.MaxDecompressedBytes
+}
@@ -0,0 +1,99 @@
+package decompress_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_RoundTrip_Policies(t *testing.T) {
+	policies := []decompress.Policy{
+		{Host: "*.internal.example.com", PathPrefix: "/raw/", Disabled: true},
+		{Host: "*.internal.example.com"},
+		{Host: "example.com", PathPrefix: "/api/", Encodings: []string{"deflate"}},
+		{Encodings: []string{"gzip"}},
+	}
+	tt := []struct {
+		title                      string
+		url                        string
+		contentEncoding            string
+		body                       []byte
+		wantBody                   string
+		wantAcceptEncoding         string
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:              "disabled",
+			url:                "http://a.internal.example.com/raw/file",
+			contentEncoding:    "gzip",
+			body:               gzipBytes([]byte("foobarbaz")),
+			wantBody:           string(gzipBytes([]byte("foobarbaz"))),
+			wantAcceptEncoding: "",
+		},
+		{
+			title:              "host pattern with port",
+			url:                "http://A.internal.example.com:8080/file",
+			contentEncoding:    "deflate",
+			body:               deflateBytes([]byte("foobarbaz")),
+			wantBody:           "foobarbaz",
+			wantAcceptEncoding: "br, deflate, gzip, zstd",
+		},
+		{
+			title:              "path prefix",
+			url:                "http://example.com/api/users",
+			contentEncoding:    "deflate",
+			body:               deflateBytes([]byte("foobarbaz")),
+			wantBody:           "foobarbaz",
+			wantAcceptEncoding: "deflate",
+		},
+		{
+			title:                      "encodings not allowed by the policy",
+			url:                        "http://example.com/api/users",
+			contentEncoding:            "gzip",
+			body:                       gzipBytes([]byte("foobarbaz")),
+			wantErrUnsupportedEncoding: true,
+		},
+		{
+			title:              "fallback",
+			url:                "http://example.com/",
+			contentEncoding:    "gzip",
+			body:               gzipBytes([]byte("foobarbaz")),
+			wantBody:           "foobarbaz",
+			wantAcceptEncoding: "gzip",
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var acceptEncoding string
+			dr := decompress.RoundTripper{
+				Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					acceptEncoding = req.Header.Get("Accept-Encoding")
+					return newResponse(t, te.body, te.contentEncoding), nil
+				}),
+				AdvertiseEncodings: true,
+				Policies:           policies,
+			}
+			req, _ := http.NewRequest("GET", te.url, nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+			if got, want := acceptEncoding, te.wantAcceptEncoding; got != want {
+				t.Errorf("Accept-Encoding got %v, want %v", got, want)
+			}
+		})
+	}
+}
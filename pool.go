@@ -0,0 +1,373 @@
+package decompress
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewPooledFactory returns the factory that pools the decoders created by f, so that the decoders are reused by Reset
+// for the new streams instead of allocating them per request. The decoder is returned to the pool when it is closed,
+// unless the decoding failed. The decoders of f must be reusable by Reset after Close, e.g. the gzip and flate readers
+// of compress/gzip and compress/flate. The built-in `gzip` and `deflate` decoders are pooled
+func NewPooledFactory(f DecoderFactory) DecoderFactory {
+	return &pooledFactory{factory: f}
+}
+
+type pooledFactory struct {
+	factory DecoderFactory
+	pool    sync.Pool
+}
+
+func (f *pooledFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	d := &pooledDecoder{}
+	if err := f.init(d, r); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// init sets the pooled decoder reset to r, or a new decoder if the pool is empty, to d
+func (f *pooledFactory) init(d *pooledDecoder, r io.Reader) error {
+	*d = pooledDecoder{factory: f}
+	if dec, ok := f.pool.Get().(Decoder); ok {
+		if err := dec.Reset(r); err == nil {
+			d.Decoder = dec
+			d.reused = true
+			return nil
+		}
+		// discard the decoder failed to reset, e.g. by the malformed header
+	}
+	dec, err := f.factory.NewDecoder(r)
+	if err != nil {
+		return err
+	}
+	d.Decoder = dec
+	return nil
+}
+
+// MemoryEstimate implements MemoryEstimator by the estimate of the underlying factory
+func (f *pooledFactory) MemoryEstimate() int64 {
+	return memoryEstimate("", f.factory)
+}
+
+// pooledDecoder returns the decoder to the pool of factory on Close
+type pooledDecoder struct {
+	Decoder
+	factory *pooledFactory
+	failed  bool
+	closed  bool
+	// reused reports whether the decoder is reused from the pool
+	reused bool
+}
+
+func (d *pooledDecoder) Read(p []byte) (int, error) {
+	if d.closed {
+		return 0, errDecoderClosed
+	}
+	// remains failed if the decoder panics
+	d.failed = true
+	n, err := d.Decoder.Read(p)
+	d.failed = err != nil && err != io.EOF
+	return n, err
+}
+
+func (d *pooledDecoder) WriteTo(w io.Writer) (int64, error) {
+	return d.writeToPool(w, defaultBufferPool)
+}
+
+func (d *pooledDecoder) writeToPool(w io.Writer, pool BufferPool) (int64, error) {
+	if d.closed {
+		return 0, errDecoderClosed
+	}
+	d.failed = true
+	n, err := writeTo(w, d.Decoder, pool)
+	d.failed = err != nil
+	return n, err
+}
+
+func (d *pooledDecoder) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if err := d.Decoder.Close(); err != nil || d.failed {
+		return err
+	}
+	// the decoder may be reused by another stream from now
+	d.factory.pool.Put(d.Decoder)
+	d.Decoder = nil
+	return nil
+}
+
+func (d *pooledDecoder) Reset(r io.Reader) error {
+	if d.closed {
+		return d.factory.init(d, r)
+	}
+	d.failed = false
+	return d.Decoder.Reset(r)
+}
+
+var errDecoderClosed = errors.New("decompress: read on closed decoder")
+
+// stdGzipFactories is the pooled factories of the built-in `gzip` decoders keyed by GzipOptions
+var stdGzipFactories sync.Map
+
+func stdGzipFactory(opts GzipOptions) DecoderFactory {
+	if f, ok := stdGzipFactories.Load(opts); ok {
+		return f.(DecoderFactory)
+	}
+	f, _ := stdGzipFactories.LoadOrStore(opts, NewPooledFactory(NewGzipFactory(opts, newStdGzipReader)))
+	return f.(DecoderFactory)
+}
+
+// defaultReadBufferSize is the size of the buffer between the network body and the decoder by default,
+// that is the same as the buffer of compress/gzip
+const defaultReadBufferSize = 4096
+
+// bodyState is the storage of the wrappers of a decompressed body, that is pooled so that RoundTrip does not allocate
+// them per request in the steady state
+type bodyState struct {
+	codings [DefaultMaxEncodings]string
+	layers  [DefaultMaxEncodings]decoderLayer
+	stages  [DefaultMaxEncodings]decodeStage
+	br      *bufio.Reader
+	out     *bufio.Reader
+}
+
+// decodeStage is the wrappers of a decoder layer
+type decodeStage struct {
+	lazy    lazyDecoder
+	rec     recoverReadCloser
+	cascade cascadeReadCloser
+	pd      pooledDecoder
+}
+
+var bodyStates = sync.Pool{
+	New: func() any {
+		return new(bodyState)
+	},
+}
+
+func getBodyState() *bodyState {
+	return bodyStates.Get().(*bodyState)
+}
+
+// stage returns the storage of the i-th decoder layer
+func (st *bodyState) stage(i int) *decodeStage {
+	if i < len(st.stages) {
+		return &st.stages[i]
+	}
+	return new(decodeStage)
+}
+
+// release drops the references to the response, and returns st to the pool
+func (st *bodyState) release() {
+	clear(st.codings[:])
+	clear(st.layers[:])
+	clear(st.stages[:])
+	for _, br := range []*bufio.Reader{st.br, st.out} {
+		if br != nil {
+			br.Reset(nil)
+		}
+	}
+	bodyStates.Put(st)
+}
+
+// sourceReader is the buffered reader of the source of a decoder. The source is used as is if it is *bufio.Reader,
+// otherwise it is wrapped by the reader owned by the decoder. The reader of the source is never reset by the decoder,
+// since it may be reused by another response after the decoder is closed and pooled, e.g. bodyState.br
+type sourceReader struct {
+	br  *bufio.Reader
+	own *bufio.Reader
+}
+
+// reset makes s read r, and returns the buffered reader of r
+func (s *sourceReader) reset(r io.Reader) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		s.br = br
+		return br
+	}
+	if s.own == nil {
+		s.own = bufio.NewReader(r)
+	} else {
+		s.own.Reset(r)
+	}
+	s.br = s.own
+	return s.br
+}
+
+// resetBufioReader resets br to read r, or returns a new reader if br is nil or its size is not size
+func resetBufioReader(br *bufio.Reader, r io.Reader, size int) *bufio.Reader {
+	if br == nil || br.Size() != max(size, 16) {
+		return bufio.NewReaderSize(r, size)
+	}
+	br.Reset(r)
+	return br
+}
+
+// decodedBody is the decompressed body returned by RoundTrip. Closing the body returns the state of the body to
+// the pool, unless a Read is in progress, so that the state is never shared by the responses even if the body is
+// closed concurrently to abort the Read
+type decodedBody struct {
+	rc     io.ReadCloser
+	st     *bodyState
+	pool   BufferPool
+	mu     sync.Mutex
+	closed atomic.Bool
+
+	// compressed counts the bytes read from the wire, and decompressed the bytes returned to the caller
+	compressed   countingReadCloser
+	decompressed atomic.Int64
+	stats        *stats
+	enc          *encodingCounters
+	failed       atomic.Bool
+	metrics      Metrics
+	encoding     string
+	start        time.Time
+	req          *http.Request
+	onError      func(req *http.Request, err error)
+
+	// the fields of DecodeTrace, guarded by mu except err
+	trace     *DecodeTrace
+	pipelined bool
+	gotFirst  bool
+	readTime  time.Duration
+	err       atomic.Pointer[error]
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed.Load() {
+		return 0, http.ErrBodyReadAfterClose
+	}
+	if b.trace != nil {
+		start := time.Now()
+		defer func() {
+			b.readTime += time.Since(start)
+		}()
+	}
+	n, err := b.rc.Read(p)
+	b.decompressed.Add(int64(n))
+	if err != nil && err != io.EOF {
+		b.fail(err)
+	}
+	if b.trace != nil {
+		b.gotBytes(n)
+	}
+	return n, err
+}
+
+// gotBytes reports the first decompressed bytes to the trace. b.mu must be held
+func (b *decodedBody) gotBytes(n int) {
+	if n > 0 && !b.gotFirst {
+		b.gotFirst = true
+		if b.trace.GotFirstDecompressedByte != nil {
+			b.trace.GotFirstDecompressedByte()
+		}
+	}
+}
+
+// WriteTo implements io.WriterTo, so that io.Copy of the body uses the decoder-internal copies if the decoder implements
+// io.WriterTo, otherwise a buffer of the BufferPool of the RoundTripper instead of allocating the intermediate buffer
+func (b *decodedBody) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed.Load() {
+		return 0, http.ErrBodyReadAfterClose
+	}
+	if b.trace != nil {
+		start := time.Now()
+		defer func() {
+			b.readTime += time.Since(start)
+		}()
+		if !b.gotFirst {
+			w = &traceWriter{Writer: w, b: b}
+		}
+	}
+	n, err := writeTo(w, b.rc, b.pool)
+	b.decompressed.Add(n)
+	if err != nil {
+		b.fail(err)
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	if b.closed.Swap(true) {
+		return nil
+	}
+	// unblocks the Read in progress
+	err := b.rc.Close()
+	compressed, decompressed := b.compressed.n.Load(), b.decompressed.Load()
+	b.stats.compressedBytes.Add(compressed)
+	b.stats.decompressedBytes.Add(decompressed)
+	b.enc.compressedBytes.Add(compressed)
+	b.enc.decompressedBytes.Add(decompressed)
+	if b.metrics != nil {
+		b.metrics.DecodeFinished(b.encoding, compressed, decompressed, time.Since(b.start))
+	}
+	if b.trace != nil && b.trace.DecodeDone != nil {
+		b.traceDone(compressed, decompressed)
+	}
+	if b.mu.TryLock() {
+		if b.st != nil {
+			b.st.release()
+			b.st = nil
+		}
+		b.rc = nil
+		b.mu.Unlock()
+	}
+	return err
+}
+
+// fail counts the error of the response once. The errors caused by Close are not counted
+func (b *decodedBody) fail(err error) {
+	if b.closed.Load() || b.failed.Swap(true) {
+		return
+	}
+	b.stats.errors.Add(1)
+	b.enc.errors.Add(1)
+	b.err.Store(&err)
+	if b.metrics != nil {
+		b.metrics.DecodeFailed(b.encoding, err)
+	}
+	if b.onError != nil {
+		b.onError(b.req, err)
+	}
+}
+
+// traceDone reports the end of the decoding to the trace
+func (b *decodedBody) traceDone(compressed, decompressed int64) {
+	info := DecodeDoneInfo{
+		Encoding:     b.encoding,
+		Compressed:   compressed,
+		Decompressed: decompressed,
+		Duration:     time.Since(b.start),
+		NetworkTime:  time.Duration(b.compressed.wait.Load()),
+	}
+	if err := b.err.Load(); err != nil {
+		info.Err = *err
+	}
+	// the Read in progress may be updating readTime
+	if !b.pipelined && b.mu.TryLock() {
+		info.DecodeTime = max(b.readTime-info.NetworkTime, 0)
+		b.mu.Unlock()
+	}
+	b.trace.DecodeDone(info)
+}
+
+// CompressedBytesRead implements ByteCounter
+func (b *decodedBody) CompressedBytesRead() int64 {
+	return b.compressed.n.Load()
+}
+
+// DecompressedBytesWritten implements ByteCounter
+func (b *decodedBody) DecompressedBytesWritten() int64 {
+	return b.decompressed.Load()
+}
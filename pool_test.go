@@ -0,0 +1,203 @@
+package decompress_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// countingFactory counts the decoders created
+type countingFactory struct {
+	decompress.DecoderFactory
+	created int
+}
+
+func (f *countingFactory) NewDecoder(r io.Reader) (decompress.Decoder, error) {
+	f.created++
+	return f.DecoderFactory.NewDecoder(r)
+}
+
+func TestNewPooledFactory(t *testing.T) {
+	newFactory := func() (*countingFactory, decompress.DecoderFactory) {
+		cf := &countingFactory{DecoderFactory: decompress.NewGzipFactory(decompress.GzipOptions{}, func(r io.Reader) (decompress.GzipReader, error) {
+			return gzip.NewReader(r)
+		})}
+		return cf, decompress.NewPooledFactory(cf)
+	}
+	decode := func(t *testing.T, f decompress.DecoderFactory, body []byte) (string, error) {
+		t.Helper()
+		d, err := f.NewDecoder(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		b, err := io.ReadAll(d)
+		return string(b), err
+	}
+	t.Run("reused", func(t *testing.T) {
+		cf, f := newFactory()
+		const n = 10
+		for i := 0; i < n; i++ {
+			got, err := decode(t, f, gzipBytes([]byte("foobarbaz")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		}
+		// sync.Pool may drop the decoders, e.g. by GC
+		if cf.created >= n {
+			t.Errorf("created got %v, want less than %v", cf.created, n)
+		}
+	})
+	t.Run("failed decoder is not pooled", func(t *testing.T) {
+		cf, f := newFactory()
+		corrupted := gzipBytes([]byte("foobarbaz"))
+		corrupted[len(corrupted)-8] ^= 0xff
+		if _, err := decode(t, f, corrupted); err == nil {
+			t.Fatal("got nil, want error")
+		}
+		got, err := decode(t, f, gzipBytes([]byte("foobarbaz")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "foobarbaz"; got != want {
+			t.Errorf("body got %v, want %v", got, want)
+		}
+		if got, want := cf.created, 2; got != want {
+			t.Errorf("created got %v, want %v", got, want)
+		}
+	})
+	t.Run("reset after close", func(t *testing.T) {
+		_, f := newFactory()
+		d, err := f.NewDecoder(bytes.NewReader(gzipBytes([]byte("foo"))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(d); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Read(make([]byte, 1)); err == nil {
+			t.Error("read on closed decoder got nil, want error")
+		}
+		if err := d.Reset(bytes.NewReader(gzipBytes([]byte("barbaz")))); err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		b, err := io.ReadAll(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "barbaz"; got != want {
+			t.Errorf("body got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestNewPooledFactory_ConcurrentBufioSources(t *testing.T) {
+	tt := []struct {
+		title    string
+		factory  decompress.DecoderFactory
+		compress func(b []byte) []byte
+	}{
+		{title: "deflate zlib", factory: decompress.NewPooledFactory(decompress.NewDeflateFactory(zlib.NewReader, flate.NewReader)), compress: zlibBytes},
+		{title: "deflate raw", factory: decompress.NewPooledFactory(decompress.NewDeflateFactory(zlib.NewReader, flate.NewReader)), compress: deflateBytes},
+		{title: "gzip flate", factory: decompress.NewPooledFactory(decompress.NewGzipFlateFactory(decompress.GzipOptions{}, flate.NewReader)), compress: gzipBytes},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					// the source is reused after the decoder is closed, like the pooled state of the bodies
+					var br *bufio.Reader
+					for n := 0; n < 100; n++ {
+						want := bytes.Repeat([]byte(fmt.Sprintf("%d-%d ", g, n)), 100)
+						if br == nil {
+							br = bufio.NewReader(bytes.NewReader(te.compress(want)))
+						} else {
+							br.Reset(bytes.NewReader(te.compress(want)))
+						}
+						d, err := te.factory.NewDecoder(br)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						got, err := io.ReadAll(d)
+						d.Close()
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if !bytes.Equal(got, want) {
+							t.Errorf("body got %.20q..., want %.20q...", got, want)
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_ConcurrentPooledDecoders(t *testing.T) {
+	tt := []struct {
+		title           string
+		dr              *decompress.RoundTripper
+		contentEncoding string
+		compress        func(b []byte) []byte
+	}{
+		{title: "deflate", dr: &decompress.RoundTripper{}, contentEncoding: "deflate", compress: zlibBytes},
+		{title: "gzip skip checksum", dr: &decompress.RoundTripper{Gzip: decompress.GzipOptions{SkipChecksum: true}}, contentEncoding: "gzip", compress: gzipBytes},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			te.dr.Wrap = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return newResponse(t, te.compress([]byte(req.URL.Path)), te.contentEncoding), nil
+			})
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for n := 0; n < 100; n++ {
+						path := fmt.Sprintf("/%d/%d", g, n)
+						req, _ := http.NewRequest("GET", path, nil)
+						resp, err := te.dr.RoundTrip(req)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						got, err := io.ReadAll(resp.Body)
+						resp.Body.Close()
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if string(got) != path {
+							t.Errorf("body got %q, want %q", got, path)
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+		})
+	}
+}
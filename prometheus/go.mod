@@ -0,0 +1,23 @@
+module github.com/kei2100/decompress-roundtripper/prometheus
+
+go 1.21
+
+require (
+	github.com/kei2100/decompress-roundtripper v0.0.0-20261015080837-280d162cd1c1
+	github.com/prometheus/client_golang v1.20.5
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
+
+// for the local development only. The modules depending on this module ignore it, and use the required version above
+replace github.com/kei2100/decompress-roundtripper => ../
@@ -0,0 +1,158 @@
+// Package prometheus provides the collector of the metrics of the decompression for Prometheus, using
+// github.com/prometheus/client_golang. The collector implements decompress.Metrics, so that it is wired in by
+// registering it and setting it to the RoundTripper:
+//
+//	c := prometheus.NewCollector(prometheus.Options{})
+//	registry.MustRegister(c)
+//	rt := decompress.New(decompress.WithMetrics(c))
+//
+// The metrics are labeled by the content codings, e.g. "gzip" or "deflate, gzip". The failures before the codings are
+// resolved are labeled by decompress.UnsupportedEncodingLabel or decompress.UnknownEncodingLabel.
+// The metrics of the decoders, that are the pool gets and the live decoders, are labeled by a single content coding
+package prometheus
+
+import (
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Options is the options for the collector
+type Options struct {
+	// Namespace is the prefix of the metric names. If empty, "decompress" is used
+	Namespace string
+	// ConstLabels is the labels added to all the metrics, e.g. the name of the client
+	ConstLabels prom.Labels
+	// RatioBuckets is the buckets of the histogram of the compression ratios. If nil, 1, 2, 4, ..., 1024 are used
+	RatioBuckets []float64
+	// DurationBuckets is the buckets of the histogram of the decoding durations in seconds.
+	// If nil, prometheus.DefBuckets are used
+	DurationBuckets []float64
+}
+
+// Collector collects the metrics of the decompression. It implements decompress.DecoderMetrics and prometheus.Collector
+type Collector struct {
+	responses    *prom.CounterVec
+	errors       *prom.CounterVec
+	compressed   *prom.CounterVec
+	decompressed *prom.CounterVec
+	ratio        *prom.HistogramVec
+	duration     *prom.HistogramVec
+	poolGets     *prom.CounterVec
+	liveDecoders *prom.GaugeVec
+}
+
+var _ decompress.DecoderMetrics = (*Collector)(nil)
+
+// NewCollector returns the Collector with the options
+func NewCollector(o Options) *Collector {
+	ns := o.Namespace
+	if ns == "" {
+		ns = "decompress"
+	}
+	ratioBuckets := o.RatioBuckets
+	if ratioBuckets == nil {
+		ratioBuckets = prom.ExponentialBuckets(1, 2, 11)
+	}
+	durationBuckets := o.DurationBuckets
+	if durationBuckets == nil {
+		durationBuckets = prom.DefBuckets
+	}
+	labels := []string{"encoding"}
+	return &Collector{
+		responses: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: ns, Name: "responses_total", ConstLabels: o.ConstLabels,
+			Help: "Number of the responses started to be decoded.",
+		}, labels),
+		errors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: ns, Name: "errors_total", ConstLabels: o.ConstLabels,
+			Help: "Number of the responses failed to be decoded.",
+		}, labels),
+		compressed: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: ns, Name: "compressed_bytes_total", ConstLabels: o.ConstLabels,
+			Help: "Bytes of the compressed bodies read from the wire.",
+		}, labels),
+		decompressed: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: ns, Name: "decompressed_bytes_total", ConstLabels: o.ConstLabels,
+			Help: "Bytes of the decompressed bodies produced.",
+		}, labels),
+		ratio: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: ns, Name: "compression_ratio", ConstLabels: o.ConstLabels,
+			Help:    "Ratio of the decompressed size to the compressed size of the bodies.",
+			Buckets: ratioBuckets,
+		}, labels),
+		duration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: ns, Name: "duration_seconds", ConstLabels: o.ConstLabels,
+			Help:    "Duration from the start of the decoding until the body is closed.",
+			Buckets: durationBuckets,
+		}, labels),
+		poolGets: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: ns, Name: "decoder_pool_gets_total", ConstLabels: o.ConstLabels,
+			Help: "Number of the decoders got from the pools, by the result of hit or miss.",
+		}, []string{"encoding", "result"}),
+		liveDecoders: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: ns, Name: "live_decoders", ConstLabels: o.ConstLabels,
+			Help: "Number of the decoders created and not closed yet.",
+		}, labels),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.responses.Describe(ch)
+	c.errors.Describe(ch)
+	c.compressed.Describe(ch)
+	c.decompressed.Describe(ch)
+	c.ratio.Describe(ch)
+	c.duration.Describe(ch)
+	c.poolGets.Describe(ch)
+	c.liveDecoders.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.responses.Collect(ch)
+	c.errors.Collect(ch)
+	c.compressed.Collect(ch)
+	c.decompressed.Collect(ch)
+	c.ratio.Collect(ch)
+	c.duration.Collect(ch)
+	c.poolGets.Collect(ch)
+	c.liveDecoders.Collect(ch)
+}
+
+// DecodeStarted implements decompress.Metrics
+func (c *Collector) DecodeStarted(encoding string) {
+	c.responses.WithLabelValues(encoding).Inc()
+}
+
+// DecodeFinished implements decompress.Metrics. The ratio is observed only if the compressed body is read
+func (c *Collector) DecodeFinished(encoding string, compressed, decompressed int64, d time.Duration) {
+	c.compressed.WithLabelValues(encoding).Add(float64(compressed))
+	c.decompressed.WithLabelValues(encoding).Add(float64(decompressed))
+	if compressed > 0 {
+		c.ratio.WithLabelValues(encoding).Observe(float64(decompressed) / float64(compressed))
+	}
+	c.duration.WithLabelValues(encoding).Observe(d.Seconds())
+}
+
+// DecodeFailed implements decompress.Metrics
+func (c *Collector) DecodeFailed(encoding string, _ error) {
+	c.errors.WithLabelValues(encoding).Inc()
+}
+
+// DecoderCreated implements decompress.DecoderMetrics
+func (c *Collector) DecoderCreated(encoding string, pooled, reused bool) {
+	c.liveDecoders.WithLabelValues(encoding).Inc()
+	if reused {
+		c.poolGets.WithLabelValues(encoding, "hit").Inc()
+	} else if pooled {
+		c.poolGets.WithLabelValues(encoding, "miss").Inc()
+	}
+}
+
+// DecoderClosed implements decompress.DecoderMetrics
+func (c *Collector) DecoderClosed(encoding string) {
+	c.liveDecoders.WithLabelValues(encoding).Dec()
+}
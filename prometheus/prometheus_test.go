@@ -0,0 +1,108 @@
+package prometheus_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+	dprometheus "github.com/kei2100/decompress-roundtripper/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := dprometheus.NewCollector(dprometheus.Options{ConstLabels: prometheus.Labels{"client": "test"}})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	body := gzipBytes(bytes.Repeat([]byte("foobarbaz"), 100))
+	responses := map[string][]byte{"gzip": body, "x-unknown": []byte("foobarbaz")}
+	dr := decompress.New(
+		decompress.WithMetrics(c),
+		decompress.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ce := req.URL.Query().Get("ce")
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Encoding": {ce}},
+				Body:       io.NopCloser(bytes.NewReader(responses[ce])),
+			}, nil
+		})),
+	)
+	for _, ce := range []string{"gzip", "gzip", "x-unknown"} {
+		req, _ := http.NewRequest("GET", "/?ce="+ce, nil)
+		resp, err := dr.RoundTrip(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	want := `
+# HELP decompress_compressed_bytes_total Bytes of the compressed bodies read from the wire.
+# TYPE decompress_compressed_bytes_total counter
+decompress_compressed_bytes_total{client="test",encoding="gzip"} ` + strconv.Itoa(2*len(body)) + `
+# HELP decompress_decompressed_bytes_total Bytes of the decompressed bodies produced.
+# TYPE decompress_decompressed_bytes_total counter
+decompress_decompressed_bytes_total{client="test",encoding="gzip"} 1800
+# HELP decompress_errors_total Number of the responses failed to be decoded.
+# TYPE decompress_errors_total counter
+decompress_errors_total{client="test",encoding="unsupported"} 1
+# HELP decompress_responses_total Number of the responses started to be decoded.
+# TYPE decompress_responses_total counter
+decompress_responses_total{client="test",encoding="gzip"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"decompress_compressed_bytes_total", "decompress_decompressed_bytes_total",
+		"decompress_errors_total", "decompress_responses_total"); err != nil {
+		t.Error(err)
+	}
+	if got, want := testutil.CollectAndCount(c, "decompress_compression_ratio", "decompress_duration_seconds"), 2; got != want {
+		t.Errorf("histograms got %v, want %v", got, want)
+	}
+	wantLive := `
+# HELP decompress_live_decoders Number of the decoders created and not closed yet.
+# TYPE decompress_live_decoders gauge
+decompress_live_decoders{client="test",encoding="gzip"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(wantLive), "decompress_live_decoders"); err != nil {
+		t.Error(err)
+	}
+	// the hits depend on sync.Pool
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gets float64
+	for _, mf := range families {
+		if mf.GetName() == "decompress_decoder_pool_gets_total" {
+			for _, m := range mf.GetMetric() {
+				gets += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if got, want := gets, 2.0; got != want {
+		t.Errorf("pool gets got %v, want %v", got, want)
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func gzipBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := gzip.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
@@ -0,0 +1,93 @@
+package decompress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Quota is the budget of the decompressed bytes, that can be shared by the RoundTrippers, so that e.g. a run of
+// a batch crawler can not exceed the memory or disk budget.
+// The bytes of all the in-flight and past responses in the window are counted against the quota. Quota is safe for concurrent use
+type Quota struct {
+	limit  int64
+	window time.Duration
+
+	mu    sync.Mutex
+	used  int64
+	start time.Time
+}
+
+// NewQuota returns the Quota of limit bytes per window. If window is 0, the quota is never reset
+func NewQuota(limit int64, window time.Duration) *Quota {
+	return &Quota{limit: limit, window: window, start: time.Now()}
+}
+
+// Used returns the decompressed bytes counted in the current window
+func (q *Quota) Used() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reset()
+	return q.used
+}
+
+// take counts n bytes against the quota, and returns the bytes within the quota
+func (q *Quota) take(n int64) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reset()
+	n = min(n, q.limit-q.used)
+	q.used += n
+	return n
+}
+
+// exhausted reports whether no bytes remain in the quota
+func (q *Quota) exhausted() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reset()
+	return q.used >= q.limit
+}
+
+// reset starts the new window if the current window has passed. q.mu must be held
+func (q *Quota) reset() {
+	if q.window > 0 && time.Since(q.start) >= q.window {
+		q.used = 0
+		q.start = time.Now()
+	}
+}
+
+// ErrQuotaExceeded represents the error that the decompressed bytes exceed the Quota
+type ErrQuotaExceeded struct {
+	Limit int64
+}
+
+// Error implements the error interface
+func (e *ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("decompress: decompressed bytes exceed the quota %d bytes", e.Limit)
+}
+
+// quotaReadCloser counts the bytes read from rc against q, and returns ErrQuotaExceeded and closes rc when the quota is exhausted
+type quotaReadCloser struct {
+	rc  io.ReadCloser
+	q   *Quota
+	err error
+}
+
+func (l *quotaReadCloser) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.rc.Read(p)
+	if m := l.q.take(int64(n)); m < int64(n) {
+		l.err = &ErrQuotaExceeded{Limit: l.q.limit}
+		l.rc.Close()
+		return int(m), l.err
+	}
+	return n, err
+}
+
+func (l *quotaReadCloser) Close() error {
+	return l.rc.Close()
+}
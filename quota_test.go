@@ -0,0 +1,70 @@
+package decompress_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestQuota(t *testing.T) {
+	roundTrip := func(t *testing.T, q *decompress.Quota) ([]byte, error) {
+		t.Helper()
+		dr := decompress.RoundTripper{
+			Wrap:  &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")},
+			Quota: q,
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp, err := dr.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+	t.Run("shared", func(t *testing.T) {
+		q := decompress.NewQuota(20, 0)
+		for i := 0; i < 2; i++ {
+			b, err := roundTrip(t, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		}
+		if got, want := q.Used(), int64(18); got != want {
+			t.Errorf("Used got %v, want %v", got, want)
+		}
+		// exceeds while reading
+		b, err := roundTrip(t, q)
+		var wantErr *decompress.ErrQuotaExceeded
+		if !errors.As(err, &wantErr) {
+			t.Fatalf("got %T %v, want ErrQuotaExceeded", err, err)
+		}
+		if got, want := string(b), "fo"; got != want {
+			t.Errorf("body got %v, want %v", got, want)
+		}
+		// refused
+		if _, err := roundTrip(t, q); !errors.As(err, &wantErr) {
+			t.Fatalf("got %T %v, want ErrQuotaExceeded", err, err)
+		}
+	})
+	t.Run("window", func(t *testing.T) {
+		q := decompress.NewQuota(9, 50*time.Millisecond)
+		if _, err := roundTrip(t, q); err != nil {
+			t.Fatal(err)
+		}
+		var wantErr *decompress.ErrQuotaExceeded
+		if _, err := roundTrip(t, q); !errors.As(err, &wantErr) {
+			t.Fatalf("got %T %v, want ErrQuotaExceeded", err, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if _, err := roundTrip(t, q); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package decompress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// NewReader returns the reader that decodes r according to encoding, so that the decoding logic of the RoundTripper,
+// including the registered decoders, can be reused for the streams other than HTTP (e.g. message queue payloads).
+// encoding is a content coding or a list of the content codings in the order they were applied, e.g. `deflate, gzip`,
+// that is interpreted the same way as ParseContentEncoding.
+// If an encoding is unsupported, ErrUnsupportedEncoding is returned.
+// Closing the returned reader releases the resources of the decoders, but does not close r
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	var rt RoundTripper
+	return rt.newReader(context.Background(), ParseContentEncoding(encoding), encoding, r)
+}
+
+// newReader returns the reader that decodes r according to the content codings
+func (r *RoundTripper) newReader(ctx context.Context, codings []string, encoding string, src io.Reader) (io.ReadCloser, error) {
+	if limit := r.maxEncodings(); limit > 0 && len(codings) > limit {
+		return nil, &ErrTooManyEncodings{Encoding: encoding, Limit: limit}
+	}
+	factories := make([]DecoderFactory, len(codings))
+	for i, coding := range codings {
+		f, ok := r.decoder(ctx, coding, nil)
+		if !ok {
+			return nil, &ErrUnsupportedEncoding{Encoding: encoding}
+		}
+		factories[i] = f
+	}
+	var rc io.ReadCloser = io.NopCloser(src)
+	for i := len(codings) - 1; i >= 0; i-- {
+		d, err := newDecoder(factories[i], codings[i], rc)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("decompress: create %s reader: %w", codings[i], err)
+		}
+		rc = &cascadeReadCloser{readFrom: &recoverReadCloser{rc: d, encoding: codings[i]}, cascade: rc}
+	}
+	return rc, nil
+}
+
+// Bytes decodes data according to contentEncoding, that is the value of the Content-Encoding header,
+// the same way as NewReader. It is useful for the stored or cached compressed payloads
+func Bytes(data []byte, contentEncoding string) ([]byte, error) {
+	r, err := NewReader(contentEncoding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,91 @@
+package decompress_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestNewReader(t *testing.T) {
+	tt := []struct {
+		title                      string
+		encoding                   string
+		data                       []byte
+		want                       string
+		wantErr                    bool
+		wantErrUnsupportedEncoding bool
+	}{
+		{title: "gzip", encoding: "gzip", data: gzipBytes([]byte("foobarbaz")), want: "foobarbaz"},
+		{title: "chain", encoding: "deflate, gzip", data: gzipBytes(deflateBytes([]byte("foobarbaz"))), want: "foobarbaz"},
+		{title: "registered", encoding: "x-rot13", data: rot13([]byte("foobarbaz")), want: "foobarbaz"},
+		{title: "subpackage", encoding: "br", data: brotliBytes([]byte("foobarbaz")), want: "foobarbaz"},
+		{title: "identity", encoding: "identity", data: []byte("foobarbaz"), want: "foobarbaz"},
+		{title: "invalid header", encoding: "gzip", data: []byte("foobarbaz"), wantErr: true},
+		{title: "unsupported", encoding: "gzip, x-unknown", data: []byte("foobarbaz"), wantErrUnsupportedEncoding: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			r, err := decompress.NewReader(te.encoding, bytes.NewReader(te.data))
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBytes(t *testing.T) {
+	tt := []struct {
+		title           string
+		contentEncoding string
+		data            []byte
+		want            string
+		wantErr         bool
+	}{
+		{title: "chain", contentEncoding: "deflate, gzip", data: gzipBytes(deflateBytes([]byte("foobarbaz"))), want: "foobarbaz"},
+		{title: "empty encoding", contentEncoding: "", data: []byte("foobarbaz"), want: "foobarbaz"},
+		{title: "truncated", contentEncoding: "gzip", data: gzipBytes([]byte("foobarbaz"))[:20], wantErr: true},
+		{title: "unsupported", contentEncoding: "x-unknown", data: []byte("foobarbaz"), wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			b, err := decompress.Bytes(te.data, te.contentEncoding)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package decompress
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// ErrDecoderPanic represents the error that a decoder panicked, e.g. by the malformed input to the third-party libraries.
+// The panic is recovered so that it does not crash the process
+type ErrDecoderPanic struct {
+	Encoding string
+	// Value is the value passed to panic
+	Value any
+	// Stack is the stack trace of the goroutine at the panic
+	Stack []byte
+}
+
+// Error implements the error interface
+func (e *ErrDecoderPanic) Error() string {
+	return fmt.Sprintf("decompress: %s decoder panic: %v", e.Encoding, e.Value)
+}
+
+// Is reports whether target is ErrCorrupted, since the decoders panic mostly by the malformed input
+func (e *ErrDecoderPanic) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// recoverReadCloser converts the panics of Read and Close of rc into ErrDecoderPanic.
+// Since the state of rc is unknown after the panic, the subsequent Reads return the same error
+type recoverReadCloser struct {
+	rc       io.ReadCloser
+	encoding string
+	err      error
+}
+
+func (r *recoverReadCloser) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	defer r.recoverPanic(&err)
+	return r.rc.Read(p)
+}
+
+func (r *recoverReadCloser) WriteTo(w io.Writer) (int64, error) {
+	return r.writeToPool(w, defaultBufferPool)
+}
+
+func (r *recoverReadCloser) writeToPool(w io.Writer, pool BufferPool) (n int64, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	defer r.recoverPanic(&err)
+	return writeTo(w, r.rc, pool)
+}
+
+func (r *recoverReadCloser) Close() (err error) {
+	defer r.recoverPanic(&err)
+	return r.rc.Close()
+}
+
+// recoverPanic must be deferred directly
+func (r *recoverReadCloser) recoverPanic(err *error) {
+	if v := recover(); v != nil {
+		r.err = &ErrDecoderPanic{Encoding: r.encoding, Value: v, Stack: debug.Stack()}
+		*err = r.err
+	}
+}
+
+// newDecoder calls f.NewDecoder, and converts the panic into ErrDecoderPanic
+func newDecoder(f DecoderFactory, encoding string, r io.Reader) (d Decoder, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &ErrDecoderPanic{Encoding: encoding, Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return f.NewDecoder(r)
+}
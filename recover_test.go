@@ -0,0 +1,86 @@
+package decompress_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+type panicReadCloser struct {
+	onRead  bool
+	onClose bool
+}
+
+func (p *panicReadCloser) Read([]byte) (int, error) {
+	if p.onRead {
+		panic("malformed input")
+	}
+	return 0, io.EOF
+}
+
+func (p *panicReadCloser) Close() error {
+	if p.onClose {
+		panic("malformed input")
+	}
+	return nil
+}
+
+func TestRoundTripper_RoundTrip_DecoderPanic(t *testing.T) {
+	tt := []struct {
+		title        string
+		fn           decompress.DecoderFunc
+		wantReadErr  bool
+		wantCloseErr bool
+	}{
+		{
+			title:       "new decoder",
+			fn:          func(io.Reader) (io.ReadCloser, error) { panic("malformed input") },
+			wantReadErr: true,
+		},
+		{
+			title:       "read",
+			fn:          func(io.Reader) (io.ReadCloser, error) { return &panicReadCloser{onRead: true}, nil },
+			wantReadErr: true,
+		},
+		{
+			title:        "close",
+			fn:           func(io.Reader) (io.ReadCloser, error) { return &panicReadCloser{onClose: true}, nil },
+			wantCloseErr: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:     &stubRoundTripper{response: newResponse(t, []byte("foobarbaz"), "x-panic")},
+				Decoders: map[string]decompress.DecoderFactory{"x-panic": te.fn},
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wantErr *decompress.ErrDecoderPanic
+			_, err = io.ReadAll(resp.Body)
+			if got, want := errors.As(err, &wantErr), te.wantReadErr; got != want {
+				t.Errorf("read error got %T %v, want ErrDecoderPanic %v", err, err, want)
+			}
+			if te.wantReadErr {
+				if got, want := wantErr.Encoding, "x-panic"; got != want {
+					t.Errorf("Encoding got %v, want %v", got, want)
+				}
+				// the error is sticky
+				if _, err := resp.Body.Read(make([]byte, 1)); !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrDecoderPanic", err, err)
+				}
+			}
+			err = resp.Body.Close()
+			if got, want := errors.As(err, &wantErr), te.wantCloseErr; got != want {
+				t.Errorf("close error got %T %v, want ErrDecoderPanic %v", err, err, want)
+			}
+		})
+	}
+}
@@ -1,14 +1,17 @@
 package decompress
 
 import (
-	"compress/flate"
-	"compress/gzip"
+	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
-
-	"github.com/andybalholm/brotli"
+	"sync/atomic"
+	"time"
 )
 
 // RoundTripper is an implementation of the http.RoundTripper, that automatically decompresses the response body
@@ -16,62 +19,526 @@ import (
 type RoundTripper struct {
 	// Wrap is the actual RoundTripper. If Wrap is nil, http.DefaultTransport will be used
 	Wrap http.RoundTripper
+	// Decoders is the decoders keyed by the content coding name, that extend or override the decoders registered by
+	// RegisterDecoder and the built-in decoders for this RoundTripper
+	Decoders map[string]DecoderFactory
+	// Aliases is the aliases of the content coding names for this RoundTripper, that map e.g. `x-zstd` to `zstd`.
+	// Aliases takes precedence over the aliases registered by RegisterAlias
+	Aliases map[string]string
+	// Encodings restricts the content codings decoded by this RoundTripper, that are matched after resolving the aliases.
+	// The other codings are treated as unsupported encodings. If Encodings is nil, all the supported codings are decoded
+	Encodings []string
+	// Gzip is the options for the gzip decoder
+	Gzip GzipOptions
+	// KeyProvider returns the input keying material for the key ID of the `aes128gcm` content coding (RFC 8188).
+	// If KeyProvider is nil, `aes128gcm` is treated as an unsupported encoding
+	KeyProvider func(keyID []byte) ([]byte, error)
+	// DeltaBase returns the base representation that the `vcdiff` delta encoding (RFC 3284) of the response is applied to.
+	// It is typically the cached entity identified by the Delta-Base header of the response (RFC 3229).
+	// If DeltaBase is nil, `vcdiff` is treated as an unsupported encoding
+	DeltaBase func(res *http.Response) ([]byte, error)
+	// Preferences is the preferences of the content codings for this RoundTripper, that are the qvalues in the
+	// Accept-Encoding header returned by AcceptEncoding. Preferences takes precedence over the preferences set by SetPreference.
+	// The preferences are rounded to 3 decimal places, and the codings with a preference not in the range of 0 to 1
+	// are not advertised
+	Preferences map[string]float64
+	// AdvertiseEncodings makes RoundTrip set the Accept-Encoding header returned by AcceptEncoding to the requests
+	// without the header
+	AdvertiseEncodings bool
+	// LenientParsing makes RoundTrip tolerate the parameters of the content codings sent by broken servers,
+	// such as `gzip;q=1.0` or `gzip; charset=utf-8`. The parameters after `;` are stripped before matching the content coding.
+	// By default, such values are treated as unsupported encodings
+	LenientParsing bool
+	// Base64 enables the non-standard `base64` content coding, that some APIs use to encode the response body.
+	// It is disabled by default, and `base64` is treated as an unsupported encoding
+	Base64 bool
+	// CloneResponse makes RoundTrip return a shallow copy of the response with a copy of the header, instead of modifying
+	// the response returned by Wrap, so that the other wrappers keeping a reference to the original response are not affected
+	CloneResponse bool
+	// PreserveOriginalHeaders makes RoundTrip copy the removed Content-Encoding and Content-Length headers into
+	// X-Original-Content-Encoding and X-Original-Content-Length, so that the logging or debugging middlewares can see
+	// what the server actually sent
+	PreserveOriginalHeaders bool
+	// AnnotationHeader is the name of the response header, e.g. `X-Decompressed-By`, that is set to the decoded content
+	// codings in the order applied, like `decompress-roundtripper (gzip,br)`, so that the downstream middlewares and
+	// handlers can tell the body was already decoded and by which chain.
+	// If AnnotationHeader is empty, no header is set
+	AnnotationHeader string
+	// PassThroughUnsupported makes RoundTrip return the untouched response, with the original body and headers,
+	// instead of ErrUnsupportedEncoding when the response has an unsupported content coding
+	PassThroughUnsupported bool
+	// PartialDecoding makes RoundTrip decode the supported content codings applied after an unsupported one, e.g. `gzip`
+	// of `Content-Encoding: custom, gzip`, and return the response with the Content-Encoding header rewritten to
+	// the remaining codings, instead of ErrUnsupportedEncoding.
+	// If the last applied coding is unsupported, the response is handled as if PartialDecoding is false
+	PartialDecoding bool
+	// DisableHeader is the name of the request header, e.g. `X-Decompress`, that disables the decompression of the request
+	// when its value is `off`, for the callers that can not use Disable (e.g. generated clients).
+	// The header is removed from the request before sending it regardless of its value.
+	// If DisableHeader is empty, no header is used
+	DisableHeader string
+	// Policies is the settings for the requests matching the host and the path patterns.
+	// The first matching policy is applied to the request
+	Policies []Policy
+	// SkipContentTypes is the media types of the responses not to be decompressed, e.g. `application/zip`.
+	// A media type of the form `type/*` matches all the subtypes. The matched responses are returned as is
+	SkipContentTypes []string
+	// StatusFilter reports whether the responses of the status code are decompressed, e.g. only 2xx, so that the error
+	// bodies and the redirects can be passed through as is. If StatusFilter is nil, the responses of all the status codes are decompressed
+	StatusFilter func(code int) bool
+	// Methods restricts the decompression to the responses of the request methods, e.g. GET and POST.
+	// The requests of the other methods pass through as is. If Methods is nil, the responses of all the methods are decompressed
+	Methods []string
+	// StrictAdvertised makes RoundTrip decompress only the content codings advertised by the RoundTripper itself with
+	// AdvertiseEncodings. The unsolicited codings are handled as the unsupported codings, that is, ErrUnsupportedEncoding
+	// is returned unless PassThroughUnsupported or PartialDecoding is set. It helps debugging the misbehaving servers
+	StrictAdvertised bool
+	// BufferLimit makes RoundTrip read the whole decompressed body into memory, and set ContentLength and
+	// the Content-Length header to the decompressed size, for the code depending on the length (e.g. progress bars).
+	// If the decompressed body exceeds BufferLimit bytes, RoundTrip returns ErrTooLarge.
+	// If BufferLimit is 0, the body is decompressed while reading it
+	BufferLimit int64
+	// SpillThreshold makes RoundTrip buffer the whole decompressed body like BufferLimit, but the body exceeding
+	// SpillThreshold bytes is buffered in a temporary file of SpillDir instead of memory. The size of the body is limited
+	// by BufferLimit if it is set. The buffered body implements io.Seeker and io.ReaderAt, for the consumers requiring
+	// the random access, e.g. archive/zip. The temporary file is removed when the body is closed
+	SpillThreshold int64
+	// SpillDir is the directory of the temporary files of SpillThreshold. If SpillDir is empty, os.TempDir is used
+	SpillDir string
+	// AdaptiveBufferLimit makes RoundTrip buffer the decompressed body of up to AdaptiveBufferLimit bytes, and set
+	// ContentLength and the Content-Length header like BufferLimit. Unlike BufferLimit, the larger body is not an error,
+	// but is streamed following the buffered data, and its ContentLength remains -1.
+	// It is ignored if BufferLimit or SpillThreshold is set
+	AdaptiveBufferLimit int64
+	// MaxDecompressedBytes limits the size of the decompressed body, to protect against the decompression bombs.
+	// When the decompressed body exceeds MaxDecompressedBytes bytes, Read of the body returns ErrTooLarge and
+	// the underlying body is closed, that closes the connection. If MaxDecompressedBytes is 0, the size is not limited
+	MaxDecompressedBytes int64
+	// MaxCompressionRatio limits the ratio of the decompressed size to the compressed size, to detect the decompression
+	// bombs earlier than MaxDecompressedBytes. When the ratio exceeds MaxCompressionRatio after reading
+	// CompressionRatioMinBytes decompressed bytes, Read of the body returns ErrRatioExceeded and the underlying body is
+	// closed. If MaxCompressionRatio is 0, the ratio is not limited
+	MaxCompressionRatio float64
+	// CompressionRatioMinBytes is the decompressed bytes read before checking MaxCompressionRatio, so that the small
+	// but highly compressible bodies are allowed
+	CompressionRatioMinBytes int64
+	// MaxEncodings limits the number of the chained content codings, to protect against the hostile servers stacking
+	// the decoders, e.g. `gzip, gzip, gzip, ...`. If the response has more codings, RoundTrip closes the body and
+	// returns ErrTooManyEncodings. If MaxEncodings is 0, DefaultMaxEncodings is used, and if it is negative,
+	// the number is not limited
+	MaxEncodings int
+	// Quota is the budget of the decompressed bytes, that can be shared by the RoundTrippers.
+	// When the quota is exhausted, RoundTrip closes the body of the compressed responses and returns ErrQuotaExceeded,
+	// and Read of the body being decompressed returns ErrQuotaExceeded. If Quota is nil, the bytes are not limited
+	Quota *Quota
+	// ReadTimeout limits the time of each Read of the decompressed body, so that the servers trickling the compressed
+	// bytes can not pin the goroutine forever. When a Read does not complete in ReadTimeout, the underlying body is
+	// closed and the Read returns ErrReadTimeout. If ReadTimeout is 0, the time is not limited
+	ReadTimeout time.Duration
+	// MemoryBudget is the budget of the memory held by the decoders, that can be shared by the RoundTrippers.
+	// If MemoryBudget is nil, the memory is not limited
+	MemoryBudget *MemoryBudget
+	// VerifyDigest makes the decompressed body verify the digest of the Repr-Digest header (RFC 9530), or the legacy
+	// Digest header if the response does not have Repr-Digest. The digest is computed while reading, and the final Read
+	// returns ErrDigestMismatch on the mismatch. SHA-256 and SHA-512 are supported, and the other algorithms are ignored
+	VerifyDigest bool
+	// VerifyContentLength makes the decompressed body compare the size of the compressed stream read from the wire with
+	// the Content-Length of the response, to detect the truncation hidden by e.g. the proxies re-chunking the body.
+	// When the compressed stream ends short of or beyond the Content-Length, Read of the body returns ErrLengthMismatch.
+	// The responses without the Content-Length are not verified
+	VerifyContentLength bool
+	// DrainOnClose is the maximum bytes of the remaining compressed stream discarded when the decompressed body is
+	// closed before EOF, so that the connection can be reused for the keep-alive. The remaining bytes are known by
+	// the Content-Length of the response. The bodies with more remaining bytes or without the Content-Length are
+	// closed as is without reading, that closes the connection. If DrainOnClose is 0, the body is not drained
+	DrainOnClose int64
+	// ReadBufferSize is the size of the bufio.Reader wrapping the network body before the decoder, that the decoders
+	// such as gzip and deflate read through instead of their own buffer, so that the small reads of the decoders do not
+	// hit the transport each time. Small buffers suit many small API calls, and large buffers suit the bulk downloads.
+	// The readers are pooled. If ReadBufferSize is 0, 4096 bytes is used
+	ReadBufferSize int
+	// OutputBufferSize is the size of the buffer of the decompressed body, that serves the small Reads of the callers
+	// without calling the decoder each time. If OutputBufferSize is 0, the decompressed body is not buffered
+	OutputBufferSize int
+	// Limiter limits the number of the responses being decompressed at once. If Limiter is nil, the number is not limited
+	Limiter *Limiter
+	// BufferPool is the pool of the buffers used for copying and buffering the bodies, e.g. by BufferLimit.
+	// If BufferPool is nil, the default pool shared by the package is used
+	BufferPool BufferPool
+	// PipelineDepth enables the pipelined decompression, that decodes the body in a background goroutine up to
+	// PipelineDepth chunks of up to 32 KiB ahead of the consumer, so that the network reads and the decoding overlap.
+	// It hides the latency of the decoders such as zstd and brotli for the large downloads, at the cost of a goroutine
+	// and the buffers per response. If PipelineDepth is 0, the body is decoded in Read of the consumer
+	PipelineDepth int
+	// LowLatency guarantees that the decoded bytes are delivered to Read as soon as the flushed data of the server
+	// is received, for the streaming APIs. It bypasses the buffering by BufferLimit, SpillThreshold and
+	// AdaptiveBufferLimit, that waits for the whole body. The responses of text/event-stream are always handled
+	// as if LowLatency is set. Note that the decoders reading ahead blocks, such as the pgzip subpackage, still delay
+	// the delivery
+	LowLatency bool
+	// Metrics receives the events of the decompression, to export the metrics. If Metrics is nil, no events are sent.
+	// The counters are also available by Stats regardless of Metrics. See also ContextMetrics
+	Metrics Metrics
+	// Logger logs the decisions of the RoundTripper, e.g. the pass-through of the unsupported encodings and
+	// the partial decoding, and the decode errors with the structured attributes such as the host, the encodings and
+	// the sizes. It is useful to diagnose the misconfigured servers e.g. in staging. The decisions and the decoded
+	// responses are logged at the debug or info level, and the errors at the warn level. If Logger is nil, nothing is logged
+	Logger *slog.Logger
+	// Trace is the hooks run at the stages of the decompression of the responses, e.g. to separate the network time
+	// from the decode time. See also WithDecodeTrace for the hooks per request
+	Trace *DecodeTrace
+	// OnDecode is called with the request, the response and the decoded content codings in the order of
+	// the Content-Encoding header, when RoundTrip starts decoding the response, e.g. for the lightweight auditing
+	// without Metrics. The response must not be read by OnDecode. If OnDecode is nil, it is not called
+	OnDecode func(req *http.Request, res *http.Response, encodings []string)
+	// OnError is called once per response, when RoundTrip or reading the body fails to decode the response.
+	// It may be called concurrently by the responses. The errors caused by closing the body are not reported.
+	// If OnError is nil, it is not called
+	OnError func(req *http.Request, err error)
+	// TeeCompressed returns the writer, that the compressed stream of res is copied to as read from the wire, e.g. a file
+	// or a ring buffer to capture the exact bytes sent by the server for the offline analysis of the decode errors.
+	// If the writer implements io.Closer, it is closed when the body is closed. The errors of the writer are ignored
+	// not to affect the decoding, and the writer is not written after an error. If TeeCompressed is nil or returns nil,
+	// the stream is not copied
+	TeeCompressed func(res *http.Response) io.Writer
+
+	stats atomic.Value // *stats, see Stats
+}
+
+// DefaultMaxEncodings is the default limit of the number of the chained content codings. See RoundTripper.MaxEncodings
+const DefaultMaxEncodings = 5
+
+// GzipOptions is the options for the gzip decoder
+type GzipOptions struct {
+	// DisableMultistream makes the decoder stop at the end of the first gzip member.
+	// By default, a body consisting of concatenated gzip members is decoded as a single stream
+	DisableMultistream bool
+	// Strict makes the decoder verify the CRC32 and ISIZE fields of each gzip member by itself at the end of the member,
+	// and report the mismatch as ErrChecksum, so that the data corrupted by proxies or CDNs are detected regardless of
+	// the implementation of the gzip reader. It costs an additional CRC32 computation
+	Strict bool
+	// SkipChecksum makes the decoder skip the verification of the CRC32 and ISIZE fields, to save the CPU e.g. for the
+	// trusted internal traffic in the high-throughput proxies. The corrupted data are NOT detected, so leave it off
+	// for the untrusted origins. SkipChecksum is ignored if Strict is set. See NewGzipFlateFactory
+	SkipChecksum bool
 }
 
 // RoundTrip implements the RoundTrip method of the http.RoundTripper.
 // If the response body is compressed, decompress it according to the Content-Encoding header before returning it.
 // Supported Content-Encoding is:
-//   - gzip
-//   - deflate
-//   - br
+//   - gzip (x-gzip)
+//   - deflate (both zlib-wrapped and raw)
+//   - bzip2
+//   - compress (x-compress)
+//   - aes128gcm (requires the KeyProvider field)
+//   - vcdiff (requires the DeltaBase field)
+//   - base64 (requires the Base64 field)
 //   - identity
+//
+// The aliases of the Aliases field and the aliases registered by RegisterAlias are resolved before decoding.
+// In addition, the decoders of the Decoders field, the decoders registered by RegisterDecoder and the decoders of
+// the request context set by WithDecoders are supported.
+// The decoders depending on third-party libraries are provided by the subpackages, that register themselves when imported:
+//
+//	import _ "github.com/kei2100/decompress-roundtripper/brotli" // br
+//	import _ "github.com/kei2100/decompress-roundtripper/zstd"   // zstd
+//	import _ "github.com/kei2100/decompress-roundtripper/xz"     // xz, lzma
+//	import _ "github.com/kei2100/decompress-roundtripper/lz4"    // lz4
+//	import _ "github.com/kei2100/decompress-roundtripper/snappy" // snappy
+//
+// The `dcz` content coding of the Compression Dictionary Transport (RFC 9842) is provided by zstd.NewDCZFactory.
+// The `dcb` content coding is not supported, since the brotli decoders can not decode with a dictionary.
 // If an unsupported value is set, ErrUnsupportedEncoding will be returned. You can retrieve the original http.Response from ErrUnsupportedEncoding.
+// The decoders are created at the first Read of the body, so the errors of the invalid stream headers are returned by Read,
+// and an empty body yields io.EOF.
 func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	w := r.Wrap
+	return r.roundTrip(r.Wrap, req)
+}
+
+// roundTrip sends req by w, and decompresses the response. If w is nil, http.DefaultTransport will be used
+func (r *RoundTripper) roundTrip(w http.RoundTripper, req *http.Request) (*http.Response, error) {
 	if w == nil {
 		w = http.DefaultTransport
 	}
+	req, off := r.stripDisableHeader(req)
+	if off || disabled(req.Context()) || !r.allowedMethod(req) {
+		return w.RoundTrip(req)
+	}
+	p := r.policy(req)
+	if p != nil && p.Disabled {
+		return w.RoundTrip(req)
+	}
+	ctx := withPolicy(req.Context(), p)
+	var advertised []string
+	if r.AdvertiseEncodings {
+		req, advertised = r.advertise(ctx, req)
+	}
 	res, err := w.RoundTrip(req)
 	if err != nil {
 		return nil, err
 	}
-	ce := res.Header.Get("Content-Encoding")
-	if len(ce) == 0 {
+	return r.decode(ctx, req, res, advertised)
+}
+
+// DecodeResponse decompresses the body of res according to the Content-Encoding header, and rewrites the fields and
+// the headers of res the same way as the RoundTripper with the default settings does.
+// It is useful for the responses received from the other sources, such as http.ReadResponse or the recorded fixtures.
+// If the encoding is unsupported, ErrUnsupportedEncoding is returned and res is not modified
+func DecodeResponse(res *http.Response) error {
+	ctx := context.Background()
+	if res.Request != nil {
+		ctx = res.Request.Context()
+	}
+	var r RoundTripper
+	_, err := r.decode(ctx, res.Request, res, nil)
+	return err
+}
+
+// decode decompresses the body of res, the response of req. advertised is the names of the content codings advertised
+// by the RoundTripper
+func (r *RoundTripper) decode(ctx context.Context, req *http.Request, res *http.Response, advertised []string) (_ *http.Response, err error) {
+	st := getBodyState()
+	inUse := false
+	stats := r.counters()
+	var db *decodedBody
+	var label string
+	var encodings []string
+	metrics := r.Metrics
+	dm, _ := metrics.(DecoderMetrics)
+	if cm, ok := metrics.(ContextMetrics); ok {
+		metrics = cm.ForContext(ctx)
+	}
+	if r.Logger != nil {
+		lm := newLogMetrics(ctx, r.Logger, res)
+		if metrics == nil {
+			metrics = lm
+		} else {
+			metrics = multiMetrics{metrics, lm}
+		}
+	}
+	defer func() {
+		if !inUse {
+			st.release()
+		}
+		// the error may be counted already by the body read for buffering
+		if err != nil && (db == nil || !db.failed.Swap(true)) {
+			stats.errors.Add(1)
+			if db != nil {
+				db.enc.errors.Add(1)
+			}
+			if metrics != nil {
+				if label == "" {
+					label = unresolvedLabel(err)
+				}
+				metrics.DecodeFailed(label, err)
+			}
+			if r.OnError != nil {
+				r.OnError(req, err)
+			}
+		}
+		if err == nil && inUse && r.OnDecode != nil {
+			r.OnDecode(req, res, encodings)
+		}
+	}()
+	codings := r.appendContentEncoding(st.codings[:0], res.Header.Values("Content-Encoding")...)
+	if len(codings) == 0 {
 		return res, nil
 	}
+	if r.skip(res) {
+		stats.passThroughs.Add(1)
+		if r.Logger != nil {
+			r.logDecision(ctx, res, slog.LevelDebug, "decompress: skipped the response by the filters",
+				slog.String("encodings", strings.Join(codings, ", ")),
+				slog.Int("status", res.StatusCode),
+				slog.String("content_type", res.Header.Get("Content-Type")))
+		}
+		return res, nil
+	}
+	if limit := r.maxEncodings(); limit > 0 && len(codings) > limit {
+		res.Body.Close()
+		return nil, &ErrTooManyEncodings{Encoding: strings.Join(res.Header.Values("Content-Encoding"), ", "), Limit: limit}
+	}
+	if r.Quota != nil && r.Quota.exhausted() {
+		res.Body.Close()
+		return nil, &ErrQuotaExceeded{Limit: r.Quota.limit}
+	}
 	// decompress
 	// e.g. `Content-Encoding: deflate, gzip` => decompress `gzip` > `deflate`
-	var decompressed bool
-	encodings := strings.Split(ce, ",")
-	body := res.Body
-	for i := len(encodings) - 1; i >= 0; i-- {
-		encoding := strings.TrimSpace(encodings[i])
-		switch encoding {
-		case "gzip":
-			decompressed = true
-			r, err := gzip.NewReader(body)
-			if err != nil {
-				return nil, fmt.Errorf("decompress: create gzip reader: %w", err)
+	// all the decoders are resolved before reading the body, so that the body is untouched if an encoding is unsupported
+	layers := st.layers[:0]
+	var remaining []string
+	for i := len(codings) - 1; i >= 0; i-- {
+		encoding := codings[i]
+		f, ok := r.decoder(ctx, encoding, res)
+		unsolicited := ok && r.StrictAdvertised && !slices.Contains(advertised, encoding)
+		if !ok || unsolicited {
+			if r.PartialDecoding && len(layers) > 0 {
+				remaining = codings[:i+1]
+				if r.Logger != nil {
+					r.logDecision(ctx, res, slog.LevelInfo, "decompress: partially decoding the response",
+						slog.String("encodings", strings.Join(codings, ", ")), slog.String("remaining", strings.Join(remaining, ", ")))
+				}
+				break
 			}
-			body = &cascadeReadCloser{readFrom: r, cascade: body}
-		case "deflate":
-			decompressed = true
-			r := flate.NewReader(body)
-			body = &cascadeReadCloser{readFrom: r, cascade: body}
-		case "br":
-			decompressed = true
-			r := brotli.NewReader(body)
-			body = &cascadeReadCloser{readFrom: io.NopCloser(r), cascade: body}
-		case "identity", "":
-			// nop
-		default:
-			return nil, &ErrUnsupportedEncoding{Original: res, Encoding: ce}
-		}
-	}
-	if !decompressed {
+			if r.PassThroughUnsupported {
+				stats.passThroughs.Add(1)
+				if r.Logger != nil {
+					r.logDecision(ctx, res, slog.LevelInfo, "decompress: passed through the unsupported encoding",
+						slog.String("encodings", strings.Join(codings, ", ")), slog.String("encoding", encoding), slog.Bool("unsolicited", unsolicited))
+				}
+				return res, nil
+			}
+			ce := strings.Join(res.Header.Values("Content-Encoding"), ", ")
+			return nil, &ErrUnsupportedEncoding{Original: res, Encoding: ce, Unsolicited: unsolicited}
+		}
+		layers = append(layers, decoderLayer{encoding: encoding, factory: f})
+	}
+	if len(layers) == 0 {
 		return res, nil
 	}
-	res.Body = body
+	var held int64
+	if r.MemoryBudget != nil {
+		var n int64
+		for _, l := range layers {
+			n += memoryEstimate(l.encoding, l.factory)
+		}
+		var err error
+		if held, err = r.MemoryBudget.acquire(ctx, n); err != nil {
+			if r.MemoryBudget.policy == BudgetPassThrough {
+				stats.passThroughs.Add(1)
+				if r.Logger != nil {
+					r.logDecision(ctx, res, slog.LevelInfo, "decompress: passed through the response exceeding the memory budget",
+						slog.String("encodings", strings.Join(codings, ", ")))
+				}
+				return res, nil
+			}
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	trace := r.decodeTrace(ctx)
+	// the codings are in the reverse order of the decoding
+	decoded := make([]string, len(layers))
+	for i, l := range layers {
+		decoded[len(layers)-1-i] = l.encoding
+	}
+	if r.OnDecode != nil {
+		// copied only for OnDecode, that may retain the codings, so that decoded is not allocated on the heap per request
+		encodings = slices.Clone(decoded)
+	}
+	label = encodingLabel(decoded)
+	db = &decodedBody{
+		pool:      r.bufferPool(),
+		stats:     stats,
+		enc:       stats.encoding(label),
+		encoding:  label,
+		metrics:   metrics,
+		trace:     trace,
+		pipelined: r.PipelineDepth > 0,
+		req:       req,
+		onError:   r.OnError,
+	}
+	if metrics != nil || trace != nil {
+		db.start = time.Now()
+		db.compressed.timed = trace != nil
+	}
+	if metrics != nil {
+		metrics.DecodeStarted(label)
+	}
+	body := res.Body
+	if r.TeeCompressed != nil {
+		if w := r.TeeCompressed(res); w != nil {
+			body = &teeReadCloser{rc: body, w: w}
+		}
+	}
+	if r.DrainOnClose > 0 {
+		body = &drainReadCloser{rc: body, max: r.DrainOnClose, length: res.ContentLength}
+	}
+	contentLength := int64(-1)
+	if r.VerifyContentLength {
+		contentLength = originalContentLength(res)
+	}
+	compressed := &db.compressed
+	compressed.ReadCloser = body
+	body = compressed
+	var d *digest
+	if r.VerifyDigest {
+		d = parseDigest(res.Header)
+	}
+	var encoded *hashingReadCloser
+	if d != nil {
+		encoded = &hashingReadCloser{ReadCloser: body, h: d.new()}
+		body = encoded
+	}
+	var limited *limiterReadCloser
+	if r.Limiter != nil {
+		limited = &limiterReadCloser{l: r.Limiter, ctx: ctx}
+		body = &limiterSource{ReadCloser: body, lr: limited}
+	}
+	for i, l := range layers {
+		var src io.Reader = body
+		if i == 0 {
+			size := r.ReadBufferSize
+			if size <= 0 {
+				size = defaultReadBufferSize
+			}
+			st.br = resetBufioReader(st.br, body, size)
+			src = st.br
+		}
+		sg := st.stage(i)
+		sg.lazy = lazyDecoder{layer: l, src: src, raw: compressed, pd: &sg.pd, trace: trace, stats: stats, metrics: dm}
+		sg.rec = recoverReadCloser{rc: &sg.lazy, encoding: l.encoding}
+		sg.cascade = cascadeReadCloser{readFrom: &sg.rec, cascade: body}
+		body = &sg.cascade
+	}
+	if limited != nil {
+		limited.rc = body
+		body = limited
+	}
+	if d != nil {
+		body = &digestReadCloser{rc: body, d: d, h: d.new(), encoded: encoded}
+	}
+	if r.PipelineDepth > 0 {
+		body = newPipelineReadCloser(body, res.Body, r.PipelineDepth, r.bufferPool())
+	}
+	if r.OutputBufferSize > 0 {
+		st.out = resetBufioReader(st.out, body, r.OutputBufferSize)
+		body = &bufferedReadCloser{Reader: st.out, Closer: body}
+	}
+	if contentLength >= 0 {
+		body = &lengthReadCloser{rc: body, compressed: compressed, want: contentLength}
+	}
+	if r.ReadTimeout > 0 {
+		body = &timeoutReadCloser{rc: body, raw: res.Body, timeout: r.ReadTimeout}
+	}
+	if limit := r.maxDecompressedBytes(ctx); limit > 0 {
+		body = &limitReadCloser{rc: body, limit: limit, remaining: limit}
+	}
+	if r.Quota != nil {
+		body = &quotaReadCloser{rc: body, q: r.Quota}
+	}
+	if r.MaxCompressionRatio > 0 {
+		body = &ratioReadCloser{rc: body, compressed: compressed, max: r.MaxCompressionRatio, minBytes: r.CompressionRatioMinBytes}
+	}
+	if r.MemoryBudget != nil {
+		body = &budgetReadCloser{rc: body, b: r.MemoryBudget, n: held}
+	}
+	if r.CloneResponse {
+		cp := *res
+		cp.Header = res.Header.Clone()
+		res = &cp
+	}
+	db.rc = body
+	inUse = true
+	for _, l := range layers {
+		stats.addDecoded(l.encoding)
+	}
+	db.enc.responses.Add(1)
+	// the state is left to GC if the body may be read in the background after Close
+	if r.ReadTimeout <= 0 && r.PipelineDepth <= 0 {
+		db.st = st
+	}
+	res.Body = db
 	// Refs https://github.com/golang/go/blob/0914646ab91a3157666d845d74d8d9a4a2831e1e/src/net/http/response.go#L89-L96
 	// > Uncompressed reports whether the response was sent compressed but
 	// > was decompressed by the http package. When true, reading from
@@ -80,25 +547,602 @@ func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	// > and the "Content-Length" and "Content-Encoding" fields are deleted
 	// > from the responseHeader. To get the original response from
 	// > the server, set Transport.DisableCompression to true.
-	res.Uncompressed = true
+	// If the body is decoded partially, it is still encoded by the remaining codings, so Uncompressed is not set
+	res.Uncompressed = len(remaining) == 0
 	res.ContentLength = -1
-	res.Header.Del("Content-Encoding")
+	if r.PreserveOriginalHeaders {
+		for _, k := range []string{"Content-Encoding", "Content-Length"} {
+			if v := res.Header.Values(k); len(v) > 0 {
+				res.Header["X-Original-"+k] = v
+			}
+		}
+	}
+	if r.AnnotationHeader != "" {
+		res.Header.Set(r.AnnotationHeader, "decompress-roundtripper ("+strings.Join(decoded, ",")+")")
+	}
+	if len(remaining) > 0 {
+		res.Header.Set("Content-Encoding", strings.Join(remaining, ", "))
+	} else {
+		res.Header.Del("Content-Encoding")
+	}
 	res.Header.Del("Content-Length")
+	if r.LowLatency || isEventStream(res) {
+		return res, nil
+	}
+	if r.BufferLimit > 0 || r.SpillThreshold > 0 {
+		if err := r.buffer(res); err != nil {
+			return nil, err
+		}
+	} else if r.AdaptiveBufferLimit > 0 {
+		if err := r.adaptiveBuffer(res); err != nil {
+			return nil, err
+		}
+	}
 	return res, nil
 }
 
+// maxEncodings returns the limit of the number of the chained content codings, or 0 if it is not limited
+func (r *RoundTripper) maxEncodings() int {
+	switch {
+	case r.MaxEncodings == 0:
+		return DefaultMaxEncodings
+	case r.MaxEncodings < 0:
+		return 0
+	}
+	return r.MaxEncodings
+}
+
+type disableKey struct{}
+
+// Disable returns a copy of ctx that disables the decompression of the request with the context, so that individual
+// requests (e.g. the downloads stored compressed) can opt out while sharing the same http.Client.
+// The request and the response of the disabled request pass through the RoundTripper as is
+func Disable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, disableKey{}, true)
+}
+
+func disabled(ctx context.Context) bool {
+	v, _ := ctx.Value(disableKey{}).(bool)
+	return v
+}
+
+// stripDisableHeader removes the DisableHeader from req, and reports whether the header disables the decompression
+func (r *RoundTripper) stripDisableHeader(req *http.Request) (*http.Request, bool) {
+	if r.DisableHeader == "" {
+		return req, false
+	}
+	v := req.Header.Values(r.DisableHeader)
+	if len(v) == 0 {
+		return req, false
+	}
+	// RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Del(r.DisableHeader)
+	return req, strings.EqualFold(strings.TrimSpace(v[0]), "off")
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped RoundTripper if it supports CloseIdleConnections,
+// so that http.Client.CloseIdleConnections works through the RoundTripper.
+// If Wrap is nil, the idle connections of http.DefaultTransport are closed
+func (r *RoundTripper) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	w := r.Wrap
+	if w == nil {
+		w = http.DefaultTransport
+	}
+	if c, ok := w.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
+
 // ErrUnsupportedEncoding represents unsupported encoding error
 type ErrUnsupportedEncoding struct {
 	// original http response
 	Original *http.Response
 	Encoding string
+	// Unsolicited reports whether the encoding is supported but not advertised by the RoundTripper. See RoundTripper.StrictAdvertised
+	Unsolicited bool
 }
 
 // Error implements the error interface
 func (e *ErrUnsupportedEncoding) Error() string {
+	if e.Unsolicited {
+		return fmt.Sprintf("decompress: unsolicited content encoding `%s`", e.Encoding)
+	}
 	return fmt.Sprintf("decompress: unsuported content encoding `%s`", e.Encoding)
 }
 
+// decoderLayer is a content coding of the response and its decoder factory
+type decoderLayer struct {
+	encoding string
+	factory  DecoderFactory
+}
+
+// lazyDecoder creates the decoder at the first Read, so that RoundTrip does not block on reading the stream header,
+// and an empty body yields io.EOF instead of an error
+type lazyDecoder struct {
+	layer decoderLayer
+	src   io.Reader
+	// raw is the compressed body, whose errors are not annotated as the decode errors
+	raw    *countingReadCloser
+	w      recordingWriter
+	d      Decoder
+	err    error
+	prefix prefixReader
+	// pd is the storage of the decoder if the factory is pooled, so that the wrapper is not allocated per request
+	pd      *pooledDecoder
+	trace   *DecodeTrace
+	stats   *stats
+	metrics DecoderMetrics
+	closed  bool
+}
+
+// prefixReader reads the byte peeked from r followed by r
+type prefixReader struct {
+	b [1]byte
+	n int
+	r io.Reader
+}
+
+func (p *prefixReader) Read(b []byte) (int, error) {
+	if p.n == 0 {
+		return p.r.Read(b)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	b[0] = p.b[0]
+	p.n = 0
+	return 1, nil
+}
+
+func (l *lazyDecoder) Read(p []byte) (int, error) {
+	if l.d == nil {
+		if l.err == nil {
+			l.err = l.start()
+		}
+		if l.err != nil {
+			return 0, l.err
+		}
+	}
+	n, err := l.d.Read(p)
+	return n, classifyDecodeError(err, l.raw.err, nil)
+}
+
+func (l *lazyDecoder) WriteTo(w io.Writer) (int64, error) {
+	return l.writeToPool(w, defaultBufferPool)
+}
+
+func (l *lazyDecoder) writeToPool(w io.Writer, pool BufferPool) (int64, error) {
+	if l.d == nil {
+		if l.err == nil {
+			l.err = l.start()
+		}
+		if l.err != nil {
+			if l.err == io.EOF {
+				return 0, nil
+			}
+			return 0, l.err
+		}
+	}
+	l.w = recordingWriter{w: w}
+	n, err := writeTo(&l.w, l.d, pool)
+	return n, classifyDecodeError(err, l.raw.err, l.w.err)
+}
+
+// start creates the decoder, and reports it to the trace and the counters
+func (l *lazyDecoder) start() error {
+	err := classifyDecodeError(l.init(), l.raw.err, nil)
+	if err == nil {
+		l.created()
+	}
+	// no decoder is created for the empty body
+	if l.trace != nil && l.trace.DecoderCreated != nil && err != io.EOF {
+		l.trace.DecoderCreated(l.layer.encoding, err)
+	}
+	return err
+}
+
+func (l *lazyDecoder) init() error {
+	var src io.Reader
+	if br, ok := l.src.(*bufio.Reader); ok {
+		// keep the buffer visible to the decoders as io.ByteReader
+		if _, err := br.Peek(1); err != nil {
+			return err
+		}
+		src = br
+	} else {
+		l.prefix = prefixReader{r: l.src}
+		if n, err := io.ReadFull(l.src, l.prefix.b[:]); n == 0 {
+			return err
+		}
+		l.prefix.n = 1
+		src = &l.prefix
+	}
+	// the panics are recovered by recoverReadCloser
+	if pf, ok := l.layer.factory.(*pooledFactory); ok && l.pd != nil {
+		if err := pf.init(l.pd, src); err != nil {
+			return fmt.Errorf("decompress: create %s reader: %w", l.layer.encoding, err)
+		}
+		l.d = l.pd
+		return nil
+	}
+	d, err := l.layer.factory.NewDecoder(src)
+	if err != nil {
+		return fmt.Errorf("decompress: create %s reader: %w", l.layer.encoding, err)
+	}
+	l.d = d
+	return nil
+}
+
+// created counts the decoder created
+func (l *lazyDecoder) created() {
+	pd, pooled := l.d.(*pooledDecoder)
+	reused := pooled && pd.reused
+	if l.stats != nil {
+		c := l.stats.decoder(l.layer.encoding)
+		c.live.Add(1)
+		if reused {
+			c.poolHits.Add(1)
+		} else if pooled {
+			c.poolMisses.Add(1)
+		}
+	}
+	if l.metrics != nil {
+		l.metrics.DecoderCreated(l.layer.encoding, pooled, reused)
+	}
+}
+
+func (l *lazyDecoder) Close() error {
+	if l.d == nil {
+		return nil
+	}
+	if !l.closed {
+		l.closed = true
+		if l.stats != nil {
+			l.stats.decoder(l.layer.encoding).live.Add(-1)
+		}
+		if l.metrics != nil {
+			l.metrics.DecoderClosed(l.layer.encoding)
+		}
+	}
+	return l.d.Close()
+}
+
+// ErrReadTimeout represents the error that a Read of the decompressed body did not complete in the timeout
+type ErrReadTimeout struct {
+	Limit time.Duration
+}
+
+// Error implements the error interface
+func (e *ErrReadTimeout) Error() string {
+	return fmt.Sprintf("decompress: read timeout %v exceeded", e.Limit)
+}
+
+// Timeout reports whether the error is a timeout, so that os.IsTimeout reports true
+func (e *ErrReadTimeout) Timeout() bool {
+	return true
+}
+
+// Is reports whether target is ErrTimeout
+func (e *ErrReadTimeout) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// timeoutReadCloser closes raw, that is the underlying body of rc, when a Read of rc does not complete in timeout
+type timeoutReadCloser struct {
+	rc       io.ReadCloser
+	raw      io.Closer
+	timeout  time.Duration
+	timedOut atomic.Bool
+	err      error
+}
+
+func (l *timeoutReadCloser) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	t := time.AfterFunc(l.timeout, func() {
+		l.timedOut.Store(true)
+		l.raw.Close()
+	})
+	n, err := l.rc.Read(p)
+	if !t.Stop() && l.timedOut.Load() {
+		l.err = &ErrReadTimeout{Limit: l.timeout}
+		return n, l.err
+	}
+	return n, err
+}
+
+func (l *timeoutReadCloser) Close() error {
+	return l.rc.Close()
+}
+
+// ErrTooManyEncodings represents the error that the number of the chained content codings exceeds the limit
+type ErrTooManyEncodings struct {
+	Encoding string
+	Limit    int
+}
+
+// Error implements the error interface
+func (e *ErrTooManyEncodings) Error() string {
+	return fmt.Sprintf("decompress: content encoding `%s` exceeds the limit of %d codings", e.Encoding, e.Limit)
+}
+
+// ErrTooLarge represents the error that the decompressed body exceeds the limit
+type ErrTooLarge struct {
+	// Limit is the limit of the size of the decompressed body in bytes
+	Limit int64
+}
+
+// Error implements the error interface
+func (e *ErrTooLarge) Error() string {
+	return fmt.Sprintf("decompress: decompressed body exceeds the limit %d bytes", e.Limit)
+}
+
+// limitReadCloser returns ErrTooLarge when rc yields more than limit bytes, and closes rc
+type limitReadCloser struct {
+	rc        io.ReadCloser
+	limit     int64
+	remaining int64
+	err       error
+}
+
+func (l *limitReadCloser) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	// read a byte more than the remaining to detect the excess
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.rc.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		l.err = &ErrTooLarge{Limit: l.limit}
+		l.rc.Close()
+		return n, l.err
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func (l *limitReadCloser) Close() error {
+	return l.rc.Close()
+}
+
+// ErrRatioExceeded represents the error that the compression ratio of the body exceeds the limit
+type ErrRatioExceeded struct {
+	// Limit is the limit of the ratio of the decompressed size to the compressed size
+	Limit float64
+	// Compressed and Decompressed is the bytes read when the ratio exceeded the limit
+	Compressed, Decompressed int64
+}
+
+// Error implements the error interface
+func (e *ErrRatioExceeded) Error() string {
+	return fmt.Sprintf("decompress: compression ratio exceeds the limit %v (%d bytes to %d bytes)", e.Limit, e.Compressed, e.Decompressed)
+}
+
+// ByteCounter is implemented by the decompressed bodies returned by RoundTrip and DecodeResponse, for logging the actual
+// transfer sizes and the compression ratios:
+//
+//	if c, ok := res.Body.(decompress.ByteCounter); ok {
+//		log.Printf("%d bytes decompressed from %d bytes", c.DecompressedBytesWritten(), c.CompressedBytesRead())
+//	}
+//
+// The counts are safe to be retrieved concurrently with Read, and remain after Close
+type ByteCounter interface {
+	// CompressedBytesRead returns the bytes of the compressed body read from the wire so far
+	CompressedBytesRead() int64
+	// DecompressedBytesWritten returns the bytes of the decompressed body produced so far
+	DecompressedBytesWritten() int64
+}
+
+// Ratio returns the compression ratio of the body of res, that is the decompressed bytes per compressed byte read so
+// far, e.g. to log the anomalous responses after reading the body. ok is false if the body is not decompressed by
+// the package, or no compressed byte is read
+func Ratio(res *http.Response) (ratio float64, ok bool) {
+	c, ok := res.Body.(ByteCounter)
+	if !ok || c.CompressedBytesRead() == 0 {
+		return 0, false
+	}
+	return float64(c.DecompressedBytesWritten()) / float64(c.CompressedBytesRead()), true
+}
+
+// counter returns the ByteCounter of the body rc, or the one counting nothing if rc does not implement it
+func counter(rc io.ReadCloser) ByteCounter {
+	if c, ok := rc.(ByteCounter); ok {
+		return c
+	}
+	return zeroCounter{}
+}
+
+type zeroCounter struct{}
+
+func (zeroCounter) CompressedBytesRead() int64 {
+	return 0
+}
+
+func (zeroCounter) DecompressedBytesWritten() int64 {
+	return 0
+}
+
+// countingReadCloser counts the bytes read
+type countingReadCloser struct {
+	io.ReadCloser
+	// n is atomic, since it may be loaded while the body is read by another goroutine
+	n   atomic.Int64
+	eof bool
+	// err is the last error of the Reads other than io.EOF
+	err error
+	// timed makes the reader measure the time of the Reads into wait, in nanoseconds
+	timed bool
+	wait  atomic.Int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	if c.timed {
+		start := time.Now()
+		defer func() {
+			c.wait.Add(int64(time.Since(start)))
+		}()
+	}
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	if err == io.EOF {
+		c.eof = true
+	} else if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// originalContentLength returns the Content-Length of the response, or -1 if it is unknown
+func originalContentLength(res *http.Response) int64 {
+	if res.ContentLength >= 0 {
+		return res.ContentLength
+	}
+	if n, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return -1
+}
+
+// ErrLengthMismatch represents the error that the size of the compressed stream does not match the Content-Length
+type ErrLengthMismatch struct {
+	ContentLength int64
+	// Read is the bytes of the compressed stream read from the wire
+	Read int64
+}
+
+// Error implements the error interface
+func (e *ErrLengthMismatch) Error() string {
+	return fmt.Sprintf("decompress: compressed stream is %d bytes, want %d bytes by Content-Length", e.Read, e.ContentLength)
+}
+
+// Is reports whether target is ErrTruncated if the stream is short of the Content-Length, or ErrCorrupted otherwise
+func (e *ErrLengthMismatch) Is(target error) bool {
+	if e.Read < e.ContentLength {
+		return target == ErrTruncated
+	}
+	return target == ErrCorrupted
+}
+
+// lengthReadCloser returns ErrLengthMismatch when the compressed stream read from compressed ends short of or beyond want
+type lengthReadCloser struct {
+	rc         io.ReadCloser
+	compressed *countingReadCloser
+	want       int64
+}
+
+func (l *lengthReadCloser) Read(p []byte) (int, error) {
+	n, err := l.rc.Read(p)
+	switch {
+	case err == io.EOF:
+		// the decoders may stop before the end of the wire, so read the rest up to one byte beyond want
+		if read := l.compressed.n.Load(); !l.compressed.eof && read <= l.want {
+			io.Copy(io.Discard, io.LimitReader(l.compressed, l.want-read+1))
+		}
+		if read := l.compressed.n.Load(); read != l.want {
+			return n, &ErrLengthMismatch{ContentLength: l.want, Read: read}
+		}
+	case err != nil && l.compressed.eof && l.compressed.n.Load() < l.want:
+		// e.g. io.ErrUnexpectedEOF of the truncated stream
+		return n, &ErrLengthMismatch{ContentLength: l.want, Read: l.compressed.n.Load()}
+	}
+	return n, err
+}
+
+func (l *lengthReadCloser) Close() error {
+	return l.rc.Close()
+}
+
+// ratioReadCloser returns ErrRatioExceeded when the ratio of the bytes read from rc to the bytes read from compressed
+// exceeds max, and closes rc
+type ratioReadCloser struct {
+	rc         io.ReadCloser
+	compressed *countingReadCloser
+	max        float64
+	minBytes   int64
+	n          int64
+	err        error
+}
+
+func (l *ratioReadCloser) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.rc.Read(p)
+	l.n += int64(n)
+	if c := l.compressed.n.Load(); l.n >= l.minBytes && c > 0 && float64(l.n)/float64(c) > l.max {
+		l.err = &ErrRatioExceeded{Limit: l.max, Compressed: c, Decompressed: l.n}
+		l.rc.Close()
+		return n, l.err
+	}
+	return n, err
+}
+
+func (l *ratioReadCloser) Close() error {
+	return l.rc.Close()
+}
+
+// bufferedReadCloser reads through the buffer, and closes the underlying body
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// drainReadCloser discards the remaining stream before closing it, if the remaining bytes are known and at most max
+type drainReadCloser struct {
+	rc  io.ReadCloser
+	max int64
+	// length is the Content-Length of the body, or -1 if it is unknown
+	length int64
+	read   int64
+}
+
+func (d *drainReadCloser) Read(p []byte) (int, error) {
+	n, err := d.rc.Read(p)
+	d.read += int64(n)
+	return n, err
+}
+
+func (d *drainReadCloser) Close() error {
+	if remaining := d.length - d.read; d.length >= 0 && remaining > 0 && remaining <= d.max {
+		io.CopyN(io.Discard, d.rc, remaining)
+	}
+	return d.rc.Close()
+}
+
+// teeReadCloser writes the bytes read from rc to w until w fails, and closes w if it is io.Closer
+type teeReadCloser struct {
+	rc     io.ReadCloser
+	w      io.Writer
+	failed bool
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 && !t.failed {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			t.failed = true
+		}
+	}
+	return n, err
+}
+
+func (t *teeReadCloser) Close() error {
+	err := t.rc.Close()
+	if c, ok := t.w.(io.Closer); ok {
+		c.Close()
+	}
+	return err
+}
+
 type cascadeReadCloser struct {
 	readFrom io.ReadCloser
 	cascade  io.Closer
@@ -108,6 +1152,14 @@ func (c *cascadeReadCloser) Read(p []byte) (int, error) {
 	return c.readFrom.Read(p)
 }
 
+func (c *cascadeReadCloser) WriteTo(w io.Writer) (int64, error) {
+	return c.writeToPool(w, defaultBufferPool)
+}
+
+func (c *cascadeReadCloser) writeToPool(w io.Writer, pool BufferPool) (int64, error) {
+	return writeTo(w, c.readFrom, pool)
+}
+
 func (c *cascadeReadCloser) Close() error {
 	rerr := c.readFrom.Close()
 	cerr := c.cascade.Close()
@@ -1,18 +1,38 @@
 package decompress_test
 
 import (
+	"bufio"
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"os"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/andybalholm/brotli"
 	"github.com/kei2100/decompress-roundtripper"
+	_ "github.com/kei2100/decompress-roundtripper/brotli"
+	_ "github.com/kei2100/decompress-roundtripper/lz4"
+	_ "github.com/kei2100/decompress-roundtripper/snappy"
+	_ "github.com/kei2100/decompress-roundtripper/xz"
+	_ "github.com/kei2100/decompress-roundtripper/zstd"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 type stubRoundTripper struct {
@@ -44,18 +64,78 @@ func TestRoundTripper_RoundTrip(t *testing.T) {
 			wantBody:         "foobarbaz",
 			wantDecompressed: true,
 		},
+		{
+			title:            "x-gzip",
+			resp:             newResponse(t, gzipBytes([]byte("foobarbaz")), "x-gzip"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
 		{
 			title:            "deflate",
 			resp:             newResponse(t, deflateBytes([]byte("foobarbaz")), "deflate"),
 			wantBody:         "foobarbaz",
 			wantDecompressed: true,
 		},
+		{
+			title:            "deflate zlib",
+			resp:             newResponse(t, zlibBytes([]byte("foobarbaz")), "deflate"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
 		{
 			title:            "br",
 			resp:             newResponse(t, brotliBytes([]byte("foobarbaz")), "br"),
 			wantBody:         "foobarbaz",
 			wantDecompressed: true,
 		},
+		{
+			title:            "zstd",
+			resp:             newResponse(t, zstdBytes([]byte("foobarbaz")), "zstd"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
+		{
+			title:            "xz",
+			resp:             newResponse(t, xzBytes([]byte("foobarbaz")), "xz"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
+		{
+			title:            "lzma",
+			resp:             newResponse(t, lzmaBytes([]byte("foobarbaz")), "lzma"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
+		{
+			title:            "lz4",
+			resp:             newResponse(t, lz4Bytes([]byte("foobarbaz")), "lz4"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
+		{
+			title:            "snappy",
+			resp:             newResponse(t, snappyBytes([]byte("foobarbaz")), "snappy"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
+		{
+			title:            "bzip2",
+			resp:             newResponse(t, bzip2Foobarbaz, "bzip2"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
+		{
+			title:            "compress",
+			resp:             newResponse(t, compressBytes([]byte("foobarbaz"), 16), "compress"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
+		{
+			title:            "x-compress",
+			resp:             newResponse(t, compressBytes([]byte("foobarbaz"), 16), "x-compress"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
 		{
 			title:            "identity",
 			resp:             newResponse(t, []byte("foobarbaz"), "identity"),
@@ -71,6 +151,15 @@ func TestRoundTripper_RoundTrip(t *testing.T) {
 			wantBody:         "foobarbaz",
 			wantDecompressed: true,
 		},
+		{
+			title: "mixed snappy",
+			resp: newResponse(
+				t,
+				gzipBytes(snappyBytes([]byte("foobarbaz"))),
+				"snappy, gzip"),
+			wantBody:         "foobarbaz",
+			wantDecompressed: true,
+		},
 		{
 			title:                      "unsupported encoding",
 			resp:                       newResponse(t, gzipBytes([]byte{1, 2, 3}), "unsupported, gzip"),
@@ -138,87 +227,1827 @@ func TestRoundTripper_RoundTrip(t *testing.T) {
 	}
 }
 
-func newResponse(t *testing.T, body []byte, contentEncoding string) *http.Response {
-	t.Helper()
-	h := http.Header{}
-	h.Set("Content-Length", strconv.Itoa(len(body)))
-	if contentEncoding != "" {
-		h.Set("Content-Encoding", contentEncoding)
+func TestRoundTripper_RoundTrip_GzipMultistream(t *testing.T) {
+	body := append(gzipBytes([]byte("foobar")), gzipBytes([]byte("baz"))...)
+	tt := []struct {
+		title    string
+		opts     decompress.GzipOptions
+		wantBody string
+	}{
+		{title: "default", wantBody: "foobarbaz"},
+		{title: "disabled", opts: decompress.GzipOptions{DisableMultistream: true}, wantBody: "foobar"},
 	}
-	resp := &http.Response{
-		Status:           http.StatusText(200),
-		StatusCode:       200,
-		Proto:            "HTTP/1.1",
-		ProtoMajor:       1,
-		ProtoMinor:       1,
-		Header:           h,
-		Body:             io.NopCloser(bytes.NewBuffer(body)),
-		ContentLength:    int64(len(body)),
-		TransferEncoding: nil,
-		Close:            false,
-		Uncompressed:     false,
-		Trailer:          nil,
-		Request:          nil,
-		TLS:              nil,
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: newResponse(t, body, "gzip")},
+				Gzip: te.opts,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
 	}
-	return resp
 }
 
-func copyAndReadAll(t *testing.T, resp *http.Response) []byte {
-	t.Helper()
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		t.Error(err)
+func TestRoundTripper_RoundTrip_GzipStrict(t *testing.T) {
+	corrupt := func(b []byte, i int) []byte {
+		b = bytes.Clone(b)
+		b[len(b)+i] ^= 0xff
+		return b
+	}
+	multistream := concat(gzipBytes([]byte("foobar")), gzipBytes([]byte("baz")))
+	tt := []struct {
+		title           string
+		opts            decompress.GzipOptions
+		body            []byte
+		wantBody        string
+		wantErrChecksum bool
+	}{
+		{title: "valid", body: gzipBytes([]byte("foobarbaz")), wantBody: "foobarbaz"},
+		{title: "multistream", body: multistream, wantBody: "foobarbaz"},
+		{title: "disable multistream", opts: decompress.GzipOptions{DisableMultistream: true}, body: multistream, wantBody: "foobar"},
+		{title: "corrupted CRC32", body: corrupt(gzipBytes([]byte("foobarbaz")), -8), wantErrChecksum: true},
+		{title: "corrupted ISIZE", body: corrupt(gzipBytes([]byte("foobarbaz")), -1), wantErrChecksum: true},
+		{title: "corrupted second member", body: concat(gzipBytes([]byte("foobar")), corrupt(gzipBytes([]byte("baz")), -5)), wantErrChecksum: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			te.opts.Strict = true
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: newResponse(t, te.body, "gzip")},
+				Gzip: te.opts,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErrChecksum {
+				var wantErr *decompress.ErrChecksum
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrChecksum", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
 	}
-	cp := bytes.NewBuffer(b)
-	resp.Body = io.NopCloser(cp)
-	return cp.Bytes()
 }
 
-func gzipBytes(b []byte) []byte {
-	var dst bytes.Buffer
-	w := gzip.NewWriter(&dst)
-	if _, err := w.Write(b); err != nil {
-		panic(err)
+func TestRoundTripper_RoundTrip_GzipSkipChecksum(t *testing.T) {
+	var named bytes.Buffer
+	w := gzip.NewWriter(&named)
+	w.Name, w.Comment, w.Extra = "foo.txt", "comment", []byte("extra")
+	w.Write([]byte("foobarbaz"))
+	w.Close()
+	corrupted := gzipBytes([]byte("foobarbaz"))
+	corrupted[len(corrupted)-8] ^= 0xff
+	multistream := concat(gzipBytes([]byte("foobar")), gzipBytes([]byte("baz")))
+	tt := []struct {
+		title    string
+		opts     decompress.GzipOptions
+		body     []byte
+		wantBody string
+		wantErr  error
+	}{
+		{title: "valid", body: gzipBytes([]byte("foobarbaz")), wantBody: "foobarbaz"},
+		{title: "header fields", body: named.Bytes(), wantBody: "foobarbaz"},
+		{title: "multistream", body: multistream, wantBody: "foobarbaz"},
+		{title: "disable multistream", opts: decompress.GzipOptions{DisableMultistream: true}, body: multistream, wantBody: "foobar"},
+		{title: "corrupted CRC32 is not verified", body: corrupted, wantBody: "foobarbaz"},
+		{title: "strict takes precedence", opts: decompress.GzipOptions{Strict: true}, body: corrupted, wantErr: gzip.ErrChecksum},
+		{title: "truncated trailer", body: gzipBytes([]byte("foobarbaz"))[:len(gzipBytes([]byte("foobarbaz")))-4], wantErr: io.ErrUnexpectedEOF},
+		{title: "trailing garbage", body: concat(gzipBytes([]byte("foobarbaz")), []byte("garbage garbage")), wantErr: gzip.ErrHeader},
 	}
-	if err := w.Flush(); err != nil {
-		panic(err)
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			te.opts.SkipChecksum = true
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: newResponse(t, te.body, "gzip")},
+				Gzip: te.opts,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErr != nil {
+				if !errors.Is(err, te.wantErr) {
+					t.Errorf("got %v, want %v", err, te.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
 	}
-	if err := w.Close(); err != nil {
-		panic(err)
+}
+
+func TestRoundTripper_RoundTrip_Base64(t *testing.T) {
+	encoded := []byte(base64.StdEncoding.EncodeToString(gzipBytes([]byte("foobarbaz"))))
+
+	t.Run("enabled", func(t *testing.T) {
+		dr := decompress.RoundTripper{
+			Wrap:   &stubRoundTripper{response: newResponse(t, encoded, "gzip, base64")},
+			Base64: true,
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp, err := dr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+			t.Errorf("body got %v, want %v", got, want)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		dr := decompress.RoundTripper{
+			Wrap: &stubRoundTripper{response: newResponse(t, encoded, "gzip, base64")},
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		_, err := dr.RoundTrip(req)
+		var wantErr *decompress.ErrUnsupportedEncoding
+		if !errors.As(err, &wantErr) {
+			t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+		}
+	})
+}
+
+func TestRoundTripper_RoundTrip_LenientParsing(t *testing.T) {
+	tt := []struct {
+		title                      string
+		lenient                    bool
+		contentEncoding            string
+		wantErrUnsupportedEncoding bool
+	}{
+		{title: "quality", lenient: true, contentEncoding: "gzip;q=1.0"},
+		{title: "parameter with spaces", lenient: true, contentEncoding: "gzip ; charset=utf-8"},
+		{title: "identity with parameter", lenient: true, contentEncoding: "identity;q=0, gzip;q=1.0"},
+		{title: "strict", contentEncoding: "gzip;q=1.0", wantErrUnsupportedEncoding: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:           &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), te.contentEncoding)},
+				LenientParsing: te.lenient,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
 	}
-	return dst.Bytes()
 }
 
-func deflateBytes(b []byte) []byte {
-	var dst bytes.Buffer
-	w, err := flate.NewWriter(&dst, flate.DefaultCompression)
-	if err != nil {
-		panic(err)
+func TestRoundTripper_RoundTrip_CloneResponse(t *testing.T) {
+	tt := []struct {
+		title         string
+		clone         bool
+		wantUnchanged bool
+	}{
+		{title: "clone", clone: true, wantUnchanged: true},
+		{title: "in place", clone: false, wantUnchanged: false},
 	}
-	if _, err := w.Write(b); err != nil {
-		panic(err)
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			original := newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")
+			originalBody := original.Body
+			dr := decompress.RoundTripper{
+				Wrap:          &stubRoundTripper{response: original},
+				CloneResponse: te.clone,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Encoding"), ""; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := resp == original, !te.wantUnchanged; got != want {
+				t.Errorf("same response got %v, want %v", got, want)
+			}
+			if !te.wantUnchanged {
+				return
+			}
+			if got, want := original.Header.Get("Content-Encoding"), "gzip"; got != want {
+				t.Errorf("original Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := original.Header.Get("Content-Length"), strconv.FormatInt(original.ContentLength, 10); got != want {
+				t.Errorf("original Content-Length got %v, want %v", got, want)
+			}
+			if original.Uncompressed {
+				t.Error("original Uncompressed got true, want false")
+			}
+			if original.Body != originalBody {
+				t.Error("original Body is replaced")
+			}
+		})
 	}
-	if err := w.Flush(); err != nil {
-		panic(err)
+}
+
+func TestRoundTripper_RoundTrip_PreserveOriginalHeaders(t *testing.T) {
+	body := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title                string
+		preserve             bool
+		contentEncoding      string
+		wantOriginalEncoding string
+		wantOriginalLength   string
+	}{
+		{title: "preserve", preserve: true, contentEncoding: "gzip", wantOriginalEncoding: "gzip", wantOriginalLength: strconv.Itoa(len(body))},
+		{title: "preserve multiple codings", preserve: true, contentEncoding: "identity, gzip", wantOriginalEncoding: "identity, gzip", wantOriginalLength: strconv.Itoa(len(body))},
+		{title: "not preserve", contentEncoding: "gzip"},
 	}
-	if err := w.Close(); err != nil {
-		panic(err)
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:                    &stubRoundTripper{response: newResponse(t, body, te.contentEncoding)},
+				PreserveOriginalHeaders: te.preserve,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Encoding"), ""; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("X-Original-Content-Encoding"), te.wantOriginalEncoding; got != want {
+				t.Errorf("X-Original-Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("X-Original-Content-Length"), te.wantOriginalLength; got != want {
+				t.Errorf("X-Original-Content-Length got %v, want %v", got, want)
+			}
+		})
 	}
-	return dst.Bytes()
 }
 
-func brotliBytes(b []byte) []byte {
-	var dst bytes.Buffer
-	w := brotli.NewWriter(&dst)
-	if _, err := w.Write(b); err != nil {
-		panic(err)
+func TestRoundTripper_RoundTrip_AnnotationHeader(t *testing.T) {
+	tt := []struct {
+		title           string
+		header          string
+		contentEncoding string
+		body            []byte
+		wantHeader      string
+	}{
+		{title: "single coding", header: "X-Decompressed-By", contentEncoding: "gzip", body: gzipBytes([]byte("foobarbaz")), wantHeader: "decompress-roundtripper (gzip)"},
+		{title: "multiple codings", header: "X-Decompressed-By", contentEncoding: "deflate, gzip", body: gzipBytes(deflateBytes([]byte("foobarbaz"))), wantHeader: "decompress-roundtripper (deflate,gzip)"},
+		{title: "partially decoded", header: "X-Decompressed-By", contentEncoding: "x-unknown, gzip", body: gzipBytes([]byte("foobarbaz")), wantHeader: "decompress-roundtripper (gzip)"},
+		{title: "not compressed", header: "X-Decompressed-By", body: []byte("foobarbaz")},
+		{title: "no header", contentEncoding: "gzip", body: gzipBytes([]byte("foobarbaz"))},
 	}
-	if err := w.Flush(); err != nil {
-		panic(err)
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:             &stubRoundTripper{response: newResponse(t, te.body, te.contentEncoding)},
+				AnnotationHeader: te.header,
+				PartialDecoding:  true,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got, want := resp.Header.Get("X-Decompressed-By"), te.wantHeader; got != want {
+				t.Errorf("X-Decompressed-By got %v, want %v", got, want)
+			}
+		})
 	}
-	if err := w.Close(); err != nil {
-		panic(err)
+}
+
+func TestRoundTripper_RoundTrip_PassThroughUnsupported(t *testing.T) {
+	tt := []struct {
+		title                      string
+		passThrough                bool
+		contentEncoding            string
+		wantErrUnsupportedEncoding bool
+	}{
+		{title: "pass through", passThrough: true, contentEncoding: "x-unknown"},
+		{title: "pass through chain", passThrough: true, contentEncoding: "x-unknown, gzip"},
+		{title: "error", contentEncoding: "x-unknown, gzip", wantErrUnsupportedEncoding: true},
 	}
-	return dst.Bytes()
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			body := gzipBytes([]byte("foobarbaz"))
+			dr := decompress.RoundTripper{
+				Wrap:                   &stubRoundTripper{response: newResponse(t, body, te.contentEncoding)},
+				PassThroughUnsupported: te.passThrough,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Fatalf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				resp = wantErr.Original
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), string(body); got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Encoding"), te.contentEncoding; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := resp.ContentLength, int64(len(body)); got != want {
+				t.Errorf("ContentLength got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_PartialDecoding(t *testing.T) {
+	tt := []struct {
+		title                      string
+		partial                    bool
+		contentEncoding            string
+		body                       []byte
+		wantBody                   string
+		wantContentEncoding        string
+		wantUncompressed           bool
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:               "custom, gzip",
+			partial:             true,
+			contentEncoding:     "x-unknown, gzip",
+			body:                gzipBytes([]byte("foobarbaz")),
+			wantBody:            "foobarbaz",
+			wantContentEncoding: "x-unknown",
+		},
+		{
+			title:               "multiple remaining codings",
+			partial:             true,
+			contentEncoding:     "gzip,x-unknown ,  deflate, gzip",
+			body:                gzipBytes(deflateBytes([]byte("foobarbaz"))),
+			wantBody:            "foobarbaz",
+			wantContentEncoding: "gzip, x-unknown",
+		},
+		{
+			title:               "all supported",
+			partial:             true,
+			contentEncoding:     "deflate, gzip",
+			body:                gzipBytes(deflateBytes([]byte("foobarbaz"))),
+			wantBody:            "foobarbaz",
+			wantContentEncoding: "",
+			wantUncompressed:    true,
+		},
+		{
+			title:                      "last coding unsupported",
+			partial:                    true,
+			contentEncoding:            "gzip, x-unknown",
+			body:                       gzipBytes([]byte("foobarbaz")),
+			wantErrUnsupportedEncoding: true,
+		},
+		{
+			title:                      "disabled",
+			contentEncoding:            "x-unknown, gzip",
+			body:                       gzipBytes([]byte("foobarbaz")),
+			wantErrUnsupportedEncoding: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:            &stubRoundTripper{response: newResponse(t, te.body, te.contentEncoding)},
+				PartialDecoding: te.partial,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Encoding"), te.wantContentEncoding; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Length"), ""; got != want {
+				t.Errorf("Content-Length got %v, want %v", got, want)
+			}
+			if got, want := resp.Uncompressed, te.wantUncompressed; got != want {
+				t.Errorf("Uncompressed got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDisable(t *testing.T) {
+	body := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title               string
+		disable             bool
+		wantBody            string
+		wantContentEncoding string
+		wantAcceptEncoding  string
+	}{
+		{title: "disabled", disable: true, wantBody: string(body), wantContentEncoding: "gzip", wantAcceptEncoding: ""},
+		{title: "enabled", wantBody: "foobarbaz", wantContentEncoding: "", wantAcceptEncoding: "br, deflate, gzip, zstd"},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var acceptEncoding string
+			dr := decompress.RoundTripper{
+				Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					acceptEncoding = req.Header.Get("Accept-Encoding")
+					return newResponse(t, body, "gzip"), nil
+				}),
+				AdvertiseEncodings: true,
+			}
+			ctx := context.Background()
+			if te.disable {
+				ctx = decompress.Disable(ctx)
+			}
+			req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Encoding"), te.wantContentEncoding; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := acceptEncoding, te.wantAcceptEncoding; got != want {
+				t.Errorf("Accept-Encoding got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_DisableHeader(t *testing.T) {
+	body := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title         string
+		disableHeader string
+		header        map[string]string
+		wantBody      string
+		wantForwarded string
+	}{
+		{title: "off", disableHeader: "X-Decompress", header: map[string]string{"X-Decompress": "off"}, wantBody: string(body)},
+		{title: "off case insensitive", disableHeader: "x-decompress", header: map[string]string{"X-Decompress": " OFF"}, wantBody: string(body)},
+		{title: "on", disableHeader: "X-Decompress", header: map[string]string{"X-Decompress": "on"}, wantBody: "foobarbaz"},
+		{title: "no header", disableHeader: "X-Decompress", wantBody: "foobarbaz"},
+		{title: "not configured", header: map[string]string{"X-Decompress": "off"}, wantBody: "foobarbaz", wantForwarded: "off"},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var forwarded string
+			dr := decompress.RoundTripper{
+				Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					forwarded = req.Header.Get("X-Decompress")
+					return newResponse(t, body, "gzip"), nil
+				}),
+				DisableHeader: te.disableHeader,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			for k, v := range te.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+			if got, want := forwarded, te.wantForwarded; got != want {
+				t.Errorf("forwarded header got %v, want %v", got, want)
+			}
+			if got, want := req.Header.Get("X-Decompress"), te.header["X-Decompress"]; got != want {
+				t.Errorf("original request header got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_ContentEncodingList(t *testing.T) {
+	tt := []struct {
+		title               string
+		contentEncodings    []string
+		body                []byte
+		partial             bool
+		wantBody            string
+		wantContentEncoding string
+	}{
+		{
+			title:            "multiple header fields",
+			contentEncodings: []string{"deflate", "gzip"},
+			body:             gzipBytes(deflateBytes([]byte("foobarbaz"))),
+			wantBody:         "foobarbaz",
+		},
+		{
+			title:            "empty elements",
+			contentEncodings: []string{"deflate, ,gzip,"},
+			body:             gzipBytes(deflateBytes([]byte("foobarbaz"))),
+			wantBody:         "foobarbaz",
+		},
+		{
+			title:            "empty header field",
+			contentEncodings: []string{"deflate", "", "gzip"},
+			body:             gzipBytes(deflateBytes([]byte("foobarbaz"))),
+			wantBody:         "foobarbaz",
+		},
+		{
+			title:               "partial decoding",
+			contentEncodings:    []string{"x-unknown, ", "gzip"},
+			body:                gzipBytes([]byte("foobarbaz")),
+			partial:             true,
+			wantBody:            "foobarbaz",
+			wantContentEncoding: "x-unknown",
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			res := newResponse(t, te.body, "")
+			res.Header["Content-Encoding"] = te.contentEncodings
+			dr := decompress.RoundTripper{
+				Wrap:            &stubRoundTripper{response: res},
+				PartialDecoding: te.partial,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			if got, want := strings.Join(resp.Header.Values("Content-Encoding"), ","), te.wantContentEncoding; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_LazyDecoder(t *testing.T) {
+	tt := []struct {
+		title           string
+		contentEncoding string
+		body            []byte
+		wantBody        string
+		wantErr         bool
+	}{
+		{title: "empty body", contentEncoding: "gzip", body: nil, wantBody: ""},
+		{title: "empty body chain", contentEncoding: "deflate, gzip", body: nil, wantBody: ""},
+		{title: "empty stream", contentEncoding: "deflate, gzip", body: gzipBytes(nil), wantBody: ""},
+		{title: "invalid header", contentEncoding: "gzip", body: []byte("foobarbaz"), wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: newResponse(t, te.body, te.contentEncoding)}}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+		})
+	}
+	t.Run("close without read", func(t *testing.T) {
+		dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: newResponse(t, []byte("foobarbaz"), "gzip")}}
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp, err := dr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestDecodeResponse(t *testing.T) {
+	tt := []struct {
+		title                      string
+		contentEncoding            string
+		body                       []byte
+		wantBody                   string
+		wantContentEncoding        string
+		wantErrUnsupportedEncoding bool
+	}{
+		{title: "gzip", contentEncoding: "gzip", body: gzipBytes([]byte("foobarbaz")), wantBody: "foobarbaz"},
+		{title: "chain", contentEncoding: "deflate, gzip", body: gzipBytes(deflateBytes([]byte("foobarbaz"))), wantBody: "foobarbaz"},
+		{title: "not encoded", body: []byte("foobarbaz"), wantBody: "foobarbaz"},
+		{title: "unsupported", contentEncoding: "x-unknown", body: []byte("foobarbaz"), wantBody: "foobarbaz", wantContentEncoding: "x-unknown", wantErrUnsupportedEncoding: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var raw bytes.Buffer
+			raw.WriteString("HTTP/1.1 200 OK\r\n")
+			if te.contentEncoding != "" {
+				raw.WriteString("Content-Encoding: " + te.contentEncoding + "\r\n")
+			}
+			raw.WriteString("Content-Length: " + strconv.Itoa(len(te.body)) + "\r\n\r\n")
+			raw.Write(te.body)
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := http.ReadResponse(bufio.NewReader(&raw), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = decompress.DecodeResponse(resp)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Encoding"), te.wantContentEncoding; got != want {
+				t.Errorf("Content-Encoding got %v, want %v", got, want)
+			}
+			if got, want := resp.Uncompressed, te.contentEncoding != "" && !te.wantErrUnsupportedEncoding; got != want {
+				t.Errorf("Uncompressed got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_MaxDecompressedBytes(t *testing.T) {
+	data := bytes.Repeat([]byte("foobarbaz"), 1000)
+	tt := []struct {
+		title           string
+		limit           int64
+		policies        []decompress.Policy
+		wantErrTooLarge bool
+	}{
+		{title: "no limit"},
+		{title: "just the limit", limit: int64(len(data))},
+		{title: "exceeds the limit", limit: int64(len(data)) - 1, wantErrTooLarge: true},
+		{title: "policy", limit: int64(len(data)), policies: []decompress.Policy{{MaxDecompressedBytes: 100}}, wantErrTooLarge: true},
+		{title: "policy without limit", limit: 100, policies: []decompress.Policy{{MaxDecompressedBytes: -1}}},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			res := newResponse(t, gzipBytes(data), "gzip")
+			body := &closeRecorder{ReadCloser: res.Body}
+			res.Body = body
+			dr := decompress.RoundTripper{
+				Wrap:                 &stubRoundTripper{response: res},
+				MaxDecompressedBytes: te.limit,
+				Policies:             te.policies,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErrTooLarge {
+				var wantErr *decompress.ErrTooLarge
+				if !errors.As(err, &wantErr) {
+					t.Fatalf("got %T %v, want ErrTooLarge", err, err)
+				}
+				if got, want := int64(len(b)), wantErr.Limit; got != want {
+					t.Errorf("read got %v bytes, want %v bytes", got, want)
+				}
+				if !body.closed {
+					t.Error("body is not closed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(b), len(data); got != want {
+				t.Errorf("read got %v bytes, want %v bytes", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_MaxCompressionRatio(t *testing.T) {
+	bomb := bytes.Repeat([]byte{0}, 1<<20)
+	random := make([]byte, 1<<16)
+	rand.New(rand.NewSource(1)).Read(random)
+	tt := []struct {
+		title                string
+		data                 []byte
+		ratio                float64
+		minBytes             int64
+		wantErrRatioExceeded bool
+	}{
+		{title: "bomb", data: bomb, ratio: 100, wantErrRatioExceeded: true},
+		{title: "bomb smaller than min bytes", data: bomb, ratio: 100, minBytes: 2 << 20},
+		{title: "incompressible", data: random, ratio: 2},
+		{title: "no limit", data: bomb},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			res := newResponse(t, gzipBytes(te.data), "gzip")
+			body := &closeRecorder{ReadCloser: res.Body}
+			res.Body = body
+			dr := decompress.New(
+				decompress.WithTransport(&stubRoundTripper{response: res}),
+				decompress.WithMaxCompressionRatio(te.ratio, te.minBytes),
+			)
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErrRatioExceeded {
+				var wantErr *decompress.ErrRatioExceeded
+				if !errors.As(err, &wantErr) {
+					t.Fatalf("got %T %v, want ErrRatioExceeded", err, err)
+				}
+				if len(b) >= len(te.data) {
+					t.Errorf("read got %v bytes, want less than %v bytes", len(b), len(te.data))
+				}
+				if !body.closed {
+					t.Error("body is not closed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(b), len(te.data); got != want {
+				t.Errorf("read got %v bytes, want %v bytes", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_MaxEncodings(t *testing.T) {
+	gzipN := func(n int) ([]byte, string) {
+		b, codings := []byte("foobarbaz"), make([]string, n)
+		for i := range codings {
+			b, codings[i] = gzipBytes(b), "gzip"
+		}
+		return b, strings.Join(codings, ", ")
+	}
+	tt := []struct {
+		title                   string
+		maxEncodings            int
+		n                       int
+		wantErrTooManyEncodings bool
+	}{
+		{title: "default", n: decompress.DefaultMaxEncodings},
+		{title: "exceeds default", n: decompress.DefaultMaxEncodings + 1, wantErrTooManyEncodings: true},
+		{title: "limit", maxEncodings: 2, n: 2},
+		{title: "exceeds limit", maxEncodings: 2, n: 3, wantErrTooManyEncodings: true},
+		{title: "no limit", maxEncodings: -1, n: 20},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			b, ce := gzipN(te.n)
+			res := newResponse(t, b, ce)
+			body := &closeRecorder{ReadCloser: res.Body}
+			res.Body = body
+			dr := decompress.RoundTripper{
+				Wrap:         &stubRoundTripper{response: res},
+				MaxEncodings: te.maxEncodings,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrTooManyEncodings {
+				var wantErr *decompress.ErrTooManyEncodings
+				if !errors.As(err, &wantErr) {
+					t.Fatalf("got %T %v, want ErrTooManyEncodings", err, err)
+				}
+				if !body.closed {
+					t.Error("body is not closed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(copyAndReadAll(t, resp)), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_VerifyContentLength(t *testing.T) {
+	gz := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title           string
+		body            []byte
+		contentEncoding string
+		contentLength   int64
+		wantMismatch    bool
+	}{
+		{title: "match", body: gz, contentEncoding: "gzip", contentLength: int64(len(gz))},
+		{title: "truncated", body: gz[:len(gz)-4], contentEncoding: "gzip", contentLength: int64(len(gz)), wantMismatch: true},
+		{title: "short", body: gz, contentEncoding: "gzip", contentLength: int64(len(gz)) + 10, wantMismatch: true},
+		{title: "long", body: concat(deflateBytes([]byte("foobarbaz")), []byte("trailing")), contentEncoding: "deflate", contentLength: int64(len(deflateBytes([]byte("foobarbaz")))), wantMismatch: true},
+		{title: "unknown length", body: gz, contentEncoding: "gzip", contentLength: -1},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, te.body, te.contentEncoding)
+			resp.ContentLength = te.contentLength
+			resp.Header.Del("Content-Length")
+			if te.contentLength >= 0 {
+				resp.Header.Set("Content-Length", strconv.FormatInt(te.contentLength, 10))
+			}
+			dr := decompress.RoundTripper{
+				Wrap:                &stubRoundTripper{response: resp},
+				VerifyContentLength: true,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if te.wantMismatch {
+				var wantErr *decompress.ErrLengthMismatch
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrLengthMismatch", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_DrainOnClose(t *testing.T) {
+	random := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	body := gzipBytes(random)
+	tt := []struct {
+		title         string
+		drain         int64
+		contentLength int64
+		wantDrained   bool
+	}{
+		{title: "disabled", drain: 0, contentLength: int64(len(body)), wantDrained: false},
+		{title: "drained", drain: int64(len(body)), contentLength: int64(len(body)), wantDrained: true},
+		{title: "too many remaining bytes", drain: 1024, contentLength: int64(len(body)), wantDrained: false},
+		{title: "unknown length", drain: int64(len(body)), contentLength: -1, wantDrained: false},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, body, "gzip")
+			raw := bytes.NewReader(body)
+			recorder := &closeRecorder{ReadCloser: io.NopCloser(raw)}
+			resp.Body = recorder
+			resp.ContentLength = te.contentLength
+			dr := decompress.RoundTripper{
+				Wrap:         &stubRoundTripper{response: resp},
+				DrainOnClose: te.drain,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := resp.Body.Read(make([]byte, 16)); err != nil {
+				t.Fatal(err)
+			}
+			remaining := raw.Len()
+			if err := resp.Body.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !recorder.closed {
+				t.Error("body is not closed")
+			}
+			if got, want := raw.Len() == 0, te.wantDrained; got != want {
+				t.Errorf("drained got %v, want %v", got, want)
+			}
+			if !te.wantDrained && raw.Len() != remaining {
+				t.Errorf("read %v bytes on close, want 0", remaining-raw.Len())
+			}
+		})
+	}
+}
+
+// readRecorder records the reads of the underlying reader
+type readRecorder struct {
+	io.Reader
+	calls   int
+	maxRead int
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.calls++
+	r.maxRead = max(r.maxRead, n)
+	return n, err
+}
+
+func TestRoundTripper_RoundTrip_ReadBufferSize(t *testing.T) {
+	// compressible, so that the deflate decoder reads byte by byte instead of reading the stored blocks at once
+	random := make([]byte, 256<<10)
+	rng := rand.New(rand.NewSource(1))
+	for i := range random {
+		random[i] = 'a' + byte(rng.Intn(4))
+	}
+	body := gzipBytes(random)
+	tt := []struct {
+		title       string
+		size        int
+		wantMaxRead int
+	}{
+		{title: "default", size: 0, wantMaxRead: 4096},
+		{title: "small", size: 512, wantMaxRead: 512},
+		{title: "large", size: 32 << 10, wantMaxRead: 32 << 10},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, body, "gzip")
+			raw := &readRecorder{Reader: bytes.NewReader(body)}
+			resp.Body = io.NopCloser(raw)
+			dr := decompress.RoundTripper{
+				Wrap:           &stubRoundTripper{response: resp},
+				ReadBufferSize: te.size,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, random) {
+				t.Error("body mismatch")
+			}
+			if got, want := raw.maxRead, te.wantMaxRead; got != want {
+				t.Errorf("max read got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_PooledBuffers(t *testing.T) {
+	data := bytes.Repeat([]byte("foobarbaz"), 1000)
+	body := gzipBytes(data)
+	dr := decompress.RoundTripper{
+		Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return newResponse(t, body, "gzip"), nil
+		}),
+		ReadBufferSize:   512,
+		OutputBufferSize: 512,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(partial bool) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if partial {
+				// closed before EOF
+				resp.Body.Read(make([]byte, 100))
+				resp.Body.Close()
+				if _, err := resp.Body.Read(make([]byte, 1)); err != http.ErrBodyReadAfterClose {
+					t.Errorf("read after close got %v, want %v", err, http.ErrBodyReadAfterClose)
+				}
+				return
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(b, data) {
+				t.Error("body mismatch")
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
+
+func TestRoundTripper_RoundTrip_WriteTo(t *testing.T) {
+	data := bytes.Repeat([]byte("foobarbaz"), 10000)
+	tt := []struct {
+		title           string
+		dr              decompress.RoundTripper
+		body            []byte
+		contentEncoding string
+		wantBody        []byte
+		wantErrTooLarge bool
+	}{
+		{title: "gzip", body: gzipBytes(data), contentEncoding: "gzip", wantBody: data},
+		{title: "chained", body: gzipBytes(deflateBytes(data)), contentEncoding: "deflate, gzip", wantBody: data},
+		{title: "empty", body: nil, contentEncoding: "gzip", wantBody: nil},
+		{title: "buffers", dr: decompress.RoundTripper{ReadBufferSize: 512, OutputBufferSize: 512}, body: gzipBytes(data), contentEncoding: "gzip", wantBody: data},
+		{title: "buffered mode", dr: decompress.RoundTripper{BufferLimit: 1 << 20}, body: gzipBytes(data), contentEncoding: "gzip", wantBody: data},
+		{title: "guarded", dr: decompress.RoundTripper{MaxDecompressedBytes: 100}, body: gzipBytes(data), contentEncoding: "gzip", wantErrTooLarge: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := te.dr
+			dr.Wrap = &stubRoundTripper{response: newResponse(t, te.body, te.contentEncoding)}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			wt, ok := resp.Body.(io.WriterTo)
+			if !ok {
+				t.Fatalf("%T does not implement io.WriterTo", resp.Body)
+			}
+			var buf bytes.Buffer
+			n, err := wt.WriteTo(&buf)
+			if te.wantErrTooLarge {
+				var wantErr *decompress.ErrTooLarge
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrTooLarge", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := n, int64(len(te.wantBody)); got != want {
+				t.Errorf("n got %v, want %v", got, want)
+			}
+			if !bytes.Equal(buf.Bytes(), te.wantBody) {
+				t.Error("body mismatch")
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_OutputBufferSize(t *testing.T) {
+	tt := []struct {
+		title     string
+		size      int
+		wantCalls int
+	}{
+		{title: "unbuffered", size: 0, wantCalls: 10},
+		{title: "buffered", size: 4096, wantCalls: 1},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var decoded *readRecorder
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: newResponse(t, []byte("0123456789"), "x-identity")},
+				Decoders: map[string]decompress.DecoderFactory{
+					"x-identity": decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+						decoded = &readRecorder{Reader: r}
+						return io.NopCloser(decoded), nil
+					}),
+				},
+				OutputBufferSize: te.size,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			p := make([]byte, 1)
+			for i := 0; i < 10; i++ {
+				if _, err := io.ReadFull(resp.Body, p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := decoded.calls, te.wantCalls; got != want {
+				t.Errorf("decoder reads got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_ReadTimeout(t *testing.T) {
+	tt := []struct {
+		title       string
+		timeout     time.Duration
+		stall       bool
+		wantTimeout bool
+	}{
+		{title: "stall", timeout: 50 * time.Millisecond, stall: true, wantTimeout: true},
+		{title: "no stall", timeout: time.Second},
+		{title: "no timeout"},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			res := newResponse(t, nil, "gzip")
+			body := newStallBody(gzipBytes([]byte("foobarbaz")), te.stall)
+			res.Body = body
+			dr := decompress.RoundTripper{
+				Wrap:        &stubRoundTripper{response: res},
+				ReadTimeout: te.timeout,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if te.wantTimeout {
+				var wantErr *decompress.ErrReadTimeout
+				if !errors.As(err, &wantErr) {
+					t.Fatalf("got %T %v, want ErrReadTimeout", err, err)
+				}
+				if !os.IsTimeout(err) {
+					t.Error("os.IsTimeout got false, want true")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// stallBody yields the half of the data, and blocks until closed if stall is true
+type stallBody struct {
+	r      io.Reader
+	rest   []byte
+	stall  bool
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newStallBody(data []byte, stall bool) *stallBody {
+	return &stallBody{r: bytes.NewReader(data[:len(data)/2]), rest: data[len(data)/2:], stall: stall, closed: make(chan struct{})}
+}
+
+func (s *stallBody) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != io.EOF || s.rest == nil {
+		return n, err
+	}
+	if s.stall {
+		<-s.closed
+		return 0, errors.New("read on closed body")
+	}
+	s.r, s.rest = bytes.NewReader(s.rest), nil
+	return s.r.Read(p)
+}
+
+func (s *stallBody) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestRoundTripper_RoundTrip_Pipeline(t *testing.T) {
+	data := bytes.Repeat([]byte("foobarbaz"), 32<<10)
+	tt := []struct {
+		title    string
+		depth    int
+		body     []byte
+		wantBody []byte
+		wantErr  bool
+	}{
+		{title: "pipelined", depth: 4, body: gzipBytes(data), wantBody: data},
+		{title: "depth 1", depth: 1, body: gzipBytes(data), wantBody: data},
+		{title: "small", depth: 4, body: gzipBytes([]byte("foobarbaz")), wantBody: []byte("foobarbaz")},
+		{title: "empty", depth: 4, body: gzipBytes(nil), wantBody: []byte{}},
+		{title: "corrupted", depth: 4, body: gzipBytes(data)[:100], wantErr: true},
+		{title: "not pipelined", body: gzipBytes(data), wantBody: data},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:          &stubRoundTripper{response: newResponse(t, te.body, "gzip")},
+				PipelineDepth: te.depth,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, te.wantBody) {
+				t.Errorf("body got %d bytes, want %d bytes", len(b), len(te.wantBody))
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_PipelineClose(t *testing.T) {
+	res := newResponse(t, nil, "gzip")
+	body := newStallBody(gzipBytes(bytes.Repeat([]byte("foobarbaz"), 32<<10)), true)
+	res.Body = body
+	dr := decompress.RoundTripper{
+		Wrap:          &stubRoundTripper{response: res},
+		PipelineDepth: 2,
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	resp, err := dr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resp.Body.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	// the background goroutine stalls on the body, and is unblocked by Close
+	if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-body.closed:
+	case <-time.After(time.Second):
+		t.Fatal("body is not closed")
+	}
+	if _, err := resp.Body.Read(make([]byte, 16)); err == nil {
+		t.Error("read after close got nil, want error")
+	}
+}
+
+func TestRoundTripper_RoundTrip_Flush(t *testing.T) {
+	tt := []struct {
+		title           string
+		contentEncoding string
+		contentType     string
+		dr              decompress.RoundTripper
+	}{
+		{title: "gzip", contentEncoding: "gzip"},
+		{title: "deflate", contentEncoding: "deflate"},
+		{title: "read buffer", contentEncoding: "gzip", dr: decompress.RoundTripper{ReadBufferSize: 16}},
+		{title: "output buffer", contentEncoding: "gzip", dr: decompress.RoundTripper{OutputBufferSize: 4096}},
+		{title: "pipeline", contentEncoding: "gzip", dr: decompress.RoundTripper{PipelineDepth: 4}},
+		{title: "gzip strict", contentEncoding: "gzip", dr: decompress.RoundTripper{Gzip: decompress.GzipOptions{Strict: true}}},
+		{title: "gzip skip checksum", contentEncoding: "gzip", dr: decompress.RoundTripper{Gzip: decompress.GzipOptions{SkipChecksum: true}}},
+		{title: "gzip single stream", contentEncoding: "gzip", dr: decompress.RoundTripper{Gzip: decompress.GzipOptions{DisableMultistream: true}}},
+		{title: "event stream not buffered", contentEncoding: "gzip", contentType: "text/event-stream; charset=utf-8", dr: decompress.RoundTripper{BufferLimit: 1 << 20}},
+		{title: "low latency not buffered", contentEncoding: "gzip", dr: decompress.RoundTripper{AdaptiveBufferLimit: 1 << 20, LowLatency: true}},
+		{title: "low latency", contentEncoding: "gzip", dr: decompress.RoundTripper{OutputBufferSize: 4096, PipelineDepth: 4, LowLatency: true}},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			pr, pw := io.Pipe()
+			defer pr.Close()
+			var w interface {
+				io.WriteCloser
+				Flush() error
+			}
+			if te.contentEncoding == "gzip" {
+				w = gzip.NewWriter(pw)
+			} else {
+				w, _ = flate.NewWriter(pw, flate.DefaultCompression)
+			}
+			next := make(chan struct{})
+			go func() {
+				defer pw.Close()
+				for i := 0; i < 3; i++ {
+					fmt.Fprintf(w, "data: %d\n\n", i)
+					if err := w.Flush(); err != nil {
+						return
+					}
+					if _, ok := <-next; !ok {
+						return
+					}
+				}
+				w.Close()
+			}()
+			defer close(next)
+			res := newResponse(t, nil, te.contentEncoding)
+			res.Header.Del("Content-Length")
+			res.ContentLength = -1
+			res.Header.Set("Content-Type", te.contentType)
+			res.Body = pr
+			dr := te.dr
+			dr.Wrap = &stubRoundTripper{response: res}
+			req, _ := http.NewRequest("GET", "/", nil)
+			got := make(chan *http.Response, 1)
+			go func() {
+				resp, _ := dr.RoundTrip(req)
+				got <- resp
+			}()
+			var resp *http.Response
+			select {
+			case resp = <-got:
+			case <-time.After(time.Second):
+				t.Fatal("RoundTrip waits for the whole body")
+			}
+			if resp == nil {
+				t.Fatal("RoundTrip failed")
+			}
+			defer resp.Body.Close()
+			// each event is delivered before the next one is written
+			for i := 0; i < 3; i++ {
+				want := fmt.Sprintf("data: %d\n\n", i)
+				b := make([]byte, len(want))
+				done := make(chan error, 1)
+				go func() {
+					_, err := io.ReadFull(resp.Body, b)
+					done <- err
+				}()
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatal(err)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("event %d is not delivered", i)
+				}
+				if got := string(b); got != want {
+					t.Errorf("event got %q, want %q", got, want)
+				}
+				next <- struct{}{}
+			}
+			if _, err := io.ReadAll(resp.Body); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_ByteCounter(t *testing.T) {
+	data := bytes.Repeat([]byte("foobarbaz"), 10000)
+	body := gzipBytes(data)
+	tt := []struct {
+		title   string
+		dr      decompress.RoundTripper
+		writeTo bool
+	}{
+		{title: "streamed"},
+		{title: "WriteTo", writeTo: true},
+		{title: "pipelined", dr: decompress.RoundTripper{PipelineDepth: 2}},
+		{title: "buffered", dr: decompress.RoundTripper{BufferLimit: 1 << 20}},
+		{title: "adaptive streamed", dr: decompress.RoundTripper{AdaptiveBufferLimit: 1024}},
+		{title: "spilled", dr: decompress.RoundTripper{SpillThreshold: 1024, SpillDir: t.TempDir()}},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := te.dr
+			dr.Wrap = &stubRoundTripper{response: newResponse(t, body, "gzip")}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, ok := resp.Body.(decompress.ByteCounter)
+			if !ok {
+				t.Fatalf("got %T, want ByteCounter", resp.Body)
+			}
+			if te.writeTo {
+				_, err = resp.Body.(io.WriterTo).WriteTo(io.Discard)
+			} else {
+				_, err = io.Copy(io.Discard, struct{ io.Reader }{resp.Body})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := c.CompressedBytesRead(), int64(len(body)); got != want {
+				t.Errorf("CompressedBytesRead got %v, want %v", got, want)
+			}
+			if got, want := c.DecompressedBytesWritten(), int64(len(data)); got != want {
+				t.Errorf("DecompressedBytesWritten got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRatio(t *testing.T) {
+	data := bytes.Repeat([]byte("foobarbaz"), 1000)
+	tt := []struct {
+		title     string
+		resp      *http.Response
+		read      bool
+		wantRatio float64
+		wantOK    bool
+	}{
+		{title: "read", resp: newResponse(t, gzipBytes(data), "gzip"), read: true, wantRatio: float64(len(data)) / float64(len(gzipBytes(data))), wantOK: true},
+		{title: "not read", resp: newResponse(t, gzipBytes(data), "gzip")},
+		{title: "not compressed", resp: newResponse(t, data, ""), read: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: te.resp}}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if te.read {
+				io.Copy(io.Discard, resp.Body)
+			}
+			ratio, ok := decompress.Ratio(resp)
+			if got, want := ok, te.wantOK; got != want {
+				t.Errorf("ok got %v, want %v", got, want)
+			}
+			if got, want := ratio, te.wantRatio; got != want {
+				t.Errorf("ratio got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// teeBuffer is a bytes.Buffer that records Close, and fails the writes after limit bytes if limit > 0
+type teeBuffer struct {
+	bytes.Buffer
+	limit  int
+	closed bool
+}
+
+func (b *teeBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.Len()+len(p) > b.limit {
+		return 0, errors.New("write failed")
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *teeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRoundTripper_RoundTrip_TeeCompressed(t *testing.T) {
+	gz := gzipBytes([]byte("foobarbaz"))
+	tt := []struct {
+		title    string
+		body     []byte
+		skip     bool
+		limit    int
+		wantBody string
+		wantTee  []byte
+		wantErr  bool
+	}{
+		{title: "teed", body: gz, wantBody: "foobarbaz", wantTee: gz},
+		{title: "corrupted", body: gz[:20], wantTee: gz[:20], wantErr: true},
+		{title: "skipped", body: gz, skip: true, wantBody: "foobarbaz"},
+		{title: "write failed", body: gz, limit: 1, wantBody: "foobarbaz"},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			tee := &teeBuffer{limit: te.limit}
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: newResponse(t, te.body, "gzip")},
+				TeeCompressed: func(res *http.Response) io.Writer {
+					if te.skip {
+						return nil
+					}
+					return tee
+				},
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if got, want := err != nil, te.wantErr; got != want {
+				t.Errorf("err got %v, want error %v", err, want)
+			}
+			if got, want := string(b), te.wantBody; !te.wantErr && got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+			resp.Body.Close()
+			if got, want := tee.Bytes(), te.wantTee; !bytes.Equal(got, want) {
+				t.Errorf("teed got %x, want %x", got, want)
+			}
+			if got, want := tee.closed, !te.skip; got != want {
+				t.Errorf("closed got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_Callbacks(t *testing.T) {
+	tt := []struct {
+		title         string
+		resp          *http.Response
+		wantEncodings []string
+		wantErrors    int
+	}{
+		{title: "decoded", resp: newResponse(t, gzipBytes(deflateBytes([]byte("foobarbaz"))), "deflate, gzip"), wantEncodings: []string{"deflate", "gzip"}},
+		{title: "unsupported", resp: newResponse(t, []byte("foobarbaz"), "x-unknown"), wantErrors: 1},
+		{title: "corrupted", resp: newResponse(t, gzipBytes([]byte("foobarbaz"))[:20], "gzip"), wantEncodings: []string{"gzip"}, wantErrors: 1},
+		{title: "not compressed", resp: newResponse(t, []byte("foobarbaz"), "")},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			var gotEncodings []string
+			var gotErrors int
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: te.resp},
+				OnDecode: func(r *http.Request, res *http.Response, encodings []string) {
+					if r != req {
+						t.Error("OnDecode got the other request")
+					}
+					if res.Header.Get("Content-Encoding") != "" {
+						t.Error("OnDecode got the response not rewritten")
+					}
+					gotEncodings = encodings
+				},
+				OnError: func(r *http.Request, err error) {
+					if r != req {
+						t.Error("OnError got the other request")
+					}
+					if err == nil {
+						t.Error("OnError got nil error")
+					}
+					gotErrors++
+				},
+			}
+			if resp, err := dr.RoundTrip(req); err == nil {
+				io.Copy(io.Discard, resp.Body)
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			if got, want := gotEncodings, te.wantEncodings; !reflect.DeepEqual(got, want) {
+				t.Errorf("encodings got %v, want %v", got, want)
+			}
+			if got, want := gotErrors, te.wantErrors; got != want {
+				t.Errorf("errors got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_CloseIdleConnections(t *testing.T) {
+	w := &closeIdleRoundTripper{}
+	cli := http.Client{Transport: &decompress.RoundTripper{Wrap: w}}
+	cli.CloseIdleConnections()
+	if got, want := w.closed, 1; got != want {
+		t.Errorf("CloseIdleConnections called %v times, want %v", got, want)
+	}
+	// not supported by the wrapped RoundTripper
+	dr := decompress.RoundTripper{Wrap: &stubRoundTripper{}}
+	dr.CloseIdleConnections()
+}
+
+type closeIdleRoundTripper struct {
+	stubRoundTripper
+	closed int
+}
+
+func (c *closeIdleRoundTripper) CloseIdleConnections() {
+	c.closed++
+}
+
+func newResponse(t *testing.T, body []byte, contentEncoding string) *http.Response {
+	t.Helper()
+	h := http.Header{}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if contentEncoding != "" {
+		h.Set("Content-Encoding", contentEncoding)
+	}
+	resp := &http.Response{
+		Status:           http.StatusText(200),
+		StatusCode:       200,
+		Proto:            "HTTP/1.1",
+		ProtoMajor:       1,
+		ProtoMinor:       1,
+		Header:           h,
+		Body:             io.NopCloser(bytes.NewBuffer(body)),
+		ContentLength:    int64(len(body)),
+		TransferEncoding: nil,
+		Close:            false,
+		Uncompressed:     false,
+		Trailer:          nil,
+		Request:          nil,
+		TLS:              nil,
+	}
+	return resp
+}
+
+func copyAndReadAll(t *testing.T, resp *http.Response) []byte {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	cp := bytes.NewBuffer(b)
+	resp.Body = io.NopCloser(cp)
+	return cp.Bytes()
+}
+
+func gzipBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := gzip.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func deflateBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w, err := flate.NewWriter(&dst, flate.DefaultCompression)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func zlibBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := zlib.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func brotliBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := brotli.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func zstdBytes(b []byte, opts ...zstd.EOption) []byte {
+	var dst bytes.Buffer
+	w, err := zstd.NewWriter(&dst, opts...)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func xzBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w, err := xz.NewWriter(&dst)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func lzmaBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w, err := lzma.NewWriter(&dst)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func lz4Bytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := lz4.NewWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+func snappyBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := snappy.NewBufferedWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
+
+// bzip2Foobarbaz is "foobarbaz" compressed by bzip2, since the standard library has no bzip2 writer
+var bzip2Foobarbaz = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xf5, 0xf3, 0xd5, 0x7f, 0x00, 0x00,
+	0x02, 0x01, 0x80, 0x31, 0x00, 0x90, 0x10, 0x20, 0x00, 0x21, 0xa6, 0x99, 0xa0, 0xc0, 0x1a, 0x94,
+	0xf0, 0x58, 0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x43, 0xd7, 0xcf, 0x55, 0xfc,
 }
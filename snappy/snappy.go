@@ -0,0 +1,41 @@
+// Package snappy provides the decoder for the `snappy` content coding (snappy framing format)
+// using github.com/klauspost/compress/snappy.
+// Importing the package registers the decoder by decompress.RegisterDecoder:
+//
+//	import _ "github.com/kei2100/decompress-roundtripper/snappy"
+package snappy
+
+import (
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/klauspost/compress/snappy"
+)
+
+func init() {
+	decompress.RegisterDecoder("snappy", NewFactory())
+}
+
+// NewFactory returns the factory of the `snappy` decoders
+func NewFactory() decompress.DecoderFactory {
+	return factory{}
+}
+
+type factory struct{}
+
+func (factory) NewDecoder(r io.Reader) (decompress.Decoder, error) {
+	return &decoder{Reader: snappy.NewReader(r)}, nil
+}
+
+type decoder struct {
+	*snappy.Reader
+}
+
+func (d *decoder) Reset(r io.Reader) error {
+	d.Reader.Reset(r)
+	return nil
+}
+
+func (d *decoder) Close() error {
+	return nil
+}
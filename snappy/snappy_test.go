@@ -0,0 +1,31 @@
+package snappy_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper/codectest"
+	dsnappy "github.com/kei2100/decompress-roundtripper/snappy"
+	"github.com/klauspost/compress/snappy"
+)
+
+func TestNewFactory_Conformance(t *testing.T) {
+	codectest.Suite{
+		Factory: dsnappy.NewFactory(),
+		Encode: func(b []byte) ([]byte, error) {
+			return snappyBytes(b), nil
+		},
+	}.Run(t)
+}
+
+func snappyBytes(b []byte) []byte {
+	var dst bytes.Buffer
+	w := snappy.NewBufferedWriter(&dst)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}
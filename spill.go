@@ -0,0 +1,78 @@
+package decompress
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+// spill buffers the body of res in the temporary file of SpillDir, following head already read into the buffer of pool.
+// The total size is limited by limit bytes if it is positive
+func (r *RoundTripper) spill(res *http.Response, head []byte, pool BufferPool, limit int64) (err error) {
+	f, err := os.CreateTemp(r.SpillDir, "decompress-*")
+	if err != nil {
+		pool.Put(head)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	_, err = f.Write(head)
+	size := int64(len(head))
+	pool.Put(head)
+	if err != nil {
+		return err
+	}
+	var src io.Reader = res.Body
+	if limit > 0 {
+		src = io.LimitReader(res.Body, limit-size+1)
+	}
+	n, err := io.Copy(f, src)
+	if err != nil {
+		return err
+	}
+	size += n
+	if limit > 0 && size > limit {
+		return &ErrTooLarge{Limit: limit}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	res.Body = &spilledBody{f: f, ByteCounter: counter(res.Body)}
+	setBufferedLength(res, size)
+	return nil
+}
+
+// spilledBody is the body buffered in the temporary file, that is removed on Close.
+// It implements io.Seeker and io.ReaderAt for the consumers requiring the random access
+type spilledBody struct {
+	f *os.File
+	ByteCounter
+}
+
+func (b *spilledBody) Read(p []byte) (int, error) {
+	return b.f.Read(p)
+}
+
+func (b *spilledBody) ReadAt(p []byte, off int64) (int, error) {
+	return b.f.ReadAt(p, off)
+}
+
+func (b *spilledBody) Seek(offset int64, whence int) (int64, error) {
+	return b.f.Seek(offset, whence)
+}
+
+func (b *spilledBody) Close() error {
+	err := b.f.Close()
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	if rerr := os.Remove(b.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
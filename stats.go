@@ -0,0 +1,149 @@
+package decompress
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stats is the snapshot of the decompression activity of a RoundTripper
+type Stats struct {
+	// Decoded is the number of the responses decoded per content coding.
+	// A response of the chained content codings is counted for each coding
+	Decoded map[string]int64
+	// PassThroughs is the number of the compressed responses returned without decompression,
+	// e.g. by PassThroughUnsupported, SkipContentTypes or BudgetPassThrough
+	PassThroughs int64
+	// Errors is the number of the responses failed to be decompressed, by RoundTrip or reading the body
+	Errors int64
+	// CompressedBytes is the bytes of the compressed bodies read from the wire, counted when the bodies are closed
+	CompressedBytes int64
+	// DecompressedBytes is the bytes of the decompressed bodies produced, counted when the bodies are closed
+	DecompressedBytes int64
+	// Encodings is the breakdown of the decoded responses per combination of the content codings, keyed by the codings
+	// joined by ", " in the order of the Content-Encoding header, e.g. "gzip", "br" or "deflate, gzip"
+	Encodings map[string]EncodingStats
+	// Decoders is the activity of the decoders per content coding, e.g. to verify the effectiveness of the pooling of
+	// NewPooledFactory and to detect the leaks of the bodies not closed
+	Decoders map[string]DecoderStats
+}
+
+// EncodingStats is the activity of the responses of a combination of the content codings
+type EncodingStats struct {
+	// Responses is the number of the responses decoded
+	Responses int64
+	// Errors is the number of the responses failed to be decompressed after the decoding started
+	Errors int64
+	// CompressedBytes is the bytes of the compressed bodies read from the wire, counted when the bodies are closed
+	CompressedBytes int64
+	// DecompressedBytes is the bytes of the decompressed bodies produced, counted when the bodies are closed
+	DecompressedBytes int64
+}
+
+// DecoderStats is the activity of the decoders of a content coding
+type DecoderStats struct {
+	// Live is the number of the decoders created and not closed yet
+	Live int64
+	// PoolHits is the number of the decoders reused from the pool of NewPooledFactory
+	PoolHits int64
+	// PoolMisses is the number of the decoders newly created by the factory of NewPooledFactory as the pool is empty
+	PoolMisses int64
+}
+
+// stats is the counters of a RoundTripper, that is safe for concurrent use
+type stats struct {
+	decoded           sync.Map // map[string]*atomic.Int64
+	passThroughs      atomic.Int64
+	errors            atomic.Int64
+	compressedBytes   atomic.Int64
+	decompressedBytes atomic.Int64
+	encodings         sync.Map // map[string]*encodingCounters
+	decoders          sync.Map // map[string]*decoderCounters
+}
+
+// encodingCounters is the counters of a combination of the content codings
+type encodingCounters struct {
+	responses         atomic.Int64
+	errors            atomic.Int64
+	compressedBytes   atomic.Int64
+	decompressedBytes atomic.Int64
+}
+
+// decoderCounters is the counters of the decoders of a content coding
+type decoderCounters struct {
+	live       atomic.Int64
+	poolHits   atomic.Int64
+	poolMisses atomic.Int64
+}
+
+// Stats returns the snapshot of the decompression activity of r. It is safe to be called concurrently with RoundTrip
+func (r *RoundTripper) Stats() Stats {
+	st := Stats{Decoded: make(map[string]int64), Encodings: make(map[string]EncodingStats), Decoders: make(map[string]DecoderStats)}
+	s, _ := r.stats.Load().(*stats)
+	if s == nil {
+		return st
+	}
+	s.decoded.Range(func(k, v any) bool {
+		st.Decoded[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	s.encodings.Range(func(k, v any) bool {
+		c := v.(*encodingCounters)
+		st.Encodings[k.(string)] = EncodingStats{
+			Responses:         c.responses.Load(),
+			Errors:            c.errors.Load(),
+			CompressedBytes:   c.compressedBytes.Load(),
+			DecompressedBytes: c.decompressedBytes.Load(),
+		}
+		return true
+	})
+	s.decoders.Range(func(k, v any) bool {
+		c := v.(*decoderCounters)
+		st.Decoders[k.(string)] = DecoderStats{
+			Live:       c.live.Load(),
+			PoolHits:   c.poolHits.Load(),
+			PoolMisses: c.poolMisses.Load(),
+		}
+		return true
+	})
+	st.PassThroughs = s.passThroughs.Load()
+	st.Errors = s.errors.Load()
+	st.CompressedBytes = s.compressedBytes.Load()
+	st.DecompressedBytes = s.decompressedBytes.Load()
+	return st
+}
+
+// counters returns the counters of r, that are created at the first use
+func (r *RoundTripper) counters() *stats {
+	if s, ok := r.stats.Load().(*stats); ok {
+		return s
+	}
+	r.stats.CompareAndSwap(nil, &stats{})
+	return r.stats.Load().(*stats)
+}
+
+// addDecoded counts a response decoded by the content coding
+func (s *stats) addDecoded(encoding string) {
+	v, ok := s.decoded.Load(encoding)
+	if !ok {
+		v, _ = s.decoded.LoadOrStore(encoding, new(atomic.Int64))
+	}
+	v.(*atomic.Int64).Add(1)
+}
+
+// encoding returns the counters of the combination of the content codings label
+func (s *stats) encoding(label string) *encodingCounters {
+	v, ok := s.encodings.Load(label)
+	if !ok {
+		v, _ = s.encodings.LoadOrStore(label, new(encodingCounters))
+	}
+	return v.(*encodingCounters)
+}
+
+// decoder returns the counters of the decoders of the content coding
+func (s *stats) decoder(encoding string) *decoderCounters {
+	v, ok := s.decoders.Load(encoding)
+	if !ok {
+		v, _ = s.decoders.LoadOrStore(encoding, new(decoderCounters))
+	}
+	return v.(*decoderCounters)
+}
@@ -0,0 +1,187 @@
+package decompress_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_Stats(t *testing.T) {
+	dr := &decompress.RoundTripper{
+		PassThroughUnsupported: true,
+		SkipContentTypes:       []string{"image/*"},
+		BufferLimit:            100,
+	}
+	skipped := newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")
+	skipped.Header.Set("Content-Type", "image/png")
+	large := make([]byte, 200)
+	gz, chained := gzipBytes([]byte("foobarbaz")), gzipBytes(deflateBytes([]byte("foobarbaz")))
+	responses := []*http.Response{
+		newResponse(t, gz, "gzip"),
+		newResponse(t, chained, "deflate, gzip"),
+		newResponse(t, []byte("foobarbaz"), ""),
+		newResponse(t, []byte("foobarbaz"), "x-unknown"),
+		skipped,
+		newResponse(t, []byte("foobarbaz"), "gzip"),
+		newResponse(t, gzipBytes(large), "gzip"),
+	}
+	for _, res := range responses {
+		dr.Wrap = &stubRoundTripper{response: res}
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp, err := dr.RoundTrip(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	got := dr.Stats()
+	// the decoders reused depend on sync.Pool
+	for k, want := range map[string]int64{"gzip": 3, "deflate": 1} {
+		d := got.Decoders[k]
+		if got, want := d.PoolHits+d.PoolMisses, want; got != want {
+			t.Errorf("%s decoders got %v, want %v", k, got, want)
+		}
+		if got, want := d.Live, int64(0); got != want {
+			t.Errorf("%s live decoders got %v, want %v", k, got, want)
+		}
+	}
+	got.Decoders = nil
+	gzipCompressed := int64(len(gz) + 9 + len(gzipBytes(large)))
+	want := decompress.Stats{
+		Decoded:           map[string]int64{"gzip": 4, "deflate": 1},
+		PassThroughs:      2,
+		Errors:            2,
+		CompressedBytes:   gzipCompressed + int64(len(chained)),
+		DecompressedBytes: 9 + 9 + 101,
+		Encodings: map[string]decompress.EncodingStats{
+			"gzip":          {Responses: 3, Errors: 2, CompressedBytes: gzipCompressed, DecompressedBytes: 9 + 101},
+			"deflate, gzip": {Responses: 1, CompressedBytes: int64(len(chained)), DecompressedBytes: 9},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRoundTripper_Stats_Concurrent(t *testing.T) {
+	body := gzipBytes([]byte("foobarbaz"))
+	dr := &decompress.RoundTripper{
+		Wrap: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return newResponse(t, body, "gzip"), nil
+		}),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			dr.Stats()
+		}()
+	}
+	wg.Wait()
+	if got, want := dr.Stats().Decoded["gzip"], int64(10); got != want {
+		t.Errorf("Decoded got %v, want %v", got, want)
+	}
+	if got, want := dr.Stats().DecompressedBytes, int64(90); got != want {
+		t.Errorf("DecompressedBytes got %v, want %v", got, want)
+	}
+}
+
+func TestRoundTripper_Stats_Decoders(t *testing.T) {
+	dr := &decompress.RoundTripper{
+		Decoders: map[string]decompress.DecoderFactory{"x-rot13": decompress.DecoderFunc(newROT13Reader)},
+	}
+	roundTrip := func(t *testing.T, body []byte, contentEncoding string) *http.Response {
+		t.Helper()
+		dr.Wrap = &stubRoundTripper{response: newResponse(t, body, contentEncoding)}
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp, err := dr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	const n = 10
+	for i := 0; i < n; i++ {
+		resp := roundTrip(t, gzipBytes([]byte("foobarbaz")), "gzip")
+		if got, want := dr.Stats().Decoders["gzip"].Live, int64(1); got != want {
+			t.Errorf("live decoders before Close got %v, want %v", got, want)
+		}
+		resp.Body.Close()
+	}
+	roundTrip(t, rot13([]byte("foobarbaz")), "x-rot13").Body.Close()
+	leaked := roundTrip(t, gzipBytes([]byte("foobarbaz")), "gzip")
+
+	got := dr.Stats().Decoders
+	if got, want := got["gzip"].Live, int64(1); got != want {
+		t.Errorf("gzip live decoders got %v, want %v", got, want)
+	}
+	if got, want := got["gzip"].PoolHits+got["gzip"].PoolMisses, int64(n+1); got != want {
+		t.Errorf("gzip decoders got %v, want %v", got, want)
+	}
+	// sync.Pool may drop the decoders, e.g. by GC
+	if got["gzip"].PoolHits == 0 {
+		t.Error("gzip pool hits got 0, want more")
+	}
+	if got, want := got["x-rot13"], (decompress.DecoderStats{}); got != want {
+		t.Errorf("x-rot13 got %+v, want %+v", got, want)
+	}
+	leaked.Body.Close()
+	if got, want := dr.Stats().Decoders["gzip"].Live, int64(0); got != want {
+		t.Errorf("gzip live decoders after Close got %v, want %v", got, want)
+	}
+}
+
+func TestRoundTripper_PublishExpvar(t *testing.T) {
+	dr := &decompress.RoundTripper{
+		Wrap: &stubRoundTripper{response: newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")},
+	}
+	// unique per run, since the variables cannot be unpublished
+	name := fmt.Sprintf("decompress_test_stats_%d", time.Now().UnixNano())
+	dr.PublishExpvar(name)
+	req, _ := http.NewRequest("GET", "/", nil)
+	resp, err := dr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatal("expvar got nil, want the published variable")
+	}
+	var got decompress.Stats
+	if err := json.Unmarshal([]byte(v.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := dr.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("publishing the same name got no panic, want panic")
+		}
+	}()
+	dr.PublishExpvar(name)
+}
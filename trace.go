@@ -0,0 +1,107 @@
+package decompress
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// DecodeTrace is a set of hooks to run at the stages of the decompression of a response, like httptrace.ClientTrace.
+// It is attached to the RoundTripper by RoundTripper.Trace, or to a request by WithDecodeTrace.
+// Any of the hooks may be nil. The hooks of a response are called by the goroutine reading the body, except that
+// DecoderCreated may be called by the background goroutine if RoundTripper.PipelineDepth is set
+type DecodeTrace struct {
+	// DecoderCreated is called when the decoder of the content coding is created at the first Read of the body,
+	// with the error if the decoder failed to read the stream header. It is called for each of the chained codings
+	DecoderCreated func(encoding string, err error)
+	// GotFirstDecompressedByte is called when the first decompressed byte is returned to the reader of the body
+	GotFirstDecompressedByte func()
+	// DecodeDone is called when the body is closed
+	DecodeDone func(DecodeDoneInfo)
+}
+
+// DecodeDoneInfo is the argument to DecodeTrace.DecodeDone
+type DecodeDoneInfo struct {
+	// Encoding is the decoded content codings joined by ", ", e.g. "gzip" or "deflate, gzip"
+	Encoding string
+	// Compressed is the bytes of the compressed body read from the wire
+	Compressed int64
+	// Decompressed is the bytes of the decompressed body produced
+	Decompressed int64
+	// Duration is the time since RoundTrip started decoding the response until the body is closed
+	Duration time.Duration
+	// NetworkTime is the time spent waiting for the compressed body from the wire
+	NetworkTime time.Duration
+	// DecodeTime is the time spent in the Reads of the body except NetworkTime, that is the time of the decoders.
+	// It is not measured if the body is decoded in the background, i.e. RoundTripper.PipelineDepth is set
+	DecodeTime time.Duration
+	// Err is the error of reading the body, or nil if the body is read successfully or closed before the end
+	Err error
+}
+
+type decodeTraceKey struct{}
+
+// WithDecodeTrace returns the context attaching trace to the requests with the context.
+// The trace is called in addition to RoundTripper.Trace
+func WithDecodeTrace(ctx context.Context, trace *DecodeTrace) context.Context {
+	return context.WithValue(ctx, decodeTraceKey{}, trace)
+}
+
+// ContextDecodeTrace returns the DecodeTrace attached to ctx by WithDecodeTrace, or nil if none
+func ContextDecodeTrace(ctx context.Context) *DecodeTrace {
+	t, _ := ctx.Value(decodeTraceKey{}).(*DecodeTrace)
+	return t
+}
+
+// decodeTrace returns the trace of the request of ctx, that combines RoundTripper.Trace and the trace of the context
+func (r *RoundTripper) decodeTrace(ctx context.Context) *DecodeTrace {
+	t := ContextDecodeTrace(ctx)
+	switch {
+	case t == nil:
+		return r.Trace
+	case r.Trace == nil:
+		return t
+	}
+	return composeTrace(t, r.Trace)
+}
+
+// composeTrace returns the trace calling the hooks of a and b in order
+func composeTrace(a, b *DecodeTrace) *DecodeTrace {
+	return &DecodeTrace{
+		DecoderCreated: func(encoding string, err error) {
+			if a.DecoderCreated != nil {
+				a.DecoderCreated(encoding, err)
+			}
+			if b.DecoderCreated != nil {
+				b.DecoderCreated(encoding, err)
+			}
+		},
+		GotFirstDecompressedByte: func() {
+			if a.GotFirstDecompressedByte != nil {
+				a.GotFirstDecompressedByte()
+			}
+			if b.GotFirstDecompressedByte != nil {
+				b.GotFirstDecompressedByte()
+			}
+		},
+		DecodeDone: func(info DecodeDoneInfo) {
+			if a.DecodeDone != nil {
+				a.DecodeDone(info)
+			}
+			if b.DecodeDone != nil {
+				b.DecodeDone(info)
+			}
+		},
+	}
+}
+
+// traceWriter reports the first write of the decompressed bytes by WriteTo of the body
+type traceWriter struct {
+	io.Writer
+	b *decodedBody
+}
+
+func (w *traceWriter) Write(p []byte) (int, error) {
+	w.b.gotBytes(len(p))
+	return w.Writer.Write(p)
+}
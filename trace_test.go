@@ -0,0 +1,124 @@
+package decompress_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// newRecordingTrace returns the DecodeTrace recording the hooks called with the prefix
+func newRecordingTrace(prefix string, events *[]string, done *decompress.DecodeDoneInfo) *decompress.DecodeTrace {
+	return &decompress.DecodeTrace{
+		DecoderCreated: func(encoding string, err error) {
+			*events = append(*events, fmt.Sprintf("%s created %s %v", prefix, encoding, err != nil))
+		},
+		GotFirstDecompressedByte: func() {
+			*events = append(*events, prefix+" first byte")
+		},
+		DecodeDone: func(info decompress.DecodeDoneInfo) {
+			*events = append(*events, prefix+" done")
+			*done = info
+		},
+	}
+}
+
+func TestRoundTripper_RoundTrip_Trace(t *testing.T) {
+	tt := []struct {
+		title            string
+		resp             *http.Response
+		requestTrace     bool
+		writeTo          bool
+		wantEvents       []string
+		wantDecompressed int64
+		wantErr          bool
+	}{
+		{
+			title:            "decoded",
+			resp:             newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			wantEvents:       []string{"rt created gzip false", "rt first byte", "rt done"},
+			wantDecompressed: 9,
+		},
+		{
+			title:            "chained",
+			resp:             newResponse(t, gzipBytes(deflateBytes([]byte("foobarbaz"))), "deflate, gzip"),
+			wantEvents:       []string{"rt created gzip false", "rt created deflate false", "rt first byte", "rt done"},
+			wantDecompressed: 9,
+		},
+		{
+			title:            "WriteTo",
+			resp:             newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			writeTo:          true,
+			wantEvents:       []string{"rt created gzip false", "rt first byte", "rt done"},
+			wantDecompressed: 9,
+		},
+		{
+			title:            "request trace",
+			resp:             newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip"),
+			requestTrace:     true,
+			wantEvents:       []string{"req created gzip false", "rt created gzip false", "req first byte", "rt first byte", "req done", "rt done"},
+			wantDecompressed: 9,
+		},
+		{
+			title:      "invalid header",
+			resp:       newResponse(t, []byte("foobarbaz"), "gzip"),
+			wantEvents: []string{"rt created gzip true", "rt done"},
+			wantErr:    true,
+		},
+		{
+			title:      "empty",
+			resp:       newResponse(t, nil, "gzip"),
+			wantEvents: []string{"rt done"},
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			var events []string
+			var done decompress.DecodeDoneInfo
+			dr := decompress.RoundTripper{
+				Wrap:  &stubRoundTripper{response: te.resp},
+				Trace: newRecordingTrace("rt", &events, &done),
+			}
+			ctx := context.Background()
+			if te.requestTrace {
+				var reqDone decompress.DecodeDoneInfo
+				ctx = decompress.WithDecodeTrace(ctx, newRecordingTrace("req", &events, &reqDone))
+			}
+			compressed := te.resp.ContentLength
+			req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if te.writeTo {
+				_, err = resp.Body.(io.WriterTo).WriteTo(&bytes.Buffer{})
+			} else {
+				_, err = io.ReadAll(resp.Body)
+			}
+			if got, want := err != nil, te.wantErr; got != want {
+				t.Errorf("err got %v, want error %v", err, want)
+			}
+			resp.Body.Close()
+			if got, want := events, te.wantEvents; !reflect.DeepEqual(got, want) {
+				t.Errorf("events got %q, want %q", got, want)
+			}
+			if got, want := done.Decompressed, te.wantDecompressed; got != want {
+				t.Errorf("Decompressed got %v, want %v", got, want)
+			}
+			if got, want := done.Compressed, compressed; got != want {
+				t.Errorf("Compressed got %v, want %v", got, want)
+			}
+			if got, want := done.Err != nil, te.wantErr; got != want {
+				t.Errorf("Err got %v, want error %v", done.Err, want)
+			}
+			if done.Duration <= 0 || done.DecodeTime < 0 || done.NetworkTime < 0 {
+				t.Errorf("times got %+v", done)
+			}
+		})
+	}
+}
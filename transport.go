@@ -0,0 +1,34 @@
+package decompress
+
+import "net/http"
+
+// Transport is the http.Transport that decompresses the response bodies, so that the connections (proxy, TLS, timeouts)
+// and the decompression can be configured in a single layer.
+// The methods of the embedded http.Transport such as CloseIdleConnections are available as they are
+type Transport struct {
+	*http.Transport
+	// Decompress is the configuration of the decompression. Decompress.Wrap is ignored.
+	// If Decompress is nil, the zero RoundTripper is used
+	Decompress *RoundTripper
+}
+
+// NewTransport returns the Transport that sends the requests by base, and decompresses the responses as configured by
+// the options. If base is nil, a clone of http.DefaultTransport is used
+func NewTransport(base *http.Transport, opts ...Option) *Transport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	return &Transport{Transport: base, Decompress: New(opts...)}
+}
+
+// RoundTrip implements the RoundTrip method of the http.RoundTripper. See RoundTripper.RoundTrip
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d := t.Decompress
+	if d == nil {
+		d = &RoundTripper{}
+	}
+	if t.Transport == nil {
+		return d.roundTrip(nil, req)
+	}
+	return d.roundTrip(t.Transport, req)
+}
@@ -0,0 +1,49 @@
+package decompress_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestTransport(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Encoding", "x-rot13-local")
+		w.Write(rot13([]byte("foobarbaz")))
+	}))
+	defer svr.Close()
+
+	base := &http.Transport{ResponseHeaderTimeout: time.Minute}
+	tr := decompress.NewTransport(base, decompress.WithDecoder("x-rot13-local", decompress.DecoderFunc(newROT13Reader)))
+	defer tr.CloseIdleConnections()
+	if got, want := tr.ResponseHeaderTimeout, time.Minute; got != want {
+		t.Errorf("ResponseHeaderTimeout got %v, want %v", got, want)
+	}
+	cli := http.Client{Transport: tr}
+	resp, err := cli.Get(svr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "foobarbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+	if got, want := resp.Uncompressed, true; got != want {
+		t.Errorf("Uncompressed got %v, want %v", got, want)
+	}
+}
+
+func TestNewTransport_NilBase(t *testing.T) {
+	tr := decompress.NewTransport(nil)
+	if tr.Transport == nil || tr.Transport == http.DefaultTransport {
+		t.Error("got nil or http.DefaultTransport, want a clone of http.DefaultTransport")
+	}
+}
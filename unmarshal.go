@@ -0,0 +1,140 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DecodeJSON decompresses the body of res if it is still compressed, decodes the JSON value of the body into v,
+// and closes the body
+func DecodeJSON(res *http.Response, v any) error {
+	return decodeBody(res, func(r io.Reader) error {
+		return unmarshalJSON(r, v)
+	})
+}
+
+// DecodeXML decompresses the body of res if it is still compressed, decodes the XML value of the body into v,
+// and closes the body. See DecodeJSON
+func DecodeXML(res *http.Response, v any) error {
+	return decodeBody(res, func(r io.Reader) error {
+		return unmarshalXML(r, v)
+	})
+}
+
+// UnmarshalFunc decodes the value of the body r into v, that is a pointer to the value
+type UnmarshalFunc func(r io.Reader, v any) error
+
+var unmarshalersMu sync.RWMutex
+
+// unmarshalers is the registered UnmarshalFunc keyed by the media type
+var unmarshalers = map[string]UnmarshalFunc{
+	"application/json":                  unmarshalJSON,
+	"application/xml":                   unmarshalXML,
+	"text/xml":                          unmarshalXML,
+	"application/x-www-form-urlencoded": unmarshalForm,
+}
+
+// RegisterUnmarshaler registers the UnmarshalFunc for the media type, e.g. `application/msgpack`, used by DecodeInto.
+// The media type is case-insensitive. RegisterUnmarshaler is safe for concurrent use.
+// If fn is nil, RegisterUnmarshaler panics
+func RegisterUnmarshaler(mediaType string, fn UnmarshalFunc) {
+	if fn == nil {
+		panic(fmt.Sprintf("decompress: RegisterUnmarshaler unmarshaler is nil for %s", mediaType))
+	}
+	unmarshalersMu.Lock()
+	defer unmarshalersMu.Unlock()
+	unmarshalers[strings.ToLower(mediaType)] = fn
+}
+
+// DecodeInto decompresses the body of res if it is still compressed, decodes the value of the body according to
+// the Content-Type header, and closes the body.
+// JSON, XML and the URL-encoded form (into url.Values) are supported, in addition to the media types registered by
+// RegisterUnmarshaler. The media types with the structured syntax suffixes `+json` and `+xml` are decoded as JSON and XML
+func DecodeInto[T any](res *http.Response) (T, error) {
+	var v T
+	ct := res.Header.Get("Content-Type")
+	fn, ok := unmarshaler(parseMediaType(ct))
+	if !ok {
+		res.Body.Close()
+		return v, fmt.Errorf("decompress: unsupported media type `%s`", ct)
+	}
+	err := decodeBody(res, func(r io.Reader) error {
+		return fn(r, &v)
+	})
+	return v, err
+}
+
+// unmarshaler returns the UnmarshalFunc for the media type
+func unmarshaler(mediaType string) (UnmarshalFunc, bool) {
+	unmarshalersMu.RLock()
+	fn, ok := unmarshalers[mediaType]
+	unmarshalersMu.RUnlock()
+	if ok {
+		return fn, true
+	}
+	// structured syntax suffixes. Refs RFC 6839
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		return unmarshalJSON, true
+	case strings.HasSuffix(mediaType, "+xml"):
+		return unmarshalXML, true
+	}
+	return nil, false
+}
+
+func unmarshalJSON(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+func unmarshalXML(r io.Reader, v any) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+func unmarshalForm(r io.Reader, v any) error {
+	buf := bytes.NewBuffer(defaultBufferPool.Get()[:0])
+	defer func() {
+		defaultBufferPool.Put(buf.Bytes())
+	}()
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(buf.String())
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case *url.Values:
+		*v = values
+	case *map[string][]string:
+		*v = values
+	default:
+		return fmt.Errorf("decompress: can not decode form into %T", v)
+	}
+	return nil
+}
+
+// decodeBody decompresses the body of res by DecodeResponse, decodes it by decode, and closes it.
+// The errors of the compressed body are wrapped with the content codings
+func decodeBody(res *http.Response, decode func(r io.Reader) error) error {
+	defer func() {
+		res.Body.Close()
+	}()
+	encoding := strings.Join(res.Header.Values("Content-Encoding"), ", ")
+	if err := DecodeResponse(res); err != nil {
+		return err
+	}
+	if err := decode(res.Body); err != nil {
+		if encoding != "" {
+			return fmt.Errorf("decompress: decode `%s` body: %w", encoding, err)
+		}
+		return err
+	}
+	return nil
+}
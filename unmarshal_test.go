@@ -0,0 +1,237 @@
+package decompress_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type value struct {
+		Foo string `json:"foo"`
+	}
+	tt := []struct {
+		title   string
+		resp    func(t *testing.T) *http.Response
+		want    value
+		wantErr bool
+	}{
+		{
+			title: "compressed",
+			resp: func(t *testing.T) *http.Response {
+				return newResponse(t, gzipBytes([]byte(`{"foo":"bar"}`)), "gzip")
+			},
+			want: value{Foo: "bar"},
+		},
+		{
+			title: "decompressed by RoundTripper",
+			resp: func(t *testing.T) *http.Response {
+				dr := decompress.RoundTripper{Wrap: &stubRoundTripper{response: newResponse(t, gzipBytes([]byte(`{"foo":"bar"}`)), "gzip")}}
+				req, _ := http.NewRequest("GET", "/", nil)
+				resp, err := dr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp
+			},
+			want: value{Foo: "bar"},
+		},
+		{
+			title: "not compressed",
+			resp: func(t *testing.T) *http.Response {
+				return newResponse(t, []byte(`{"foo":"bar"}`), "")
+			},
+			want: value{Foo: "bar"},
+		},
+		{
+			title: "invalid JSON",
+			resp: func(t *testing.T) *http.Response {
+				return newResponse(t, gzipBytes([]byte(`{"foo":`)), "gzip")
+			},
+			wantErr: true,
+		},
+		{
+			title: "unsupported",
+			resp: func(t *testing.T) *http.Response {
+				return newResponse(t, []byte(`{"foo":"bar"}`), "x-unknown")
+			},
+			wantErr: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := te.resp(t)
+			body := &closeRecorder{ReadCloser: resp.Body}
+			resp.Body = body
+			var got value
+			err := decompress.DecodeJSON(resp, &got)
+			if !body.closed {
+				t.Error("body is not closed")
+			}
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := te.want; got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+	t.Run("error wrapping", func(t *testing.T) {
+		var v value
+		err := decompress.DecodeJSON(newResponse(t, gzipBytes([]byte(`{"foo":`)), "gzip"), &v)
+		if got, want := errors.Is(err, io.ErrUnexpectedEOF), true; got != want {
+			t.Errorf("errors.Is(%v, io.ErrUnexpectedEOF) got %v, want %v", err, got, want)
+		}
+	})
+}
+
+func TestDecodeXML(t *testing.T) {
+	type value struct {
+		Foo string `xml:"foo"`
+	}
+	tt := []struct {
+		title           string
+		body            []byte
+		contentEncoding string
+		want            value
+		wantErr         string
+	}{
+		{title: "compressed", body: gzipBytes([]byte("<v><foo>bar</foo></v>")), contentEncoding: "gzip", want: value{Foo: "bar"}},
+		{title: "chain", body: gzipBytes(deflateBytes([]byte("<v><foo>bar</foo></v>"))), contentEncoding: "deflate, gzip", want: value{Foo: "bar"}},
+		{title: "not compressed", body: []byte("<v><foo>bar</foo></v>"), want: value{Foo: "bar"}},
+		{title: "invalid XML", body: gzipBytes([]byte("<v><foo>")), contentEncoding: "deflate, gzip", wantErr: "decompress: decode `deflate, gzip` body: "},
+		{title: "corrupted", body: []byte("<v><foo>bar</foo></v>"), contentEncoding: "gzip", wantErr: "decompress: decode `gzip` body: "},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			resp := newResponse(t, te.body, te.contentEncoding)
+			body := &closeRecorder{ReadCloser: resp.Body}
+			resp.Body = body
+			var got value
+			err := decompress.DecodeXML(resp, &got)
+			if !body.closed {
+				t.Error("body is not closed")
+			}
+			if te.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), te.wantErr) {
+					t.Errorf("got %v, want error prefixed with %q", err, te.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := te.want; got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func init() {
+	decompress.RegisterUnmarshaler("Text/Plain", func(r io.Reader, v any) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		p, ok := v.(*string)
+		if !ok {
+			return fmt.Errorf("can not decode text into %T", v)
+		}
+		*p = string(b)
+		return nil
+	})
+}
+
+func TestDecodeInto(t *testing.T) {
+	type value struct {
+		Foo string `json:"foo" xml:"foo"`
+	}
+	t.Run("struct", func(t *testing.T) {
+		tt := []struct {
+			title       string
+			contentType string
+			body        []byte
+			want        value
+			wantErr     bool
+		}{
+			{title: "json", contentType: "application/json; charset=utf-8", body: []byte(`{"foo":"bar"}`), want: value{Foo: "bar"}},
+			{title: "json suffix", contentType: "application/problem+json", body: []byte(`{"foo":"bar"}`), want: value{Foo: "bar"}},
+			{title: "xml", contentType: "text/xml", body: []byte("<v><foo>bar</foo></v>"), want: value{Foo: "bar"}},
+			{title: "xml suffix", contentType: "application/atom+xml", body: []byte("<v><foo>bar</foo></v>"), want: value{Foo: "bar"}},
+			{title: "unsupported media type", contentType: "application/octet-stream", body: []byte(`{"foo":"bar"}`), wantErr: true},
+			{title: "no content type", body: []byte(`{"foo":"bar"}`), wantErr: true},
+			{title: "form into struct", contentType: "application/x-www-form-urlencoded", body: []byte("foo=bar"), wantErr: true},
+		}
+		for i, te := range tt {
+			t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+				resp := newResponse(t, gzipBytes(te.body), "gzip")
+				if te.contentType != "" {
+					resp.Header.Set("Content-Type", te.contentType)
+				}
+				body := &closeRecorder{ReadCloser: resp.Body}
+				resp.Body = body
+				got, err := decompress.DecodeInto[value](resp)
+				if !body.closed {
+					t.Error("body is not closed")
+				}
+				if te.wantErr {
+					if err == nil {
+						t.Error("got nil, want error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := te.want; got != want {
+					t.Errorf("got %+v, want %+v", got, want)
+				}
+			})
+		}
+	})
+	t.Run("form", func(t *testing.T) {
+		resp := newResponse(t, gzipBytes([]byte("foo=bar&foo=baz")), "gzip")
+		resp.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		got, err := decompress.DecodeInto[url.Values](resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Join(got["foo"], ","), "bar,baz"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+	t.Run("registered", func(t *testing.T) {
+		resp := newResponse(t, gzipBytes([]byte("foobarbaz")), "gzip")
+		resp.Header.Set("Content-Type", "text/plain")
+		got, err := decompress.DecodeInto[string](resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "foobarbaz"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+type closeRecorder struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,409 @@
+package decompress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+)
+
+// vcdiff implements a reader for the `vcdiff` delta encoding. Refs RFC 3284
+// Secondary compressors and application-defined code tables are not supported.
+
+const (
+	vcdDecompress = 0x01
+	vcdCodeTable  = 0x02
+	vcdAppHeader  = 0x04 // xdelta3 extension
+
+	vcdSource  = 0x01
+	vcdTarget  = 0x02
+	vcdAdler32 = 0x04 // xdelta3 extension
+
+	vcdNoop = 0
+	vcdAdd  = 1
+	vcdRun  = 2
+	vcdCopy = 3
+
+	vcdSelf = 0
+	vcdHere = 1
+
+	vcdNearSize = 4
+	vcdSameSize = 3
+
+	// vcdMaxWindowSize limits the size of a window to protect against a malicious header
+	vcdMaxWindowSize = 1 << 26
+	// vcdMaxTargetHistory is the size of the decoded data retained for the VCD_TARGET windows, so that the memory of
+	// a long stream is bounded. The windows referring to the data before it are rejected
+	vcdMaxTargetHistory = 1 << 25
+)
+
+var (
+	vcdiffMagic      = []byte{0xd6, 0xc3, 0xc4}
+	errVCDIFFCorrupt = errors.New("decompress: corrupt vcdiff data")
+)
+
+type vcdInstruction struct {
+	typ  byte
+	size int
+	mode byte
+}
+
+// vcdCodeTableDefault is the default instruction code table. Refs RFC 3284 Section 5.6
+var vcdCodeTableDefault = func() (table [256][2]vcdInstruction) {
+	i := 0
+	table[i][0] = vcdInstruction{typ: vcdRun}
+	i++
+	for size := 0; size <= 17; size++ {
+		table[i][0] = vcdInstruction{typ: vcdAdd, size: size}
+		i++
+	}
+	for mode := byte(0); mode <= 8; mode++ {
+		table[i][0] = vcdInstruction{typ: vcdCopy, mode: mode}
+		i++
+		for size := 4; size <= 18; size++ {
+			table[i][0] = vcdInstruction{typ: vcdCopy, size: size, mode: mode}
+			i++
+		}
+	}
+	for mode := byte(0); mode <= 5; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			for copySize := 4; copySize <= 6; copySize++ {
+				table[i] = [2]vcdInstruction{{typ: vcdAdd, size: addSize}, {typ: vcdCopy, size: copySize, mode: mode}}
+				i++
+			}
+		}
+	}
+	for mode := byte(6); mode <= 8; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			table[i] = [2]vcdInstruction{{typ: vcdAdd, size: addSize}, {typ: vcdCopy, size: 4, mode: mode}}
+			i++
+		}
+	}
+	for mode := byte(0); mode <= 8; mode++ {
+		table[i] = [2]vcdInstruction{{typ: vcdCopy, size: 4, mode: mode}, {typ: vcdAdd, size: 1}}
+		i++
+	}
+	return table
+}()
+
+type vcdiffReader struct {
+	r    *bufio.Reader
+	base []byte
+	// target is the tail of the decoded data from the offset targetOff, that VCD_TARGET windows may refer to.
+	// At least the last history bytes are retained
+	target    []byte
+	targetOff int
+	history   int
+	out       []byte
+	err       error
+}
+
+// newVCDIFFReader reads the header of the vcdiff format and returns the reader for the data reconstructed from base
+func newVCDIFFReader(r io.Reader, base []byte) (*vcdiffReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if string(header[:3]) != string(vcdiffMagic) || header[3] != 0 {
+		return nil, errors.New("decompress: invalid vcdiff header")
+	}
+	indicator := header[4]
+	if indicator&vcdDecompress != 0 {
+		return nil, errors.New("decompress: vcdiff secondary compressor is not supported")
+	}
+	if indicator&vcdCodeTable != 0 {
+		return nil, errors.New("decompress: vcdiff application-defined code table is not supported")
+	}
+	if indicator&vcdAppHeader != 0 {
+		n, err := readVCDInt(br)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := br.Discard(n); err != nil {
+			return nil, err
+		}
+	}
+	return &vcdiffReader{r: br, base: base, history: vcdMaxTargetHistory}, nil
+}
+
+func (v *vcdiffReader) Read(p []byte) (int, error) {
+	for len(v.out) == 0 && v.err == nil {
+		v.decodeWindow()
+	}
+	if len(v.out) > 0 {
+		n := copy(p, v.out)
+		v.out = v.out[n:]
+		return n, nil
+	}
+	return 0, v.err
+}
+
+// decodeWindow decodes a window and stores the resulting bytes into v.out
+func (v *vcdiffReader) decodeWindow() {
+	indicator, err := v.r.ReadByte()
+	if err != nil {
+		v.err = err
+		return
+	}
+	if err := v.decodeWindowBody(indicator); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		v.err = err
+	}
+}
+
+func (v *vcdiffReader) decodeWindowBody(indicator byte) error {
+	var source []byte
+	if indicator&vcdSource != 0 && indicator&vcdTarget != 0 {
+		// Refs RFC 3284 Section 4.2
+		return errVCDIFFCorrupt
+	}
+	if indicator&(vcdSource|vcdTarget) != 0 {
+		size, err := readVCDInt(v.r)
+		if err != nil {
+			return err
+		}
+		pos, err := readVCDInt(v.r)
+		if err != nil {
+			return err
+		}
+		from := v.base
+		if indicator&vcdTarget != 0 {
+			if pos < v.targetOff {
+				return fmt.Errorf("decompress: vcdiff target segment before the last %d bytes", v.history)
+			}
+			from, pos = v.target, pos-v.targetOff
+		}
+		if pos > len(from) || size > len(from)-pos {
+			return fmt.Errorf("decompress: vcdiff source segment out of range")
+		}
+		source = from[pos : pos+size]
+	}
+	var lengths [5]int // delta encoding, target window, data, instructions, addresses
+	for i := range lengths {
+		n, err := readVCDInt(v.r)
+		if err != nil {
+			return err
+		}
+		lengths[i] = n
+		if i == 1 {
+			deltaIndicator, err := v.r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if deltaIndicator != 0 {
+				return errors.New("decompress: vcdiff secondary compressor is not supported")
+			}
+		}
+	}
+	for _, n := range lengths[1:] {
+		if n > vcdMaxWindowSize {
+			return fmt.Errorf("decompress: vcdiff window too large %d", n)
+		}
+	}
+	var checksum []byte
+	if indicator&vcdAdler32 != 0 {
+		checksum = make([]byte, 4)
+		if _, err := io.ReadFull(v.r, checksum); err != nil {
+			return err
+		}
+	}
+	// the buffers grow as the data arrives, instead of being allocated by the sizes in the header
+	total := lengths[2] + lengths[3] + lengths[4]
+	sections, err := io.ReadAll(io.LimitReader(v.r, int64(total)))
+	if err != nil {
+		return err
+	}
+	if len(sections) < total {
+		return io.ErrUnexpectedEOF
+	}
+	d := &vcdWindowDecoder{
+		source: source,
+		size:   lengths[1],
+		target: make([]byte, 0, min(lengths[1], len(source)+total)),
+		data:   sections[:lengths[2]],
+		inst:   sections[lengths[2] : lengths[2]+lengths[3]],
+		addr:   sections[lengths[2]+lengths[3]:],
+	}
+	if err := d.decode(); err != nil {
+		return err
+	}
+	if len(d.target) != lengths[1] {
+		return errVCDIFFCorrupt
+	}
+	if checksum != nil && adler32.Checksum(d.target) != binary.BigEndian.Uint32(checksum) {
+		return errors.New("decompress: vcdiff window checksum mismatch")
+	}
+	v.target = append(v.target, d.target...)
+	// discarded in bulk, so that the retained data is not copied per window
+	if len(v.target) > 2*v.history {
+		n := len(v.target) - v.history
+		v.target = append(v.target[:0], v.target[n:]...)
+		v.targetOff += n
+	}
+	v.out = d.target
+	return nil
+}
+
+type vcdWindowDecoder struct {
+	source []byte
+	// size is the size of the target window
+	size   int
+	target []byte
+	data   []byte
+	inst   []byte
+	addr   []byte
+	near   [vcdNearSize]int
+	next   int
+	same   [vcdSameSize * 256]int
+}
+
+func (d *vcdWindowDecoder) decode() error {
+	for len(d.inst) > 0 {
+		code := d.inst[0]
+		d.inst = d.inst[1:]
+		for _, inst := range vcdCodeTableDefault[code] {
+			if inst.typ == vcdNoop {
+				continue
+			}
+			size := inst.size
+			if size == 0 {
+				n, err := vcdInt(&d.inst)
+				if err != nil {
+					return err
+				}
+				size = n
+			}
+			if size > d.size-len(d.target) {
+				return errVCDIFFCorrupt
+			}
+			if err := d.execute(inst, size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *vcdWindowDecoder) execute(inst vcdInstruction, size int) error {
+	switch inst.typ {
+	case vcdAdd:
+		if size > len(d.data) {
+			return errVCDIFFCorrupt
+		}
+		d.target = append(d.target, d.data[:size]...)
+		d.data = d.data[size:]
+	case vcdRun:
+		if len(d.data) == 0 {
+			return errVCDIFFCorrupt
+		}
+		b := d.data[0]
+		d.data = d.data[1:]
+		for i := 0; i < size; i++ {
+			d.target = append(d.target, b)
+		}
+	case vcdCopy:
+		here := len(d.source) + len(d.target)
+		addr, err := d.decodeAddress(here, inst.mode)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			// byte by byte, since the copy can overlap the data being decoded
+			if a := addr + i; a < len(d.source) {
+				d.target = append(d.target, d.source[a])
+			} else {
+				d.target = append(d.target, d.target[a-len(d.source)])
+			}
+		}
+	}
+	return nil
+}
+
+func (d *vcdWindowDecoder) decodeAddress(here int, mode byte) (int, error) {
+	var addr int
+	switch {
+	case mode == vcdSelf:
+		n, err := vcdInt(&d.addr)
+		if err != nil {
+			return 0, err
+		}
+		addr = n
+	case mode == vcdHere:
+		n, err := vcdInt(&d.addr)
+		if err != nil {
+			return 0, err
+		}
+		addr = here - n
+	case int(mode) < 2+vcdNearSize:
+		n, err := vcdInt(&d.addr)
+		if err != nil {
+			return 0, err
+		}
+		addr = d.near[mode-2] + n
+	default:
+		if len(d.addr) == 0 {
+			return 0, errVCDIFFCorrupt
+		}
+		addr = d.same[int(mode-2-vcdNearSize)*256+int(d.addr[0])]
+		d.addr = d.addr[1:]
+	}
+	// validated before the caches are updated, since the address indexes the same cache
+	if addr < 0 || addr >= here {
+		return 0, errVCDIFFCorrupt
+	}
+	d.near[d.next] = addr
+	d.next = (d.next + 1) % vcdNearSize
+	d.same[addr%(vcdSameSize*256)] = addr
+	return addr, nil
+}
+
+// readVCDInt reads a variable-length integer. Refs RFC 3284 Section 2
+func readVCDInt(r io.ByteReader) (int, error) {
+	var n int
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			if n > 1<<31-1 {
+				return 0, errVCDIFFCorrupt
+			}
+			return n, nil
+		}
+	}
+	return 0, errVCDIFFCorrupt
+}
+
+// vcdInt reads a variable-length integer from the head of b, and advances b
+func vcdInt(b *[]byte) (int, error) {
+	br := bytesByteReader{b: *b}
+	n, err := readVCDInt(&br)
+	if err != nil {
+		return 0, errVCDIFFCorrupt
+	}
+	*b = br.b
+	return n, nil
+}
+
+type bytesByteReader struct {
+	b []byte
+}
+
+func (r *bytesByteReader) ReadByte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c, nil
+}
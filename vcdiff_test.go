@@ -0,0 +1,362 @@
+package decompress_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func TestRoundTripper_RoundTrip_VCDIFF(t *testing.T) {
+	base := []byte("The quick brown fox jumps over the lazy dog. foobarbaz foobarbaz.")
+	target := []byte("The quick red fox jumps over the lazy dog!! foobarbaz foobarbaz foobarbaz. aaaaaaaaaaaa")
+	second := []byte("foobarbaz The quick red fox, again and again and again")
+	vcdiffHeader := []byte{0xd6, 0xc3, 0xc4, 0x00, 0x00}
+	delta := concat(vcdiffHeader, vcdiffWindow(0x01, base, 0, target))
+	deltaBase := func(res *http.Response) ([]byte, error) {
+		return base, nil
+	}
+	tt := []struct {
+		title                      string
+		body                       []byte
+		deltaBase                  func(*http.Response) ([]byte, error)
+		wantBody                   string
+		wantErr                    bool
+		wantErrUnsupportedEncoding bool
+	}{
+		{
+			title:     "single window",
+			body:      delta,
+			deltaBase: deltaBase,
+			wantBody:  string(target),
+		},
+		{
+			title: "target window",
+			body: concat(
+				vcdiffHeader,
+				vcdiffWindow(0x01, base, 0, target),
+				vcdiffWindow(0x02, target, 0, second),
+			),
+			deltaBase: deltaBase,
+			wantBody:  string(target) + string(second),
+		},
+		{
+			title:     "source and target",
+			body:      concat(vcdiffHeader, vcdiffWindow(0x03, base, 0, target)),
+			deltaBase: deltaBase,
+			wantErr:   true,
+		},
+		{
+			title:     "without source",
+			body:      concat(vcdiffHeader, vcdiffWindow(0x00, nil, 0, target)),
+			deltaBase: deltaBase,
+			wantBody:  string(target),
+		},
+		{
+			title: "COPY and ADD instruction",
+			body: concat(
+				vcdiffHeader,
+				// source segment size 65, position 0, delta encoding length 8, target window size 5,
+				// data length 1, instructions length 1, addresses length 1,
+				// data "X", instruction COPY 4 mode 0 + ADD 1, address 0
+				[]byte{0x01, 0x41, 0x00, 0x08, 0x05, 0x00, 0x01, 0x01, 0x01, 'X', 247, 0x00},
+			),
+			deltaBase: deltaBase,
+			wantBody:  "The X",
+		},
+		{
+			title:     "source segment out of range",
+			body:      concat(vcdiffHeader, vcdiffWindow(0x01, append(base, "extra"...), 0, target)),
+			deltaBase: deltaBase,
+			wantErr:   true,
+		},
+		{
+			title:     "truncated",
+			body:      delta[:len(delta)-5],
+			deltaBase: deltaBase,
+			wantErr:   true,
+		},
+		{
+			title: "delta base error",
+			body:  delta,
+			deltaBase: func(res *http.Response) ([]byte, error) {
+				return nil, errors.New("not found")
+			},
+			wantErr: true,
+		},
+		{
+			title:                      "no delta base",
+			body:                       delta,
+			wantErrUnsupportedEncoding: true,
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap:      &stubRoundTripper{response: newResponse(t, te.body, "vcdiff")},
+				DeltaBase: te.deltaBase,
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if te.wantErrUnsupportedEncoding {
+				var wantErr *decompress.ErrUnsupportedEncoding
+				if !errors.As(err, &wantErr) {
+					t.Errorf("got %T %v, want ErrUnsupportedEncoding", err, err)
+				}
+				return
+			}
+			if err != nil {
+				if !te.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_VCDIFF_Malformed(t *testing.T) {
+	vcdiffHeader := []byte{0xd6, 0xc3, 0xc4, 0x00, 0x00}
+	tt := []struct {
+		title string
+		body  []byte
+	}{
+		{
+			title: "negative HERE address",
+			// no source, delta encoding length 9, target window size 5, data length 1, instructions length 2,
+			// addresses length 1, data "X", instructions ADD 1 + COPY 4 mode HERE, address 5 that is before the window
+			body: concat(vcdiffHeader, []byte{0x00, 0x09, 0x05, 0x00, 0x01, 0x02, 0x01, 'X', 2, 36, 5}),
+		},
+		{
+			title: "large sizes without data",
+			// the window, data, instructions and addresses lengths of 1<<26-1
+			body: concat(vcdiffHeader, []byte{0x00, 0x9f, 0xff, 0xff, 0x7f, 0x9f, 0xff, 0xff, 0x7f, 0x00,
+				0x9f, 0xff, 0xff, 0x7f, 0x9f, 0xff, 0xff, 0x7f, 0x9f, 0xff, 0xff, 0x7f, 'X'}),
+		},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			dr := decompress.RoundTripper{
+				Wrap: &stubRoundTripper{response: newResponse(t, te.body, "vcdiff")},
+				DeltaBase: func(res *http.Response) ([]byte, error) {
+					return nil, nil
+				},
+			}
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, err := dr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			runtime.ReadMemStats(&after)
+			if err == nil {
+				t.Fatal("got nil, want error")
+			}
+			var panicErr *decompress.ErrDecoderPanic
+			if errors.As(err, &panicErr) {
+				t.Errorf("got %v, want the error without panic", err)
+			}
+			if got := after.TotalAlloc - before.TotalAlloc; got > 1<<20 {
+				t.Errorf("allocated %d bytes, want less than 1 MiB", got)
+			}
+		})
+	}
+}
+
+func TestVCDIFFReader_TargetHistory(t *testing.T) {
+	vcdiffHeader := []byte{0xd6, 0xc3, 0xc4, 0x00, 0x00}
+	first := []byte("The quick brown fox jumps")
+	second := []byte(" over the lazy dog. foobarbaz")
+	third := []byte("foobarbaz the lazy dog")
+	decoded := concat(first, second)
+	tt := []struct {
+		title   string
+		pos     int
+		wantErr bool
+	}{
+		{title: "retained", pos: len(decoded) - 16},
+		{title: "discarded", pos: 0, wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			body := concat(
+				vcdiffHeader,
+				vcdiffWindow(0x00, nil, 0, first),
+				vcdiffWindow(0x00, nil, 0, second),
+				vcdiffWindow(0x02, decoded[te.pos:te.pos+16], te.pos, third),
+			)
+			// the decoded data exceeds twice the history at the second window
+			r, err := decompress.NewVCDIFFReader(bytes.NewReader(body), nil, 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), string(concat(decoded, third)); got != want {
+				t.Errorf("body got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func concat(b ...[]byte) []byte {
+	return bytes.Join(b, nil)
+}
+
+// vcdiffWindow encodes a vcdiff window with the default code table, that reconstructs target from source.
+// source is used as the source segment at the position pos, unless indicator is 0
+func vcdiffWindow(indicator byte, source []byte, pos int, target []byte) []byte {
+	if indicator == 0 {
+		source = nil
+	}
+	var (
+		data, inst, addrs bytes.Buffer
+		near              [4]int
+		nextNear          int
+		same              [3 * 256]int
+		pending           []byte
+	)
+	combined := append(append([]byte{}, source...), target...)
+	encodeAddress := func(addr, here int) (mode byte, enc []byte) {
+		mode, enc = 0, vcdiffInt(addr)
+		if e := vcdiffInt(here - addr); len(e) < len(enc) {
+			mode, enc = 1, e
+		}
+		for i, n := range near {
+			if addr >= n {
+				if e := vcdiffInt(addr - n); len(e) < len(enc) {
+					mode, enc = byte(2+i), e
+				}
+			}
+		}
+		if same[addr%len(same)] == addr {
+			mode, enc = byte(6+addr%len(same)/256), []byte{byte(addr % 256)}
+		}
+		near[nextNear] = addr
+		nextNear = (nextNear + 1) % len(near)
+		same[addr%len(same)] = addr
+		return mode, enc
+	}
+	flushAdd := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if len(pending) <= 17 {
+			inst.WriteByte(byte(1 + len(pending)))
+		} else {
+			inst.WriteByte(1)
+			inst.Write(vcdiffInt(len(pending)))
+		}
+		data.Write(pending)
+		pending = nil
+	}
+	for p := 0; p < len(target); {
+		run := 1
+		for p+run < len(target) && target[p+run] == target[p] {
+			run++
+		}
+		if run >= 8 {
+			flushAdd()
+			inst.WriteByte(0)
+			inst.Write(vcdiffInt(run))
+			data.WriteByte(target[p])
+			p += run
+			continue
+		}
+		here := len(source) + p
+		bestAddr, bestLen := 0, 0
+		for a := 0; a < here; a++ {
+			n := 0
+			for p+n < len(target) && combined[a+n] == target[p+n] {
+				n++
+			}
+			if n > bestLen {
+				bestAddr, bestLen = a, n
+			}
+		}
+		if bestLen < 4 {
+			pending = append(pending, target[p])
+			p++
+			continue
+		}
+		mode, enc := encodeAddress(bestAddr, here)
+		switch {
+		case len(pending) >= 1 && len(pending) <= 4 && bestLen <= 6 && mode <= 5:
+			inst.WriteByte(byte(163 + int(mode)*12 + (len(pending)-1)*3 + (bestLen - 4)))
+			data.Write(pending)
+			pending = nil
+		case len(pending) >= 1 && len(pending) <= 4 && bestLen == 4:
+			inst.WriteByte(byte(235 + int(mode-6)*4 + (len(pending) - 1)))
+			data.Write(pending)
+			pending = nil
+		default:
+			flushAdd()
+			if bestLen <= 18 {
+				inst.WriteByte(byte(19 + int(mode)*16 + (bestLen - 3)))
+			} else {
+				inst.WriteByte(byte(19 + int(mode)*16))
+				inst.Write(vcdiffInt(bestLen))
+			}
+		}
+		addrs.Write(enc)
+		p += bestLen
+	}
+	flushAdd()
+
+	var delta bytes.Buffer
+	delta.Write(vcdiffInt(len(target)))
+	delta.WriteByte(0)
+	delta.Write(vcdiffInt(data.Len()))
+	delta.Write(vcdiffInt(inst.Len()))
+	delta.Write(vcdiffInt(addrs.Len()))
+	delta.Write(data.Bytes())
+	delta.Write(inst.Bytes())
+	delta.Write(addrs.Bytes())
+
+	var window bytes.Buffer
+	window.WriteByte(indicator)
+	if indicator != 0 {
+		window.Write(vcdiffInt(len(source)))
+		window.Write(vcdiffInt(pos))
+	}
+	window.Write(vcdiffInt(delta.Len()))
+	window.Write(delta.Bytes())
+	return window.Bytes()
+}
+
+func vcdiffInt(n int) []byte {
+	b := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		b = append([]byte{byte(n&0x7f) | 0x80}, b...)
+	}
+	return b
+}
@@ -0,0 +1,42 @@
+// Package xz provides the decoders for the `xz` and `lzma` content codings using github.com/ulikunitz/xz.
+// Importing the package registers the decoders by decompress.RegisterDecoder:
+//
+//	import _ "github.com/kei2100/decompress-roundtripper/xz"
+package xz
+
+import (
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func init() {
+	decompress.RegisterDecoder("xz", NewFactory())
+	decompress.RegisterDecoder("lzma", NewLZMAFactory())
+}
+
+// NewFactory returns the factory of the `xz` decoders.
+// Since the xz readers can not be reset, Reset of the decoders creates a new reader
+func NewFactory() decompress.DecoderFactory {
+	return decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	})
+}
+
+// NewLZMAFactory returns the factory of the `lzma` decoders, that decode the classic LZMA format (.lzma).
+// Since the lzma readers can not be reset, Reset of the decoders creates a new reader
+func NewLZMAFactory() decompress.DecoderFactory {
+	return decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+		lr, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(lr), nil
+	})
+}
@@ -0,0 +1,51 @@
+package xz_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper/codectest"
+	dxz "github.com/kei2100/decompress-roundtripper/xz"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestNewFactory_Conformance(t *testing.T) {
+	codectest.Suite{
+		Factory: dxz.NewFactory(),
+		Encode: func(b []byte) ([]byte, error) {
+			var dst bytes.Buffer
+			w, err := xz.NewWriter(&dst)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(b); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return dst.Bytes(), nil
+		},
+	}.Run(t)
+}
+
+func TestNewLZMAFactory_Conformance(t *testing.T) {
+	codectest.Suite{
+		Factory: dxz.NewLZMAFactory(),
+		Encode: func(b []byte) ([]byte, error) {
+			var dst bytes.Buffer
+			w, err := lzma.NewWriter(&dst)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(b); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return dst.Bytes(), nil
+		},
+	}.Run(t)
+}
@@ -0,0 +1,84 @@
+package zstd_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+	dzstd "github.com/kei2100/decompress-roundtripper/zstd"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNewDCZFactory(t *testing.T) {
+	dict := []byte("foobarbaz is the dictionary content for foobarbaz")
+	otherDict := []byte("another dictionary")
+	provider := decompress.DictionaryProviderFunc(func(hash [sha256.Size]byte) ([]byte, error) {
+		switch hash {
+		case sha256.Sum256(dict):
+			return dict, nil
+		case sha256.Sum256(otherDict):
+			// wrong dictionary for the hash
+			return dict, nil
+		}
+		return nil, errors.New("not found")
+	})
+	tt := []struct {
+		title    string
+		body     []byte
+		wantBody string
+		wantErr  bool
+	}{
+		{
+			title:    "ok",
+			body:     dczBytes([]byte("foobarbaz"), dict),
+			wantBody: "foobarbaz",
+		},
+		{
+			title:   "dictionary not found",
+			body:    dczBytes([]byte("foobarbaz"), []byte("unknown")),
+			wantErr: true,
+		},
+		{
+			title:   "dictionary hash mismatch",
+			body:    dczBytes([]byte("foobarbaz"), otherDict),
+			wantErr: true,
+		},
+		{
+			title:   "invalid header",
+			body:    zstdBytes([]byte("foobarbaz")),
+			wantErr: true,
+		},
+	}
+	f, err := dzstd.NewDCZFactory(provider, dzstd.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			b, err := decode(f, te.body)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), te.wantBody; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func dczBytes(b, dict []byte) []byte {
+	hash := sha256.Sum256(dict)
+	dst := bytes.NewBuffer([]byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00})
+	dst.Write(hash[:])
+	dst.Write(zstdBytes(b, zstd.WithEncoderDictRaw(0, dict)))
+	return dst.Bytes()
+}
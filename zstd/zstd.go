@@ -0,0 +1,90 @@
+// Package zstd provides the decoders for the `zstd` content coding (RFC 8878) and the `dcz` content coding of
+// the Compression Dictionary Transport (RFC 9842).
+// Importing the package registers the `zstd` decoder with the default options by decompress.RegisterDecoder,
+// and advertises `zstd` by decompress.SetPreference:
+//
+//	import _ "github.com/kei2100/decompress-roundtripper/zstd"
+//
+// Use NewFactory with RoundTripper.Decoders to customize the options, and NewDCZFactory to enable `dcz`.
+// The decoders use github.com/klauspost/compress/zstd by default, or github.com/DataDog/zstd (libzstd)
+// with the czstd build tag.
+package zstd
+
+import (
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+func init() {
+	decompress.SetPreference("zstd", 1)
+	decompress.RegisterDecoder("zstd", &factory{})
+}
+
+// Options is the options for the zstd decoder.
+// The libzstd backend (czstd build tag) supports only Dictionaries, and the factories return an error if the other
+// options are set, so that the limits are not silently ignored
+type Options struct {
+	// Dictionaries is the zstd dictionaries keyed by the dictionary ID.
+	// Compressed responses that reference a dictionary ID in the frame header are decoded with the corresponding dictionary.
+	// Each dictionary can be either in the zstd dictionary format (e.g. created by `zstd --train`) or raw content.
+	// Raw content dictionaries are not supported by the libzstd backend (czstd build tag)
+	Dictionaries map[uint32][]byte
+	// MaxWindowSize is the maximum window size of the frames. Frames requiring a larger window are rejected.
+	// RFC 8878 recommends 8MB for the `zstd` content coding. If 0, the default of github.com/klauspost/compress/zstd is used
+	MaxWindowSize uint64
+	// MaxMemory is the maximum memory the decoder may allocate for a frame. If 0, no limit other than MaxWindowSize is applied
+	MaxMemory uint64
+	// LowMemory makes the decoder allocate less memory at the cost of speed
+	LowMemory bool
+	// Concurrency is the number of goroutines the decoder uses. If 0, the default of github.com/klauspost/compress/zstd is used.
+	// Use 1 to decode synchronously without any goroutines
+	Concurrency int
+}
+
+// NewFactory returns the factory of the `zstd` decoders with the options.
+// It returns an error if the options are not supported by the backend
+func NewFactory(o Options) (decompress.DecoderFactory, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return &factory{opts: o}, nil
+}
+
+type factory struct {
+	opts Options
+}
+
+func (f *factory) NewDecoder(r io.Reader) (decompress.Decoder, error) {
+	return newDecoder(r, &f.opts, nil)
+}
+
+// MemoryEstimate implements decompress.MemoryEstimator. It is MaxMemory or MaxWindowSize if set,
+// or the window size of 8MB recommended for the `zstd` content coding
+func (f *factory) MemoryEstimate() int64 {
+	switch {
+	case f.opts.MaxMemory > 0:
+		return int64(f.opts.MaxMemory)
+	case f.opts.MaxWindowSize > 0:
+		return int64(f.opts.MaxWindowSize)
+	}
+	return 8 << 20
+}
+
+// dczMagic is the header of the `dcz` content coding, that is a zstd skippable frame containing the dictionary hash
+var dczMagic = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+
+// NewDCZFactory returns the factory of the `dcz` decoders, that decode the dictionary-compressed zstd streams
+// with the dictionaries provided by p. It returns an error if the options are not supported by the backend
+func NewDCZFactory(p decompress.DictionaryProvider, o Options) (decompress.DecoderFactory, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+		dict, err := decompress.ReadDictionary(r, dczMagic, p)
+		if err != nil {
+			return nil, err
+		}
+		return newDecoder(r, &o, dict)
+	}), nil
+}
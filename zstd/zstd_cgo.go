@@ -0,0 +1,70 @@
+//go:build cgo && czstd
+
+package zstd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/DataDog/zstd"
+	"github.com/kei2100/decompress-roundtripper"
+)
+
+// newDecoder returns the decoder using github.com/DataDog/zstd (libzstd).
+// Since the libzstd reader can not be reset, Reset of the decoder creates a new reader
+func newDecoder(r io.Reader, o *Options, rawDict []byte) (decompress.Decoder, error) {
+	return decompress.DecoderFunc(func(r io.Reader) (io.ReadCloser, error) {
+		return newReader(r, o, rawDict)
+	}).NewDecoder(r)
+}
+
+// validate returns an error if o has the options other than Dictionaries, that libzstd does not support
+func (o *Options) validate() error {
+	if o.MaxWindowSize > 0 || o.MaxMemory > 0 || o.LowMemory || o.Concurrency > 0 {
+		return errors.New("decompress: zstd MaxWindowSize, MaxMemory, LowMemory and Concurrency are not supported by the libzstd backend")
+	}
+	return nil
+}
+
+// newReader returns the libzstd reader.
+// If rawDict is not nil, it is used as the raw content dictionary for frames without the dictionary ID.
+// Otherwise the dictionary is chosen from o.Dictionaries by the dictionary ID of the first frame header
+func newReader(r io.Reader, o *Options, rawDict []byte) (io.ReadCloser, error) {
+	if rawDict != nil {
+		return zstd.NewReaderDict(r, rawDict), nil
+	}
+	if len(o.Dictionaries) == 0 {
+		return zstd.NewReader(r), nil
+	}
+	br := bufio.NewReader(r)
+	if dict, ok := o.Dictionaries[peekDictID(br)]; ok {
+		return zstd.NewReaderDict(br, dict), nil
+	}
+	return zstd.NewReader(br), nil
+}
+
+// peekDictID returns the dictionary ID in the zstd frame header, or 0 if the frame has no dictionary ID.
+// Refs RFC 8878 Section 3.1.1.1
+func peekDictID(br *bufio.Reader) uint32 {
+	h, _ := br.Peek(4 + 1 + 1 + 4)
+	if len(h) < 5 || binary.LittleEndian.Uint32(h) != 0xfd2fb528 {
+		return 0
+	}
+	descriptor := h[4]
+	offset := 5
+	if descriptor&0x20 == 0 {
+		// window descriptor
+		offset++
+	}
+	size := [4]int{0, 1, 2, 4}[descriptor&0x03]
+	if len(h) < offset+size {
+		return 0
+	}
+	var id uint32
+	for i := size - 1; i >= 0; i-- {
+		id = id<<8 | uint32(h[offset+i])
+	}
+	return id
+}
@@ -0,0 +1,46 @@
+//go:build cgo && czstd
+
+package zstd_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+	dzstd "github.com/kei2100/decompress-roundtripper/zstd"
+)
+
+// github.com/DataDog/zstd returns io.EOF for a truncated frame
+const skipTruncated = true
+
+func TestNewFactory_UnsupportedOptions(t *testing.T) {
+	provider := decompress.DictionaryProviderFunc(func(hash [sha256.Size]byte) ([]byte, error) {
+		return nil, errors.New("not found")
+	})
+	tt := []struct {
+		title   string
+		opts    dzstd.Options
+		wantErr bool
+	}{
+		{title: "defaults", opts: dzstd.Options{}},
+		{title: "dictionaries", opts: dzstd.Options{Dictionaries: map[uint32][]byte{42: []byte("foobarbaz")}}},
+		{title: "max window size", opts: dzstd.Options{MaxWindowSize: 8 << 20}, wantErr: true},
+		{title: "max memory", opts: dzstd.Options{MaxMemory: 1 << 20}, wantErr: true},
+		{title: "low memory", opts: dzstd.Options{LowMemory: true}, wantErr: true},
+		{title: "concurrency", opts: dzstd.Options{Concurrency: 1}, wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			_, err := dzstd.NewFactory(te.opts)
+			if got, want := err != nil, te.wantErr; got != want {
+				t.Errorf("NewFactory error got %v, want error %v", err, want)
+			}
+			_, err = dzstd.NewDCZFactory(provider, te.opts)
+			if got, want := err != nil, te.wantErr; got != want {
+				t.Errorf("NewDCZFactory error got %v, want error %v", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+//go:build !cgo || !czstd
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/klauspost/compress/zstd"
+)
+
+// dictMagic is the magic number of the zstd dictionary format
+var dictMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// newDecoder returns the decoder using github.com/klauspost/compress/zstd.
+// If rawDict is not nil, it is used as the raw content dictionary for frames without the dictionary ID
+func newDecoder(r io.Reader, o *Options, rawDict []byte) (decompress.Decoder, error) {
+	opts, err := o.decoderOptions()
+	if err != nil {
+		return nil, err
+	}
+	if rawDict != nil {
+		opts = append(opts, zstd.WithDecoderDictRaw(0, rawDict))
+	}
+	zr, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{Decoder: zr}, nil
+}
+
+type decoder struct {
+	*zstd.Decoder
+}
+
+// Close releases the goroutines and the buffers of the decoder. The decoder can not be reset after Close
+func (d *decoder) Close() error {
+	d.Decoder.Close()
+	return nil
+}
+
+// validate returns nil, since github.com/klauspost/compress/zstd supports all the options.
+// The dictionaries are verified when the decoders are created
+func (o *Options) validate() error {
+	return nil
+}
+
+func (o *Options) decoderOptions() ([]zstd.DOption, error) {
+	var opts []zstd.DOption
+	if o.MaxWindowSize > 0 {
+		opts = append(opts, zstd.WithDecoderMaxWindow(o.MaxWindowSize))
+	}
+	if o.MaxMemory > 0 {
+		opts = append(opts, zstd.WithDecoderMaxMemory(o.MaxMemory))
+	}
+	if o.LowMemory {
+		opts = append(opts, zstd.WithDecoderLowmem(true))
+	}
+	if o.Concurrency > 0 {
+		opts = append(opts, zstd.WithDecoderConcurrency(o.Concurrency))
+	}
+	for id, dict := range o.Dictionaries {
+		if !bytes.HasPrefix(dict, dictMagic) {
+			opts = append(opts, zstd.WithDecoderDictRaw(id, dict))
+			continue
+		}
+		d, err := zstd.InspectDictionary(dict)
+		if err != nil {
+			return nil, fmt.Errorf("decompress: inspect zstd dictionary %d: %w", id, err)
+		}
+		if d.ID() != id {
+			return nil, fmt.Errorf("decompress: zstd dictionary ID mismatch: key %d, dictionary %d", id, d.ID())
+		}
+		opts = append(opts, zstd.WithDecoderDicts(dict))
+	}
+	return opts, nil
+}
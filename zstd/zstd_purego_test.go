@@ -0,0 +1,59 @@
+//go:build !cgo || !czstd
+
+package zstd_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	dzstd "github.com/kei2100/decompress-roundtripper/zstd"
+	"github.com/klauspost/compress/zstd"
+)
+
+const skipTruncated = false
+
+func TestNewFactory_RawDictionary(t *testing.T) {
+	dict := []byte("foobarbaz is the dictionary content for foobarbaz")
+	f := newFactory(t, dzstd.Options{Dictionaries: map[uint32][]byte{42: dict}})
+	b, err := decode(f, zstdBytes([]byte("foobarbaz"), zstd.WithEncoderDictRaw(42, dict)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "foobarbaz"; got != want {
+		t.Errorf("body got %v, want %v", got, want)
+	}
+}
+
+func TestNewFactory_Limits(t *testing.T) {
+	large := bytes.Repeat([]byte("foobarbaz"), 1<<20)
+	encoded := zstdBytes(large, zstd.WithWindowSize(16<<20))
+	tt := []struct {
+		title   string
+		opts    dzstd.Options
+		wantErr bool
+	}{
+		{title: "defaults", opts: dzstd.Options{}},
+		{title: "low memory and synchronous", opts: dzstd.Options{LowMemory: true, Concurrency: 1}},
+		{title: "window within limit", opts: dzstd.Options{MaxWindowSize: 16 << 20}},
+		{title: "window exceeds limit", opts: dzstd.Options{MaxWindowSize: 8 << 20}, wantErr: true},
+		{title: "memory exceeds limit", opts: dzstd.Options{MaxMemory: 1 << 20}, wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			b, err := decode(newFactory(t, te.opts), encoded)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, large) {
+				t.Errorf("body mismatch: got %d bytes, want %d bytes", len(b), len(large))
+			}
+		})
+	}
+}
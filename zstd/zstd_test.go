@@ -0,0 +1,105 @@
+package zstd_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/kei2100/decompress-roundtripper"
+	"github.com/kei2100/decompress-roundtripper/codectest"
+	dzstd "github.com/kei2100/decompress-roundtripper/zstd"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNewFactory_Dictionaries(t *testing.T) {
+	var contents [][]byte
+	for i := 0; i < 200; i++ {
+		contents = append(contents, []byte(fmt.Sprintf(`{"id":%d,"name":"foobarbaz","value":%d}`, i, i*i)))
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       42,
+		Contents: contents,
+		History:  bytes.Join(contents[:100], nil),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := zstdBytes([]byte("foobarbaz"), zstd.WithEncoderDict(dict))
+
+	tt := []struct {
+		title        string
+		dictionaries map[uint32][]byte
+		wantErr      bool
+	}{
+		{title: "registered", dictionaries: map[uint32][]byte{42: dict}},
+		{title: "ID mismatch", dictionaries: map[uint32][]byte{1: dict}, wantErr: true},
+		{title: "no dictionaries", wantErr: true},
+	}
+	for i, te := range tt {
+		t.Run(fmt.Sprintf("#%d %s", i, te.title), func(t *testing.T) {
+			b, err := decode(newFactory(t, dzstd.Options{Dictionaries: te.dictionaries}), encoded)
+			if te.wantErr {
+				if err == nil {
+					t.Error("got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "foobarbaz"; got != want {
+				t.Errorf("body got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewFactory_Conformance(t *testing.T) {
+	codectest.Suite{
+		Factory: newFactory(t, dzstd.Options{}),
+		Encode: func(b []byte) ([]byte, error) {
+			return zstdBytes(b), nil
+		},
+		SkipTruncated: skipTruncated,
+	}.Run(t)
+}
+
+// newFactory returns the factory of the `zstd` decoders with o
+func newFactory(t *testing.T, o dzstd.Options) decompress.DecoderFactory {
+	t.Helper()
+	f, err := dzstd.NewFactory(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// decode decodes b by the decoder created by f
+func decode(f decompress.DecoderFactory, b []byte) ([]byte, error) {
+	d, err := f.NewDecoder(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return io.ReadAll(d)
+}
+
+func zstdBytes(b []byte, opts ...zstd.EOption) []byte {
+	var dst bytes.Buffer
+	w, err := zstd.NewWriter(&dst, opts...)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return dst.Bytes()
+}